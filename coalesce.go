@@ -0,0 +1,173 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrapeCoalescer shares one collection between the scrapes with the same
+// collect[] filters arriving within a window, together with the encoded
+// responses of each format, so concurrent scrapers like HA Prometheus pairs
+// don't run every collector again.
+type scrapeCoalescer struct {
+	window time.Duration
+	now    func() time.Time
+	logger log.Logger
+
+	mtx         sync.Mutex
+	collections map[string]*collection
+}
+
+// collection is the gathered metric families of one coalesced scrape.
+type collection struct {
+	start time.Time
+	done  chan struct{}
+	mfs   []*dto.MetricFamily
+	err   error
+
+	mtx     sync.Mutex
+	encoded map[encoding][]byte
+}
+
+type encoding struct {
+	format expfmt.Format
+	gzip   bool
+}
+
+func newScrapeCoalescer(window time.Duration, logger log.Logger) *scrapeCoalescer {
+	return &scrapeCoalescer{
+		window:      window,
+		now:         time.Now,
+		logger:      logger,
+		collections: map[string]*collection{},
+	}
+}
+
+// collect returns the collection of the scrapes with key, gathering from g
+// unless a collection started less than a window ago. Scrapes arriving while
+// it is in flight wait for it.
+func (s *scrapeCoalescer) collect(key string, g prometheus.Gatherer) *collection {
+	s.mtx.Lock()
+	now := s.now()
+	if c, ok := s.collections[key]; ok && now.Sub(c.start) < s.window {
+		s.mtx.Unlock()
+		<-c.done
+		return c
+	}
+	for k, c := range s.collections {
+		if now.Sub(c.start) >= s.window {
+			delete(s.collections, k)
+		}
+	}
+	c := &collection{
+		start:   now,
+		done:    make(chan struct{}),
+		encoded: map[encoding][]byte{},
+	}
+	s.collections[key] = c
+	s.mtx.Unlock()
+
+	c.mfs, c.err = g.Gather()
+	if c.err != nil {
+		level.Error(s.logger).Log("msg", "error gathering metrics", "err", c.err)
+	}
+	close(c.done)
+	return c
+}
+
+// encode returns the collection in format, encoded once per format.
+func (c *collection) encode(e encoding) ([]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if b, ok := c.encoded[e]; ok {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if e.gzip {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	enc := expfmt.NewEncoder(w, e.format)
+	for _, mf := range c.mfs {
+		if err := enc.Encode(mf); err != nil {
+			return nil, fmt.Errorf("error encoding metric family %s: %w", mf.GetName(), err)
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	c.encoded[e] = buf.Bytes()
+	return buf.Bytes(), nil
+}
+
+// handler serves the coalesced collections of the scrapes with key. Like
+// promhttp.ContinueOnError, the metrics gathered despite errors are served.
+func (s *scrapeCoalescer) handler(key string, g prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := s.collect(key, g)
+		if c.err != nil && len(c.mfs) == 0 {
+			http.Error(w, fmt.Sprintf("An error has occurred while gathering metrics:\n\n%s", c.err), http.StatusInternalServerError)
+			return
+		}
+
+		e := encoding{format: expfmt.Negotiate(r.Header), gzip: gzipAccepted(r.Header)}
+		body, err := c.encode(e)
+		if err != nil {
+			level.Error(s.logger).Log("msg", "error encoding metrics", "err", err)
+			http.Error(w, fmt.Sprintf("An error has occurred while encoding metrics:\n\n%s", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", string(e.format))
+		if e.gzip {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Write(body)
+	})
+}
+
+// gzipAccepted reports whether the client accepts gzip, like promhttp it
+// ignores quality values.
+func gzipAccepted(header http.Header) bool {
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		if enc, _, _ := strings.Cut(part, ";"); strings.TrimSpace(enc) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// countingGatherer counts its gatherings, each blocks until released.
+type countingGatherer struct {
+	mtx     sync.Mutex
+	count   int
+	release chan struct{}
+}
+
+func (g *countingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	<-g.release
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.count++
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_gatherings_total", Help: "Gatherings."})
+	c.Add(float64(g.count))
+	r := prometheus.NewRegistry()
+	r.MustRegister(c)
+	return r.Gather()
+}
+
+func TestScrapeCoalescer(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newScrapeCoalescer(5*time.Second, log.NewNopLogger())
+	s.now = func() time.Time { return now }
+	g := &countingGatherer{release: make(chan struct{})}
+	key := scrapeFilters{collect: []string{"cpu", "meminfo"}}.key()
+	h := s.handler(key, g)
+
+	scrape := func(header http.Header) *http.Response {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header = header
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+	body := func(resp *http.Response) string {
+		r := resp.Body
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Concurrent scrapes share the collection in flight.
+	var wg sync.WaitGroup
+	responses := make([]*http.Response, 3)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			header := http.Header{}
+			if i == 0 {
+				header.Set("Accept-Encoding", "gzip")
+			}
+			responses[i] = scrape(header)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(g.release)
+	wg.Wait()
+	for i, resp := range responses {
+		if got := body(resp); !strings.Contains(got, "test_gatherings_total 1\n") {
+			t.Errorf("%d: want first gathering, got %q", i, got)
+		}
+	}
+	if want, got := "gzip", responses[0].Header.Get("Content-Encoding"); want != got {
+		t.Errorf("want Content-Encoding %q, got %q", want, got)
+	}
+	if want, got := string(expfmt.FmtText), responses[1].Header.Get("Content-Type"); want != got {
+		t.Errorf("want Content-Type %q, got %q", want, got)
+	}
+
+	// Scrapes within the window reuse the collection and its encodings.
+	now = now.Add(4 * time.Second)
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited")
+	if want, got := string(expfmt.FmtProtoDelim), scrape(header).Header.Get("Content-Type"); want != got {
+		t.Errorf("want Content-Type %q, got %q", want, got)
+	}
+	if got := body(scrape(http.Header{})); !strings.Contains(got, "test_gatherings_total 1\n") {
+		t.Errorf("want first gathering within the window, got %q", got)
+	}
+	if c := s.collections[scrapeFilters{collect: []string{"meminfo", "cpu"}}.key()]; len(c.encoded) != 3 {
+		t.Errorf("want 3 encodings cached, got %d", len(c.encoded))
+	}
+
+	// Scrapes after the window collect again.
+	now = now.Add(time.Second)
+	if got := body(scrape(http.Header{})); !strings.Contains(got, "test_gatherings_total 2\n") {
+		t.Errorf("want second gathering after the window, got %q", got)
+	}
+	if want, got := 2, g.count; want != got {
+		t.Errorf("want %d gatherings, got %d", want, got)
+	}
+}
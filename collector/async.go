@@ -0,0 +1,179 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scrapeCacheAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_cache_age_seconds"),
+		"node_exporter: Age of the last successful background run of a collector served by scrapes.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_last_success_timestamp_seconds"),
+		"node_exporter: Time of the last successful background run of a collector.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// errStale is reported for background collectors whose last successful run
+// is older than their max staleness.
+var errStale = errors.New("cached metrics are stale")
+
+// asyncCollector runs a collector in the background at a fixed interval and
+// serves scrapes from the metrics of its last successful run, so slow
+// collectors don't add to the scrape latency.
+type asyncCollector struct {
+	name         string
+	collector    Collector
+	interval     time.Duration
+	maxStaleness time.Duration
+	timeout      time.Duration
+	logger       log.Logger
+	now          func() time.Time
+
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mtx         sync.Mutex
+	metrics     []prometheus.Metric
+	lastSuccess time.Time
+	duration    time.Duration
+	err         error
+}
+
+func newAsyncCollector(name string, c Collector, interval, maxStaleness time.Duration, logger log.Logger) *asyncCollector {
+	return &asyncCollector{
+		name:         name,
+		collector:    c,
+		interval:     interval,
+		maxStaleness: maxStaleness,
+		logger:       logger,
+		now:          time.Now,
+		done:         make(chan struct{}),
+		// Scrapes before the first run completes have no data.
+		err: ErrNoData,
+	}
+}
+
+// start runs the collector now and then every interval until stop is called.
+func (a *asyncCollector) start() {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			a.run()
+			select {
+			case <-ticker.C:
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
+
+func (a *asyncCollector) stop() {
+	a.stopOnce.Do(func() { close(a.done) })
+}
+
+// run updates the collector once. The metrics of a failed run are discarded
+// and the previous ones are served until they become stale.
+func (a *asyncCollector) run() {
+	ch := make(chan prometheus.Metric)
+	metrics := make(chan []prometheus.Metric)
+	go func() {
+		var ms []prometheus.Metric
+		for m := range ch {
+			ms = append(ms, m)
+		}
+		metrics <- ms
+	}()
+
+	begin := a.now()
+	err := updateWithTimeout(a.name, a.collector, ch, collectorTimeout(a.timeout, time.Time{}))
+	close(ch)
+	ms := <-metrics
+	end := a.now()
+	recordRun(a.name, begin, end.Sub(begin), err)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.duration, a.err = end.Sub(begin), err
+	if err != nil {
+		level.Debug(a.logger).Log("msg", "background run failed", "name", a.name, "err", err)
+		return
+	}
+	a.metrics, a.lastSuccess = ms, end
+}
+
+// collect sends the cached metrics and the scrape metrics of the last run.
+func (a *asyncCollector) collect(ch chan<- prometheus.Metric) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	err := a.err
+	if !a.lastSuccess.IsZero() {
+		age := a.now().Sub(a.lastSuccess)
+		ch <- prometheus.MustNewConstMetric(scrapeCacheAgeDesc, prometheus.GaugeValue, age.Seconds(), a.name)
+		ch <- prometheus.MustNewConstMetric(scrapeLastSuccessDesc, prometheus.GaugeValue, float64(a.lastSuccess.UnixNano())/1e9, a.name)
+		if age > a.maxStaleness {
+			err = errStale
+		} else {
+			for _, m := range a.metrics {
+				ch <- m
+			}
+		}
+	}
+
+	var success, timedOut float64
+	switch {
+	case err == nil:
+		success = 1
+	case isTimeoutError(err):
+		timedOut = 1
+		level.Warn(a.logger).Log("msg", "collector timed out", "name", a.name, "duration_seconds", a.duration.Seconds(), "err", err)
+	case IsNoDataError(err):
+		level.Debug(a.logger).Log("msg", "collector returned no data", "name", a.name, "err", err)
+	default:
+		level.Error(a.logger).Log("msg", "collector failed", "name", a.name, "duration_seconds", a.duration.Seconds(), "err", err)
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, a.duration.Seconds(), a.name)
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, a.name)
+	ch <- prometheus.MustNewConstMetric(scrapeTimeoutDesc, prometheus.GaugeValue, timedOut, a.name)
+}
+
+// Update sends the cached metrics, it implements Collector so background
+// collectors can be kept with the others.
+func (a *asyncCollector) Update(ch chan<- prometheus.Metric) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.lastSuccess.IsZero() || a.now().Sub(a.lastSuccess) > a.maxStaleness {
+		return ErrNoData
+	}
+	for _, m := range a.metrics {
+		ch <- m
+	}
+	return a.err
+}
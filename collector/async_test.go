@@ -0,0 +1,174 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// countingCollector exports the number of its runs, or fails.
+type countingCollector struct {
+	desc *prometheus.Desc
+	runs float64
+	err  error
+}
+
+func (c *countingCollector) Update(ch chan<- prometheus.Metric) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.runs++
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, c.runs)
+	return nil
+}
+
+// nodeCollectorAdapter exposes a collector with its scrape metrics.
+type nodeCollectorAdapter struct {
+	NodeCollector
+}
+
+func (a nodeCollectorAdapter) Describe(ch chan<- *prometheus.Desc) {}
+
+func TestAsyncCollector(t *testing.T) {
+	c := &countingCollector{desc: prometheus.NewDesc("test_runs_total", "Runs.", nil, nil)}
+	now := time.Unix(1000, 0)
+	a := newAsyncCollector("test", c, time.Minute, 3*time.Minute, log.NewNopLogger())
+	a.now = func() time.Time { return now }
+	n := nodeCollectorAdapter{NodeCollector{Collectors: map[string]Collector{"test": a}, logger: log.NewNopLogger()}}
+
+	check := func(want string) {
+		t.Helper()
+		if err := testutil.CollectAndCompare(n, strings.NewReader(want),
+			"test_runs_total",
+			"node_scrape_collector_success",
+			"node_scrape_collector_cache_age_seconds",
+			"node_scrape_collector_last_success_timestamp_seconds",
+		); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Nothing is served before the first run.
+	check(`
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="test"} 0
+`)
+
+	a.run()
+	now = now.Add(30 * time.Second)
+	check(`
+# HELP node_scrape_collector_cache_age_seconds node_exporter: Age of the last successful background run of a collector served by scrapes.
+# TYPE node_scrape_collector_cache_age_seconds gauge
+node_scrape_collector_cache_age_seconds{collector="test"} 30
+# HELP node_scrape_collector_last_success_timestamp_seconds node_exporter: Time of the last successful background run of a collector.
+# TYPE node_scrape_collector_last_success_timestamp_seconds gauge
+node_scrape_collector_last_success_timestamp_seconds{collector="test"} 1000
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="test"} 1
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 1
+`)
+
+	// A failed run keeps serving the previous metrics.
+	c.err = errors.New("failed")
+	a.run()
+	now = now.Add(time.Minute)
+	check(`
+# HELP node_scrape_collector_cache_age_seconds node_exporter: Age of the last successful background run of a collector served by scrapes.
+# TYPE node_scrape_collector_cache_age_seconds gauge
+node_scrape_collector_cache_age_seconds{collector="test"} 90
+# HELP node_scrape_collector_last_success_timestamp_seconds node_exporter: Time of the last successful background run of a collector.
+# TYPE node_scrape_collector_last_success_timestamp_seconds gauge
+node_scrape_collector_last_success_timestamp_seconds{collector="test"} 1000
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="test"} 0
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 1
+`)
+
+	// Past the max staleness the metrics are dropped.
+	now = now.Add(2 * time.Minute)
+	check(`
+# HELP node_scrape_collector_cache_age_seconds node_exporter: Age of the last successful background run of a collector served by scrapes.
+# TYPE node_scrape_collector_cache_age_seconds gauge
+node_scrape_collector_cache_age_seconds{collector="test"} 210
+# HELP node_scrape_collector_last_success_timestamp_seconds node_exporter: Time of the last successful background run of a collector.
+# TYPE node_scrape_collector_last_success_timestamp_seconds gauge
+node_scrape_collector_last_success_timestamp_seconds{collector="test"} 1000
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="test"} 0
+`)
+
+	c.err = nil
+	a.run()
+	check(`
+# HELP node_scrape_collector_cache_age_seconds node_exporter: Age of the last successful background run of a collector served by scrapes.
+# TYPE node_scrape_collector_cache_age_seconds gauge
+node_scrape_collector_cache_age_seconds{collector="test"} 0
+# HELP node_scrape_collector_last_success_timestamp_seconds node_exporter: Time of the last successful background run of a collector.
+# TYPE node_scrape_collector_last_success_timestamp_seconds gauge
+node_scrape_collector_last_success_timestamp_seconds{collector="test"} 1210
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="test"} 1
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 2
+`)
+}
+
+// closingCollector records whether it was closed.
+type closingCollector struct {
+	countingCollector
+	closed bool
+}
+
+func (c *closingCollector) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestStopCollector(t *testing.T) {
+	c := &closingCollector{}
+	stopCollector(c, log.NewNopLogger())
+	if !c.closed {
+		t.Error("want collector closed")
+	}
+
+	// Collectors run in the background are stopped and closed.
+	c = &closingCollector{countingCollector: countingCollector{desc: prometheus.NewDesc("test_runs_total", "Runs.", nil, nil)}}
+	a := newAsyncCollector("test", c, time.Hour, 3*time.Hour, log.NewNopLogger())
+	stopCollector(a, log.NewNopLogger())
+	if !c.closed {
+		t.Error("want background collector closed")
+	}
+	select {
+	case <-a.done:
+	default:
+		t.Error("want background runs stopped")
+	}
+}
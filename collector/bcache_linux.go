@@ -35,8 +35,9 @@ func init() {
 
 // A bcacheCollector is a Collector which gathers metrics from Linux bcache.
 type bcacheCollector struct {
-	fs     bcache.FS
-	logger log.Logger
+	fs            bcache.FS
+	priorityStats bool
+	logger        log.Logger
 }
 
 // NewBcacheCollector returns a newly allocated bcacheCollector.
@@ -48,8 +49,9 @@ func NewBcacheCollector(logger log.Logger) (Collector, error) {
 	}
 
 	return &bcacheCollector{
-		fs:     fs,
-		logger: logger,
+		fs:            fs,
+		priorityStats: *priorityStats,
+		logger:        logger,
 	}, nil
 }
 
@@ -58,7 +60,7 @@ func NewBcacheCollector(logger log.Logger) (Collector, error) {
 func (c *bcacheCollector) Update(ch chan<- prometheus.Metric) error {
 	var stats []*bcache.Stats
 	var err error
-	if *priorityStats {
+	if c.priorityStats {
 		stats, err = c.fs.Stats()
 	} else {
 		stats, err = c.fs.StatsWithoutPriority()
@@ -317,7 +319,7 @@ func (c *bcacheCollector) updateBcacheStats(ch chan<- prometheus.Metric, s *bcac
 				extraLabelValue: cache.Name,
 			},
 		}
-		if *priorityStats {
+		if c.priorityStats {
 			// metrics in /sys/fs/bcache/<uuid>/<cache>/priority_stats
 			priorityStatsMetrics := []bcacheMetric{
 				{
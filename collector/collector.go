@@ -17,6 +17,7 @@ package collector
 import (
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -55,6 +56,9 @@ var (
 	initiatedCollectors    = make(map[string]Collector)
 	collectorState         = make(map[string]*bool)
 	forcedCollectors       = map[string]bool{} // collectors which have been explicitly enabled or disabled
+	collectorInterval      = make(map[string]*time.Duration)
+	collectorMaxStaleness  = make(map[string]*time.Duration)
+	collectorTimeouts      = make(map[string]*time.Duration)
 )
 
 func registerCollector(collector string, isDefaultEnabled bool, factory func(logger log.Logger) (Collector, error)) {
@@ -72,13 +76,32 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func(log
 	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Action(collectorFlagAction(collector)).Bool()
 	collectorState[collector] = flag
 
+	collectorInterval[collector] = kingpin.Flag(
+		fmt.Sprintf("collector.%s.interval", collector),
+		fmt.Sprintf("Run the %s collector in the background at this interval and serve scrapes from its last result, 0 runs it on each scrape.", collector),
+	).Default("0s").Duration()
+	collectorMaxStaleness[collector] = kingpin.Flag(
+		fmt.Sprintf("collector.%s.max-staleness", collector),
+		fmt.Sprintf("Age after which the background results of the %s collector are dropped, 0 means three intervals.", collector),
+	).Default("0s").Duration()
+	collectorTimeouts[collector] = kingpin.Flag(
+		fmt.Sprintf("collector.%s.timeout", collector),
+		fmt.Sprintf("Time after which an update of the %s collector is abandoned, 0 means no limit besides the scrape timeout.", collector),
+	).Default("0s").Duration()
+
 	factories[collector] = factory
 }
 
 // NodeCollector implements the prometheus.Collector interface.
 type NodeCollector struct {
 	Collectors map[string]Collector
-	logger     log.Logger
+	// Timeout bounds the time Collect waits for the collectors, the
+	// collectors still running are abandoned. Zero means no limit.
+	Timeout time.Duration
+	// timeouts of the collectors, read when the NodeCollector is created as
+	// reloads may change the flags while collecting.
+	timeouts map[string]time.Duration
+	logger   log.Logger
 }
 
 // DisableDefaultCollectors sets the collector state to false for all collectors which
@@ -105,8 +128,17 @@ func collectorFlagAction(collector string) func(ctx *kingpin.ParseContext) error
 
 // NewNodeCollector creates a new NodeCollector.
 func NewNodeCollector(logger log.Logger, filters ...string) (*NodeCollector, error) {
+	return NewFilteredNodeCollector(logger, filters, nil)
+}
+
+// NewFilteredNodeCollector creates a new NodeCollector of the enabled
+// collectors in include, or all of them if include is empty, except those in
+// exclude.
+func NewFilteredNodeCollector(logger log.Logger, include, exclude []string) (*NodeCollector, error) {
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
 	f := make(map[string]bool)
-	for _, filter := range filters {
+	for _, filter := range include {
 		enabled, exist := collectorState[filter]
 		if !exist {
 			return nil, fmt.Errorf("missing collector: %s", filter)
@@ -116,54 +148,114 @@ func NewNodeCollector(logger log.Logger, filters ...string) (*NodeCollector, err
 		}
 		f[filter] = true
 	}
+	excluded := make(map[string]bool)
+	for _, filter := range exclude {
+		if _, exist := collectorState[filter]; !exist {
+			return nil, fmt.Errorf("missing collector: %s", filter)
+		}
+		excluded[filter] = true
+	}
 	collectors := make(map[string]Collector)
-	initiatedCollectorsMtx.Lock()
-	defer initiatedCollectorsMtx.Unlock()
+	timeouts := make(map[string]time.Duration)
 	for key, enabled := range collectorState {
-		if !*enabled || (len(f) > 0 && !f[key]) {
+		if !*enabled || (len(f) > 0 && !f[key]) || excluded[key] {
 			continue
 		}
 		if collector, ok := initiatedCollectors[key]; ok {
 			collectors[key] = collector
 		} else {
-			collector, err := factories[key](log.With(logger, "collector", key))
+			collector, err := newCollector(key, logger)
 			if err != nil {
 				return nil, err
 			}
 			collectors[key] = collector
 			initiatedCollectors[key] = collector
 		}
+		if t, ok := collectorTimeouts[key]; ok {
+			timeouts[key] = *t
+		}
+	}
+	return &NodeCollector{Collectors: collectors, timeouts: timeouts, logger: logger}, nil
+}
+
+// newCollector creates the named collector, collectors with an interval are
+// run in the background.
+func newCollector(name string, logger log.Logger) (Collector, error) {
+	logger = log.With(logger, "collector", name)
+	c, err := factories[name](logger)
+	if err != nil {
+		return nil, err
+	}
+	if interval := *collectorInterval[name]; interval > 0 {
+		maxStaleness := *collectorMaxStaleness[name]
+		if maxStaleness <= 0 {
+			maxStaleness = 3 * interval
+		}
+		a := newAsyncCollector(name, c, interval, maxStaleness, logger)
+		a.timeout = *collectorTimeouts[name]
+		a.start()
+		return a, nil
+	}
+	return c, nil
+}
+
+// stopCollector stops the background runs of a collector which is no longer
+// used. Collectors holding resources beyond a scrape, e.g. sockets or
+// goroutines, implement io.Closer to release them.
+func stopCollector(c Collector, logger log.Logger) {
+	if a, ok := c.(*asyncCollector); ok {
+		a.stop()
+		c = a.collector
+	}
+	if closer, ok := c.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			level.Warn(logger).Log("msg", "couldn't close collector", "err", err)
+		}
 	}
-	return &NodeCollector{Collectors: collectors, logger: logger}, nil
 }
 
 // Describe implements the prometheus.Collector interface.
 func (n NodeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeCacheAgeDesc
+	ch <- scrapeLastSuccessDesc
+	ch <- scrapeTimeoutDesc
 }
 
 // Collect implements the prometheus.Collector interface.
 func (n NodeCollector) Collect(ch chan<- prometheus.Metric) {
+	var deadline time.Time
+	if n.Timeout > 0 {
+		deadline = time.Now().Add(n.Timeout)
+	}
 	wg := sync.WaitGroup{}
 	wg.Add(len(n.Collectors))
 	for name, c := range n.Collectors {
 		go func(name string, c Collector) {
-			execute(name, c, ch, n.logger)
+			execute(name, c, ch, n.logger, n.timeouts[name], deadline)
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric, logger log.Logger) {
+func execute(name string, c Collector, ch chan<- prometheus.Metric, logger log.Logger, timeout time.Duration, deadline time.Time) {
+	if a, ok := c.(*asyncCollector); ok {
+		a.collect(ch)
+		return
+	}
 	begin := time.Now()
-	err := c.Update(ch)
+	err := updateWithTimeout(name, c, ch, collectorTimeout(timeout, deadline))
 	duration := time.Since(begin)
-	var success float64
+	recordRun(name, begin, duration, err)
+	var success, timedOut float64
 
 	if err != nil {
-		if IsNoDataError(err) {
+		if isTimeoutError(err) {
+			timedOut = 1
+			level.Warn(logger).Log("msg", "collector timed out", "name", name, "duration_seconds", duration.Seconds(), "err", err)
+		} else if IsNoDataError(err) {
 			level.Debug(logger).Log("msg", "collector returned no data", "name", name, "duration_seconds", duration.Seconds(), "err", err)
 		} else {
 			level.Error(logger).Log("msg", "collector failed", "name", name, "duration_seconds", duration.Seconds(), "err", err)
@@ -175,6 +267,7 @@ func execute(name string, c Collector, ch chan<- prometheus.Metric, logger log.L
 	}
 	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
 	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	ch <- prometheus.MustNewConstMetric(scrapeTimeoutDesc, prometheus.GaugeValue, timedOut, name)
 }
 
 // Collector is the interface a collector has to implement.
@@ -0,0 +1,233 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	commandTimeout        = kingpin.Flag("collector.command.timeout", "Default timeout of the external commands run by collectors.").Default("10s").Duration()
+	commandAllowlist      = kingpin.Flag("collector.command.allowlist", "Comma separated names or absolute paths of the external commands collectors may run.").Default("top").String()
+	commandMaxConcurrency = kingpin.Flag("collector.command.max-concurrency", "Maximum number of external commands running at the same time.").Default("4").Int()
+)
+
+// errCommandDenied is returned for commands missing from the allowlist.
+var errCommandDenied = errors.New("command not in --collector.command.allowlist")
+
+// command is an external command run by a collector. Commands are run
+// directly, without a shell.
+type command struct {
+	// name identifies the command in the metrics, e.g. top.
+	name string
+	// args is the argv of the command, args[0] is the executable.
+	args []string
+	// timeout overrides --collector.command.timeout if set.
+	timeout time.Duration
+	// cacheTTL is how long the output is reused by later runs, 0 disables
+	// caching.
+	cacheTTL time.Duration
+}
+
+// commandRunner runs the external commands of the collectors. Collectors
+// take a commandRunner so the parsing of the output can be tested with
+// canned output.
+type commandRunner interface {
+	run(ctx context.Context, cmd command) ([]byte, error)
+}
+
+var (
+	commandMetrics = &commandMetricsCollector{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "node_exporter",
+			Name:      "command_duration_seconds",
+			Help:      "Duration of the external commands run by collectors.",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"command"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "node_exporter",
+			Name:      "command_failures_total",
+			Help:      "Number of failed runs of the external commands run by collectors, by reason.",
+		}, []string{"command", "reason"}),
+	}
+	// CommandMetrics collects the metrics about the external commands run
+	// by collectors.
+	CommandMetrics prometheus.Collector = commandMetrics
+
+	defaultCommandRunnerOnce sync.Once
+	defaultCommandRunner     *execCommandRunner
+)
+
+type commandMetricsCollector struct {
+	duration *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+}
+
+func (c *commandMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.duration.Describe(ch)
+	c.failures.Describe(ch)
+}
+
+func (c *commandMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.duration.Collect(ch)
+	c.failures.Collect(ch)
+}
+
+// getCommandRunner returns the runner configured by the command line flags.
+func getCommandRunner() commandRunner {
+	defaultCommandRunnerOnce.Do(func() {
+		var allowlist []string
+		for _, name := range strings.Split(*commandAllowlist, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				allowlist = append(allowlist, name)
+			}
+		}
+		defaultCommandRunner = newExecCommandRunner(allowlist, *commandMaxConcurrency, *commandTimeout)
+	})
+	return defaultCommandRunner
+}
+
+// execCommandRunner runs allowlisted commands with a timeout, a bound on the
+// number of concurrent commands and an optional cache of their output.
+type execCommandRunner struct {
+	allowed map[string]bool
+	timeout time.Duration
+	sem     chan struct{}
+	metrics *commandMetricsCollector
+	exec    func(ctx context.Context, args []string) ([]byte, error)
+	now     func() time.Time
+
+	mtx   sync.Mutex
+	cache map[string]*commandCacheEntry
+}
+
+// commandCacheEntry holds the last output of a command. Its mutex also makes
+// concurrent runs of the same command wait for the first one.
+type commandCacheEntry struct {
+	mtx     sync.Mutex
+	out     []byte
+	expires time.Time
+}
+
+func newExecCommandRunner(allowlist []string, maxConcurrency int, timeout time.Duration) *execCommandRunner {
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	r := &execCommandRunner{
+		allowed: make(map[string]bool, len(allowlist)),
+		timeout: timeout,
+		sem:     make(chan struct{}, maxConcurrency),
+		metrics: commandMetrics,
+		exec:    execArgs,
+		now:     time.Now,
+		cache:   map[string]*commandCacheEntry{},
+	}
+	for _, name := range allowlist {
+		r.allowed[name] = true
+	}
+	return r
+}
+
+func (r *execCommandRunner) run(ctx context.Context, cmd command) ([]byte, error) {
+	if len(cmd.args) == 0 {
+		return nil, fmt.Errorf("command %s has no arguments", cmd.name)
+	}
+	// The executable has to match an allowlist entry exactly, an allowlisted
+	// name is looked up in $PATH and doesn't allow other paths of that name.
+	exe := cmd.args[0]
+	if !r.allowed[exe] {
+		r.metrics.failures.WithLabelValues(cmd.name, "denied").Inc()
+		return nil, fmt.Errorf("%s: %w", exe, errCommandDenied)
+	}
+
+	r.mtx.Lock()
+	key := strings.Join(cmd.args, "\x00")
+	entry, ok := r.cache[key]
+	if !ok {
+		entry = &commandCacheEntry{}
+		r.cache[key] = entry
+	}
+	r.mtx.Unlock()
+
+	entry.mtx.Lock()
+	defer entry.mtx.Unlock()
+	if r.now().Before(entry.expires) {
+		return entry.out, nil
+	}
+
+	out, err := r.runUncached(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.cacheTTL > 0 {
+		entry.out, entry.expires = out, r.now().Add(cmd.cacheTTL)
+	}
+	return out, nil
+}
+
+func (r *execCommandRunner) runUncached(ctx context.Context, cmd command) ([]byte, error) {
+	timeout := r.timeout
+	if cmd.timeout > 0 {
+		timeout = cmd.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	select {
+	case r.sem <- struct{}{}:
+		defer func() { <-r.sem }()
+	case <-ctx.Done():
+		r.metrics.failures.WithLabelValues(cmd.name, "timeout").Inc()
+		return nil, fmt.Errorf("%s: waiting for other commands: %w", cmd.name, ctx.Err())
+	}
+
+	begin := r.now()
+	out, err := r.exec(ctx, cmd.args)
+	r.metrics.duration.WithLabelValues(cmd.name).Observe(r.now().Sub(begin).Seconds())
+	if ctx.Err() != nil {
+		r.metrics.failures.WithLabelValues(cmd.name, "timeout").Inc()
+		return nil, fmt.Errorf("%s: %w", cmd.name, ctx.Err())
+	}
+	if err != nil {
+		r.metrics.failures.WithLabelValues(cmd.name, "error").Inc()
+		return nil, fmt.Errorf("%s: %w", cmd.name, err)
+	}
+	return out, nil
+}
+
+// execArgs runs argv and returns its standard output, the standard error is
+// added to the error of failed commands.
+func execArgs(ctx context.Context, args []string) ([]byte, error) {
+	c := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
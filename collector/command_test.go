@@ -0,0 +1,110 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCommandRunnerAllowlist(t *testing.T) {
+	r := newExecCommandRunner([]string{"top"}, 1, time.Second)
+	runs := 0
+	r.exec = func(ctx context.Context, args []string) ([]byte, error) {
+		runs++
+		return []byte("ok"), nil
+	}
+
+	before := testutil.ToFloat64(commandMetrics.failures.WithLabelValues("test-denied", "denied"))
+	for _, args := range [][]string{{"/usr/bin/top"}, {"sh", "-c", "top"}} {
+		if _, err := r.run(context.Background(), command{name: "test-denied", args: args}); !errors.Is(err, errCommandDenied) {
+			t.Errorf("%v: want %v, got %v", args, errCommandDenied, err)
+		}
+	}
+	if want, got := 2.0, testutil.ToFloat64(commandMetrics.failures.WithLabelValues("test-denied", "denied"))-before; want != got {
+		t.Errorf("want %v denied runs, got %v", want, got)
+	}
+	if out, err := r.run(context.Background(), command{name: "test-denied", args: []string{"top", "-b"}}); err != nil || string(out) != "ok" {
+		t.Errorf("want ok, got %q, %v", out, err)
+	}
+	if want, got := 1, runs; want != got {
+		t.Errorf("want %d runs, got %d", want, got)
+	}
+}
+
+func TestCommandRunnerCache(t *testing.T) {
+	r := newExecCommandRunner([]string{"top"}, 1, time.Second)
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+	runs := 0
+	r.exec = func(ctx context.Context, args []string) ([]byte, error) {
+		runs++
+		return []byte{byte('0' + runs)}, nil
+	}
+
+	cmd := command{name: "test-cache", args: []string{"top"}, cacheTTL: 10 * time.Second}
+	for i, want := range []string{"1", "1", "2"} {
+		if i == 2 {
+			now = now.Add(11 * time.Second)
+		}
+		out, err := r.run(context.Background(), cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("run %d: want %q, got %q", i, want, out)
+		}
+	}
+
+	// Without a TTL every run executes the command.
+	cmd = command{name: "test-cache", args: []string{"top", "-b"}}
+	r.run(context.Background(), cmd)
+	r.run(context.Background(), cmd)
+	if want, got := 4, runs; want != got {
+		t.Errorf("want %d runs, got %d", want, got)
+	}
+}
+
+func TestCommandRunnerFailures(t *testing.T) {
+	r := newExecCommandRunner([]string{"top"}, 1, time.Second)
+	r.exec = func(ctx context.Context, args []string) ([]byte, error) {
+		if args[1] == "hang" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("exit status 1")
+	}
+
+	timeouts := commandMetrics.failures.WithLabelValues("test-failures", "timeout")
+	errs := commandMetrics.failures.WithLabelValues("test-failures", "error")
+	beforeTimeouts, beforeErrs := testutil.ToFloat64(timeouts), testutil.ToFloat64(errs)
+
+	_, err := r.run(context.Background(), command{name: "test-failures", args: []string{"top", "hang"}, timeout: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	if _, err := r.run(context.Background(), command{name: "test-failures", args: []string{"top", "fail"}}); err == nil {
+		t.Error("want error")
+	}
+	if want, got := 1.0, testutil.ToFloat64(timeouts)-beforeTimeouts; want != got {
+		t.Errorf("want %v timeouts, got %v", want, got)
+	}
+	if want, got := 1.0, testutil.ToFloat64(errs)-beforeErrs; want != got {
+		t.Errorf("want %v errors, got %v", want, got)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the on-disk format of --config.file.
+//
+//	collectors:
+//	  filesystem:
+//	    mount-points-exclude: ^/(dev|proc|run|sys)($|/)
+//	  tcpinfo:
+//	    enabled: true
+//	    peer-group: [rack=10.1.0.0/16, dc=10.0.0.0/8]
+//
+// The options of a collector are the --collector.<name>.<option> flags
+// without the prefix. Lists set repeatable flags once per value.
+type Config struct {
+	Collectors map[string]CollectorConfig `yaml:"collectors"`
+}
+
+// CollectorConfig is the enable state and the options of one collector.
+type CollectorConfig struct {
+	Enabled *bool                  `yaml:"enabled"`
+	Options map[string]interface{} `yaml:",inline"`
+}
+
+// collectorOptions are the validated options of a collector keyed by flag
+// name, with the values to set on the flag.
+type collectorOptions map[string][]string
+
+// LoadConfig reads and parses a configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ConfigLoader applies a configuration file on top of the command line flags.
+// Flags given on the command line take precedence over the file, all other
+// flags are reset to their default before the file is applied so removing an
+// option from the file reverts it.
+type ConfigLoader struct {
+	path     string
+	explicit map[string]bool
+	logger   log.Logger
+
+	mtx     sync.Mutex
+	applied map[string]collectorOptions
+	// base restores the value a flag had before the file first set it,
+	// baseState is the enable state of each collector from the command line.
+	base      map[string]func()
+	baseState map[string]bool
+}
+
+// NewConfigLoader returns a loader for the configuration file at path.
+// explicitFlags are the names of the flags set on the command line.
+func NewConfigLoader(path string, explicitFlags map[string]bool, logger log.Logger) *ConfigLoader {
+	return &ConfigLoader{
+		path:      path,
+		explicit:  explicitFlags,
+		logger:    logger,
+		applied:   map[string]collectorOptions{},
+		base:      map[string]func(){},
+		baseState: map[string]bool{},
+	}
+}
+
+// Reload reads the configuration file and applies it. The collectors whose
+// options or enable state changed are created again, the others are kept.
+// If the file is invalid or a collector can't be created, the previous
+// configuration stays in effect.
+func (l *ConfigLoader) Reload() error {
+	cfg, err := LoadConfig(l.path)
+	if err != nil {
+		return err
+	}
+	options, err := validateConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	initiatedCollectorsMtx.Lock()
+	defer initiatedCollectorsMtx.Unlock()
+
+	touched := map[string]bool{}
+	for _, opts := range []map[string]collectorOptions{l.applied, options} {
+		for _, o := range opts {
+			for name := range o {
+				if !l.explicit[name] {
+					touched[name] = true
+				}
+			}
+		}
+	}
+	var rollback []func()
+	for name := range touched {
+		value := kingpin.CommandLine.GetFlag(name).Model().Value
+		if _, ok := l.base[name]; !ok {
+			l.base[name] = saveFlag(value)
+		}
+		rollback = append(rollback, saveFlag(value))
+	}
+	restore := func() {
+		for _, r := range rollback {
+			r()
+		}
+	}
+
+	for name := range touched {
+		l.base[name]()
+	}
+	for collector, o := range options {
+		for name, values := range o {
+			if l.explicit[name] {
+				level.Debug(l.logger).Log("msg", "Command line flag overrides the configuration file", "flag", name)
+				continue
+			}
+			if err := setFlag(kingpin.CommandLine.GetFlag(name).Model().Value, values); err != nil {
+				restore()
+				return fmt.Errorf("collector %s: invalid value for %s: %w", collector, name, err)
+			}
+		}
+	}
+
+	state := map[string]bool{}
+	var affected []string
+	for collector, enabled := range collectorState {
+		if _, ok := l.baseState[collector]; !ok {
+			l.baseState[collector] = *enabled
+		}
+		state[collector] = *enabled
+		if !forcedCollectors[collector] {
+			state[collector] = l.baseState[collector]
+			if c, ok := cfg.Collectors[collector]; ok && c.Enabled != nil {
+				state[collector] = *c.Enabled
+			}
+		}
+		if state[collector] != *enabled || !l.sameOptions(collector, options[collector]) {
+			affected = append(affected, collector)
+		}
+	}
+	sort.Strings(affected)
+
+	created := map[string]Collector{}
+	for _, collector := range affected {
+		if !state[collector] {
+			continue
+		}
+		c, err := newCollector(collector, l.logger)
+		if err != nil {
+			for _, c := range created {
+				stopCollector(c, l.logger)
+			}
+			restore()
+			return fmt.Errorf("couldn't create collector %s: %w", collector, err)
+		}
+		created[collector] = c
+	}
+
+	for _, collector := range affected {
+		*collectorState[collector] = state[collector]
+		if old, ok := initiatedCollectors[collector]; ok {
+			stopCollector(old, l.logger)
+		}
+		if c, ok := created[collector]; ok {
+			initiatedCollectors[collector] = c
+		} else {
+			delete(initiatedCollectors, collector)
+		}
+	}
+	l.applied = options
+	level.Info(l.logger).Log("msg", "Loaded configuration file", "file", l.path, "recreated_collectors", fmt.Sprint(affected))
+	return nil
+}
+
+// sameOptions reports whether the options of a collector are the ones
+// applied by the previous load, ignoring those overridden by flags.
+func (l *ConfigLoader) sameOptions(collector string, options collectorOptions) bool {
+	effective := func(o collectorOptions) collectorOptions {
+		out := collectorOptions{}
+		for name, values := range o {
+			if !l.explicit[name] {
+				out[name] = values
+			}
+		}
+		return out
+	}
+	return reflect.DeepEqual(effective(l.applied[collector]), effective(options))
+}
+
+// validateConfig checks that each collector and option of the configuration
+// exists and returns the flag values of the options.
+func validateConfig(cfg *Config) (map[string]collectorOptions, error) {
+	options := make(map[string]collectorOptions, len(cfg.Collectors))
+	for collector, c := range cfg.Collectors {
+		if _, ok := factories[collector]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", collector)
+		}
+		o := collectorOptions{}
+		for key, value := range c.Options {
+			name := fmt.Sprintf("collector.%s.%s", collector, key)
+			flag := kingpin.CommandLine.GetFlag(name)
+			if flag == nil {
+				return nil, fmt.Errorf("collector %s: unknown option %q", collector, key)
+			}
+			values, err := optionValues(value)
+			if err != nil {
+				return nil, fmt.Errorf("collector %s: option %s: %w", collector, key, err)
+			}
+			if len(values) != 1 && !isCumulative(flag.Model().Value) {
+				return nil, fmt.Errorf("collector %s: option %s takes a single value", collector, key)
+			}
+			o[name] = values
+		}
+		options[collector] = o
+	}
+	return options, nil
+}
+
+// optionValues converts a YAML scalar or list of scalars to flag values.
+func optionValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			s, err := optionValues(e)
+			if err != nil {
+				return nil, err
+			}
+			if len(s) != 1 {
+				return nil, fmt.Errorf("nested lists are not supported")
+			}
+			values = append(values, s[0])
+		}
+		return values, nil
+	case string, bool, int, int64, uint64, float64:
+		return []string{fmt.Sprint(v)}, nil
+	case nil:
+		return []string{""}, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+func isCumulative(v kingpin.Value) bool {
+	r, ok := v.(interface{ IsCumulative() bool })
+	return ok && r.IsCumulative()
+}
+
+// setFlag sets a flag to values, replacing the previous values of repeatable
+// flags.
+func setFlag(v kingpin.Value, values []string) error {
+	if isCumulative(v) {
+		if g, ok := v.(kingpin.Getter); ok {
+			slice := reflect.ValueOf(g.Get()).Elem()
+			slice.Set(reflect.Zero(slice.Type()))
+		}
+	}
+	for _, value := range values {
+		if err := v.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveFlag returns a function restoring the current value of a flag.
+func saveFlag(v kingpin.Value) func() {
+	if g, ok := v.(kingpin.Getter); ok && isCumulative(v) {
+		slice := reflect.ValueOf(g.Get()).Elem()
+		saved := reflect.AppendSlice(reflect.MakeSlice(slice.Type(), 0, slice.Len()), slice)
+		return func() { slice.Set(saved) }
+	}
+	saved := v.String()
+	return func() { v.Set(saved) }
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notcpinfo && !nosocket_states
+// +build !notcpinfo,!nosocket_states
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConfigLoaderReload(t *testing.T) {
+	t.Cleanup(func() {
+		initiatedCollectorsMtx.Lock()
+		defer initiatedCollectorsMtx.Unlock()
+		for _, name := range []string{"tcpinfo", "socket_states"} {
+			*collectorState[name] = false
+			delete(initiatedCollectors, name)
+		}
+		*tcpInfoLocalPorts = ""
+		*tcpInfoPeerGroups = nil
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	load := func(l *ConfigLoader, config string) error {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return l.Reload()
+	}
+	l := NewConfigLoader(path, map[string]bool{}, log.NewNopLogger())
+
+	if err := load(l, `
+collectors:
+  socket_states:
+    enabled: true
+  tcpinfo:
+    enabled: true
+    local-ports: 22
+    peer-group: [rack=10.1.0.0/16, dc=10.0.0.0/8]
+`); err != nil {
+		t.Fatal(err)
+	}
+	if !*collectorState["tcpinfo"] || !*collectorState["socket_states"] {
+		t.Fatal("want tcpinfo and socket_states enabled")
+	}
+	if want, got := "22", *tcpInfoLocalPorts; want != got {
+		t.Errorf("want local ports %q, got %q", want, got)
+	}
+	if want, got := []string{"rack=10.1.0.0/16", "dc=10.0.0.0/8"}, *tcpInfoPeerGroups; !reflect.DeepEqual(want, got) {
+		t.Errorf("want peer groups %q, got %q", want, got)
+	}
+	tcpInfo, socketStates := initiatedCollectors["tcpinfo"], initiatedCollectors["socket_states"]
+	if tcpInfo == nil || socketStates == nil {
+		t.Fatal("want tcpinfo and socket_states created")
+	}
+
+	// Only the collector whose options changed is created again. Options
+	// removed from the file revert to their default.
+	if err := load(l, `
+collectors:
+  socket_states:
+    enabled: true
+  tcpinfo:
+    enabled: true
+    local-ports: 22,443
+`); err != nil {
+		t.Fatal(err)
+	}
+	if initiatedCollectors["socket_states"] != socketStates {
+		t.Error("want socket_states kept")
+	}
+	if initiatedCollectors["tcpinfo"] == tcpInfo {
+		t.Error("want tcpinfo recreated")
+	}
+	if len(*tcpInfoPeerGroups) != 0 {
+		t.Errorf("want peer groups reset, got %q", *tcpInfoPeerGroups)
+	}
+	tcpInfo = initiatedCollectors["tcpinfo"]
+
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{"collectors: {nosuch: {}}", `unknown collector "nosuch"`},
+		{"collectors: {tcpinfo: {nosuch: 1}}", `unknown option "nosuch"`},
+		{"collectors: {tcpinfo: {local-ports: [22, 443]}}", "takes a single value"},
+		{"collectors: {tcpinfo: {enabled: true, local-ports: http}}", "couldn't create collector tcpinfo"},
+		{"collector: {}", "failed to parse"},
+	} {
+		err := load(l, tc.config)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: want error containing %q, got %v", tc.config, tc.err, err)
+		}
+	}
+	if want, got := "22,443", *tcpInfoLocalPorts; want != got {
+		t.Errorf("want local ports %q kept after invalid configs, got %q", want, got)
+	}
+	if initiatedCollectors["tcpinfo"] != tcpInfo {
+		t.Error("want tcpinfo kept after invalid configs")
+	}
+
+	// Disabling removes the collector.
+	if err := load(l, "collectors: {socket_states: {enabled: true}}"); err != nil {
+		t.Fatal(err)
+	}
+	if *collectorState["tcpinfo"] {
+		t.Error("want tcpinfo disabled")
+	}
+	if _, ok := initiatedCollectors["tcpinfo"]; ok {
+		t.Error("want tcpinfo removed")
+	}
+}
+
+func TestConfigLoaderFlagsOverride(t *testing.T) {
+	t.Cleanup(func() {
+		*collectorState["tcpinfo"] = false
+		initiatedCollectorsMtx.Lock()
+		delete(initiatedCollectors, "tcpinfo")
+		initiatedCollectorsMtx.Unlock()
+		*tcpInfoLocalPorts = ""
+	})
+	*tcpInfoLocalPorts = "80"
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("collectors: {tcpinfo: {enabled: true, local-ports: 22}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := NewConfigLoader(path, map[string]bool{"collector.tcpinfo.local-ports": true}, log.NewNopLogger())
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "80", *tcpInfoLocalPorts; want != got {
+		t.Errorf("want local ports %q from the command line, got %q", want, got)
+	}
+	if !*collectorState["tcpinfo"] {
+		t.Error("want tcpinfo enabled")
+	}
+}
+
+// TestConfigLoaderReloadWhileCollecting is meant to be run with -race.
+func TestConfigLoaderReloadWhileCollecting(t *testing.T) {
+	t.Cleanup(func() {
+		initiatedCollectorsMtx.Lock()
+		defer initiatedCollectorsMtx.Unlock()
+		*collectorState["tcpinfo"] = false
+		delete(initiatedCollectors, "tcpinfo")
+		*tcpInfoLocalPorts = ""
+		*collectorTimeouts["tcpinfo"] = 0
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	l := NewConfigLoader(path, map[string]bool{}, log.NewNopLogger())
+	load := func(i int) {
+		config := fmt.Sprintf("collectors: {tcpinfo: {enabled: true, local-ports: %d, timeout: %ds}}", 22+i%2, 1+i%2)
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load(0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			n, err := NewFilteredNodeCollector(log.NewNopLogger(), []string{"tcpinfo"}, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ch := make(chan prometheus.Metric)
+			go func() {
+				n.Collect(ch)
+				close(ch)
+			}()
+			for range ch {
+			}
+		}
+	}()
+	for i := 1; i <= 20; i++ {
+		load(i)
+	}
+	<-done
+}
@@ -18,11 +18,13 @@ package collector
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -68,7 +70,13 @@ const (
 	udevSCSIIdentSerial         = "SCSI_IDENT_SERIAL"
 )
 var (
-	ignoredDevices = kingpin.Flag("collector.diskstats.ingore-inode", "Regexp of devices to ignore for diskstats.").Default("^(ram|loop|fd|(h|s|v|xv)d[a-z]|nvme\\d+n\\d+p)\\d+$").String()
+	diskstatsInodeMountPointsExclude = kingpin.Flag("collector.diskstats.inode.mount-points-exclude", "Regexp of mount points to exclude from the inode metrics of the diskstats collector.").Default(defMountPointsExcluded).String()
+	diskstatsInodeFSTypesExclude     = kingpin.Flag("collector.diskstats.inode.fs-types-exclude", "Regexp of filesystem types to exclude from the inode metrics of the diskstats collector.").Default(defFSTypesExcluded).String()
+	diskstatsInodeMountTimeout       = kingpin.Flag("collector.diskstats.inode.mount-timeout", "How long to wait for a mount to respond before marking it as stale in the inode metrics of the diskstats collector.").Hidden().Default("5s").Duration()
+	oldDiskstatsInodeDeviceExclude   = kingpin.Flag(
+		"collector.diskstats.ingore-inode",
+		"DEPRECATED: Use collector.diskstats.inode.mount-points-exclude or collector.diskstats.inode.fs-types-exclude",
+	).Hidden().String()
 )
 
 type typedFactorDesc struct {
@@ -83,7 +91,6 @@ func (d *typedFactorDesc) mustNewConstMetric(value float64, labels ...string) pr
 }
 
 type diskstatsCollector struct {
-	ignoredDevicesPattern   *regexp.Regexp
 	deviceFilter            deviceFilter
 	fs                      blockdevice.FS
 	infoDesc                typedFactorDesc
@@ -94,6 +101,10 @@ type diskstatsCollector struct {
 	logger                  log.Logger
 	getUdevDeviceProperties func(uint32, uint32) (udevInfo, error)
 	inodeDescs              []typedFactorDesc
+	inodeMountPointsExclude *regexp.Regexp
+	inodeFSTypesExclude     *regexp.Regexp
+	inodeDeviceExclude      *regexp.Regexp
+	mountTimeout            time.Duration
 
 }
 
@@ -105,6 +116,7 @@ func init() {
 // Docs from https://www.kernel.org/doc/Documentation/iostats.txt
 func NewDiskstatsCollector(logger log.Logger) (Collector, error) {
 	var diskLabelNames = []string{"device"}
+	var inodeLabelNames = []string{"device", "mountpoint", "fstype"}
 	fs, err := blockdevice.NewFS(*procPath, *sysPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open sysfs: %w", err)
@@ -115,8 +127,18 @@ func NewDiskstatsCollector(logger log.Logger) (Collector, error) {
 		return nil, fmt.Errorf("failed to parse device filter flags: %w", err)
 	}
 
+	// The deprecated flag matched the device column of df -i, keep matching
+	// the inode metrics by device until it is removed.
+	var inodeDeviceExclude *regexp.Regexp
+	if *oldDiskstatsInodeDeviceExclude != "" {
+		level.Warn(logger).Log("msg", "--collector.diskstats.ingore-inode is DEPRECATED and will be removed in 2.0.0, use --collector.diskstats.inode.mount-points-exclude or --collector.diskstats.inode.fs-types-exclude")
+		inodeDeviceExclude, err = regexp.Compile(*oldDiskstatsInodeDeviceExclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --collector.diskstats.ingore-inode regexp: %w", err)
+		}
+	}
+
 	collector := diskstatsCollector{
-		ignoredDevicesPattern: regexp.MustCompile(*ignoredDevices),
 		deviceFilter: deviceFilter,
 		fs:           fs,
 		infoDesc: typedFactorDesc{
@@ -271,28 +293,32 @@ func NewDiskstatsCollector(logger log.Logger) (Collector, error) {
 			{
 				desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, diskSubsystem, "inodes_total"),
-					"This is the total number of inodes total.",
-					diskLabelNames,
+					"Total number of inodes of the filesystem.",
+					inodeLabelNames,
 					nil,
 				), valueType: prometheus.GaugeValue,
 			},
 			{
 				desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, diskSubsystem, "inode_used"),
-					"This is the total number of inode used.",
-					diskLabelNames,
+					"Number of used inodes of the filesystem.",
+					inodeLabelNames,
 					nil,
 				), valueType: prometheus.GaugeValue,
 			},
 			{
 				desc: prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, diskSubsystem, "inode_free"),
-					"This is the total number of inode free.",
-					diskLabelNames,
+					"Number of free inodes of the filesystem.",
+					inodeLabelNames,
 					nil,
 				), valueType: prometheus.GaugeValue,
 			},
 		},
+		inodeMountPointsExclude: regexp.MustCompile(*diskstatsInodeMountPointsExclude),
+		inodeFSTypesExclude:     regexp.MustCompile(*diskstatsInodeFSTypesExclude),
+		inodeDeviceExclude:      inodeDeviceExclude,
+		mountTimeout:            *diskstatsInodeMountTimeout,
 	}
 
 	// Only enable getting device properties from udev if the directory is readable.
@@ -403,58 +429,49 @@ func (c *diskstatsCollector) Update(ch chan<- prometheus.Metric) error {
 			}
 		}
 	}
-	diskInodes, err := getDiskInode()
+	return c.updateInodes(ch)
+}
+
+// updateInodes exports the inode usage of the mounted filesystems. Mount
+// points that don't respond are skipped like in the filesystem collector.
+func (c *diskstatsCollector) updateInodes(ch chan<- prometheus.Metric) error {
+	mps, err := mountPointDetails(c.logger)
 	if err != nil {
-		return fmt.Errorf("couldn't get diskInodes: %w", err)
+		return fmt.Errorf("couldn't get mount points: %w", err)
 	}
-	for dev, stats := range diskInodes {
-		if c.ignoredDevicesPattern.MatchString(dev) {
-			level.Debug(c.logger).Log("msg", "Ignoring device", "device", dev)
+	seen := map[mountPointLabels]bool{}
+	for _, labels := range mps {
+		if c.inodeMountPointsExclude.MatchString(labels.mountPoint) || c.inodeFSTypesExclude.MatchString(labels.fsType) {
+			continue
+		}
+		if c.inodeDeviceExclude != nil && c.inodeDeviceExclude.MatchString(labels.device) {
+			continue
+		}
+		// A mount point may be listed again if it was mounted over.
+		key := mountPointLabels{device: labels.device, mountPoint: labels.mountPoint, fsType: labels.fsType}
+		if seen[key] {
 			continue
 		}
+		seen[key] = true
 
-		for i, value := range stats {
-			// ignore unrecognized additional stats
-			if i >= len(c.inodeDescs) {
-				break
-			}
-			v, err := strconv.ParseFloat(value, 64)
-			if err != nil {
-				return fmt.Errorf("invalid value %s in diskinodes: %w", value, err)
+		buf, err := statfsMountPoint(labels.mountPoint, c.mountTimeout, c.logger)
+		if err != nil {
+			if !errors.Is(err, errMountPointStuck) {
+				level.Debug(c.logger).Log("msg", "Error on statfs() system call", "rootfs", rootfsFilePath(labels.mountPoint), "err", err)
 			}
-			ch <- c.inodeDescs[i].mustNewConstMetric(v, dev)
+			continue
 		}
-	}
-	return nil
-}
-
-func getDiskInode() (map[string][]string, error) {
-	diskInode, err := execCommand("df -i")
-	if err != nil {
-		fmt.Errorf("couldn't get diskInode: %w", err)
-		return nil, err
-	}
-	return parseDiskInode(diskInode)
-}
-func parseDiskInode(str string) (map[string][]string, error) {
-	var (
-		diskInode = map[string][]string{}
-	)
-
-	for _, devInode := range strings.Split(str, "\n")[1:] {
-
-		parts := strings.Fields(devInode)
-		if len(parts) != 0 {
-			dev := parts[0]
-			diskInode[dev] = parts[1:]
+		for i, v := range []float64{
+			float64(buf.Files),
+			float64(buf.Files - buf.Ffree),
+			float64(buf.Ffree),
+		} {
+			ch <- c.inodeDescs[i].mustNewConstMetric(v, labels.device, labels.mountPoint, labels.fsType)
 		}
-
 	}
-
-	return diskInode, nil
+	return nil
 }
 
-
 func getUdevDeviceProperties(major, minor uint32) (udevInfo, error) {
 	filename := udevDataFilePath(fmt.Sprintf("b%d:%d", major, minor))
 
@@ -22,6 +22,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 type testDiskStatsCollector struct {
@@ -51,6 +52,8 @@ func TestDiskStats(t *testing.T) {
 	*procPath = "fixtures/proc"
 	*udevDataPath = "fixtures/udev/data"
 	*diskstatsDeviceExclude = "^(ram|loop|fd|(h|s|v|xv)d[a-z]|nvme\\d+n\\d+p)\\d+$"
+	// The inode usage of the host is covered by TestDiskStatsInodes.
+	*diskstatsInodeMountPointsExclude = ".*"
 	testcase := `# HELP node_disk_ata_rotation_rate_rpm ATA disk rotation rate in RPMs (0 for SSDs).
 # TYPE node_disk_ata_rotation_rate_rpm gauge
 node_disk_ata_rotation_rate_rpm{device="sda"} 7200
@@ -340,3 +343,83 @@ node_disk_written_bytes_total{device="vda"} 1.0938236928e+11
 		t.Fatal(err)
 	}
 }
+
+func TestDiskStatsInodes(t *testing.T) {
+	*sysPath = "fixtures/sys"
+	*procPath = "fixtures/proc"
+	*rootfsPath = "fixtures"
+	defer func() { *rootfsPath = "/" }()
+	*udevDataPath = "fixtures/udev/data"
+	*diskstatsInodeMountPointsExclude = "^/(dev|proc|sys)($|/)"
+	*diskstatsInodeFSTypesExclude = "^rootfs$"
+	c, err := NewDiskstatsCollector(log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	if err := c.(*diskstatsCollector).updateInodes(ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+
+	// Only the root filesystem exists below fixtures, the other mount
+	// points can't be stat'ed and are skipped.
+	values := map[string]float64{}
+	for m := range ch {
+		metric := &dto.Metric{}
+		if err := m.Write(metric); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, l := range metric.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if want := map[string]string{"device": "/dev/dm-2", "mountpoint": "/", "fstype": "ext4"}; fmt.Sprint(want) != fmt.Sprint(labels) {
+			t.Errorf("want labels %v, got %v", want, labels)
+		}
+		values[m.Desc().String()] = metric.GetGauge().GetValue()
+	}
+	if want, got := 3, len(values); want != got {
+		t.Fatalf("want %d metrics, got %d", want, got)
+	}
+	var total, used, free float64
+	for desc, v := range values {
+		switch {
+		case strings.Contains(desc, "node_disk_inodes_total"):
+			total = v
+		case strings.Contains(desc, "node_disk_inode_used"):
+			used = v
+		case strings.Contains(desc, "node_disk_inode_free"):
+			free = v
+		}
+	}
+	if total == 0 || total != used+free {
+		t.Errorf("want total inodes %v to be used %v plus free %v", total, used, free)
+	}
+}
+
+func TestDiskStatsDeprecatedInodeDeviceExclude(t *testing.T) {
+	*sysPath = "fixtures/sys"
+	*procPath = "fixtures/proc"
+	*rootfsPath = "fixtures"
+	defer func() { *rootfsPath = "/" }()
+	*udevDataPath = "fixtures/udev/data"
+	*diskstatsInodeMountPointsExclude = "^/(dev|proc|sys)($|/)"
+	*diskstatsInodeFSTypesExclude = "^rootfs$"
+	*oldDiskstatsInodeDeviceExclude = "^/dev/dm-2$"
+	defer func() { *oldDiskstatsInodeDeviceExclude = "" }()
+	c, err := NewDiskstatsCollector(log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	if err := c.(*diskstatsCollector).updateInodes(ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	if got := len(ch); got != 0 {
+		t.Errorf("want no inode metrics of the excluded device, got %d", got)
+	}
+}
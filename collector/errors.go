@@ -0,0 +1,88 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errorLogInterval is the minimum time between two logs of the errors of the
+// same operation of a collector.
+const errorLogInterval = time.Minute
+
+var (
+	collectorErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scrape",
+		Name:      "collector_errors_total",
+		Help:      "Number of errors of collectors which didn't fail the whole collector, by operation.",
+	}, []string{"collector", "operation"})
+	// CollectorErrors collects the number of partial failures of collectors,
+	// e.g. of a single NVML call for one GPU.
+	CollectorErrors prometheus.Collector = collectorErrors
+)
+
+// errorReporter counts the errors of the operations of a collector which
+// don't fail the whole collector, and logs them at most once per
+// errorLogInterval and operation.
+type errorReporter struct {
+	collector string
+	logger    log.Logger
+	errors    *prometheus.CounterVec
+	now       func() time.Time
+
+	mtx        sync.Mutex
+	lastLog    map[string]time.Time
+	suppressed map[string]int
+}
+
+func newErrorReporter(collector string, logger log.Logger) *errorReporter {
+	return &errorReporter{
+		collector:  collector,
+		logger:     logger,
+		errors:     collectorErrors,
+		now:        time.Now,
+		lastLog:    map[string]time.Time{},
+		suppressed: map[string]int{},
+	}
+}
+
+// report counts err as an error of operation. keyvals are added to the log
+// line, e.g. the GPU or the process the operation failed for.
+func (r *errorReporter) report(operation string, err error, keyvals ...interface{}) {
+	r.errors.WithLabelValues(r.collector, operation).Inc()
+
+	r.mtx.Lock()
+	now := r.now()
+	if last, ok := r.lastLog[operation]; ok && now.Sub(last) < errorLogInterval {
+		r.suppressed[operation]++
+		r.mtx.Unlock()
+		return
+	}
+	suppressed := r.suppressed[operation]
+	r.lastLog[operation] = now
+	r.suppressed[operation] = 0
+	r.mtx.Unlock()
+
+	keyvals = append([]interface{}{"msg", "collector operation failed", "operation", operation, "err", err}, keyvals...)
+	if suppressed > 0 {
+		keyvals = append(keyvals, "suppressed", suppressed)
+	}
+	level.Warn(r.logger).Log(keyvals...)
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestErrorReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := newErrorReporter("gpu", log.NewLogfmtLogger(&buf))
+	r.errors = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors_total"}, []string{"collector", "operation"})
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+
+	r.report("DeviceGetFanSpeed", errors.New("not supported"), "gpu", "0")
+	r.report("DeviceGetFanSpeed", errors.New("not supported"), "gpu", "1")
+	r.report("DeviceGetPowerUsage", errors.New("not supported"), "gpu", "0")
+	now = now.Add(errorLogInterval)
+	r.report("DeviceGetFanSpeed", errors.New("not supported"), "gpu", "0")
+
+	if got := testutil.ToFloat64(r.errors.WithLabelValues("gpu", "DeviceGetFanSpeed")); got != 3 {
+		t.Errorf("want 3 DeviceGetFanSpeed errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(r.errors.WithLabelValues("gpu", "DeviceGetPowerUsage")); got != 1 {
+		t.Errorf("want 1 DeviceGetPowerUsage error, got %v", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want 3 log lines, got %d:\n%s", len(lines), buf.String())
+	}
+	if strings.Contains(lines[0], "suppressed") || !strings.Contains(lines[0], "operation=DeviceGetFanSpeed") {
+		t.Errorf("want the first error logged without suppressed errors, got %s", lines[0])
+	}
+	if !strings.Contains(lines[2], "suppressed=1") {
+		t.Errorf("want the suppressed error counted in the next log, got %s", lines[2])
+	}
+}
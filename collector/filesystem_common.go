@@ -20,6 +20,7 @@ package collector
 import (
 	"errors"
 	"regexp"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
@@ -63,12 +64,17 @@ var (
 	filesystemLabelNames = []string{"device", "mountpoint", "fstype"}
 )
 
+// filesystemMountTimeout returns how long statfs may take before a mount
+// point is marked as stuck, it is only set on Linux.
+var filesystemMountTimeout = func() time.Duration { return 0 }
+
 type filesystemCollector struct {
 	excludedMountPointsPattern    *regexp.Regexp
 	excludedFSTypesPattern        *regexp.Regexp
 	sizeDesc, freeDesc, availDesc *prometheus.Desc
 	filesDesc, filesFreeDesc      *prometheus.Desc
 	roDesc, deviceErrorDesc       *prometheus.Desc
+	mountTimeout                  time.Duration
 	logger                        log.Logger
 }
 
@@ -165,6 +171,7 @@ func NewFilesystemCollector(logger log.Logger) (Collector, error) {
 		filesFreeDesc:              filesFreeDesc,
 		roDesc:                     roDesc,
 		deviceErrorDesc:            deviceErrorDesc,
+		mountTimeout:               filesystemMountTimeout(),
 		logger:                     logger,
 	}, nil
 }
@@ -4,7 +4,7 @@
 package collector
 
 import (
-	"github.com/go-kit/kit/log"
+	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -38,7 +38,7 @@ func init() {
 
 // NewGpuCollector data come from nvidia-smi -q
 func NewGpuCollector(logger log.Logger) (Collector, error) {
-	info := gpuCache{}
+	info := gpuCache{errs: newErrorReporter("gpu", logger)}
 
 	return &gpuCollector{
 		info: info,
@@ -140,7 +140,7 @@ func NewGpuCollector(logger log.Logger) (Collector, error) {
 		gpuCount: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, gpuCollectorSubsystem, "gpuCount"),
 			"Number of GPUs.",
-			gpuCountNames, nil,
+			nil, nil,
 		),
 	}, nil
 }
@@ -160,31 +160,31 @@ func (this *gpuCollector) updateStat(ch chan<- prometheus.Metric) error {
 
 	seen := make(map[string]bool)
 	for _, gpuStat := range stats {
-		ch <- prometheus.MustNewConstMetric(this.total, prometheus.GaugeValue, float64(gpuStat.TotalMem/1024/1024), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.used, prometheus.GaugeValue, float64(gpuStat.UsedMem/1024/1024), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.free, prometheus.GaugeValue, float64(gpuStat.FreeMem/1024/1024), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.utilization, prometheus.GaugeValue, float64(gpuStat.Utilization), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.temp, prometheus.GaugeValue, float64(gpuStat.Temp), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.memUtilization, prometheus.GaugeValue, float64(gpuStat.MemUtilization), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.maxClock, prometheus.GaugeValue, float64(gpuStat.MaxClock), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.fanSpeed, prometheus.GaugeValue, float64(gpuStat.FanSpeed), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.computeRunningProcesses, prometheus.GaugeValue, float64(gpuStat.ComputeRunningProcesses), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.graphicsRunningProcesses, prometheus.GaugeValue, float64(gpuStat.GraphicsRunningProcesses), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.maxPcieLinkWidth, prometheus.GaugeValue, float64(gpuStat.MaxPcieLinkWidth), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.pcieThroughput, prometheus.GaugeValue, float64(gpuStat.PcieThroughput), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.performanceState, prometheus.GaugeValue, float64(gpuStat.PerformanceState), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.powerManagementDefLimit, prometheus.GaugeValue, gpuStat.PowerManagementDefLimit, gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.powerManagementLimit, prometheus.GaugeValue, gpuStat.PowerManagementLimit, gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.powerState, prometheus.GaugeValue, float64(gpuStat.PowerState), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.powerUsage, prometheus.GaugeValue, gpuStat.PowerUsage, gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		ch <- prometheus.MustNewConstMetric(this.temperatureThreshold, prometheus.GaugeValue, float64(gpuStat.TemperatureThreshold), gpuStat.Host, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
-		key := gpuStat.Host + gpuStat.Types
+		ch <- prometheus.MustNewConstMetric(this.total, prometheus.GaugeValue, float64(gpuStat.TotalMem/1024/1024), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.used, prometheus.GaugeValue, float64(gpuStat.UsedMem/1024/1024), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.free, prometheus.GaugeValue, float64(gpuStat.FreeMem/1024/1024), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.utilization, prometheus.GaugeValue, float64(gpuStat.Utilization), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.temp, prometheus.GaugeValue, float64(gpuStat.Temp), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.memUtilization, prometheus.GaugeValue, float64(gpuStat.MemUtilization), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.maxClock, prometheus.GaugeValue, float64(gpuStat.MaxClock), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.fanSpeed, prometheus.GaugeValue, float64(gpuStat.FanSpeed), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.computeRunningProcesses, prometheus.GaugeValue, float64(gpuStat.ComputeRunningProcesses), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.graphicsRunningProcesses, prometheus.GaugeValue, float64(gpuStat.GraphicsRunningProcesses), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.maxPcieLinkWidth, prometheus.GaugeValue, float64(gpuStat.MaxPcieLinkWidth), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.pcieThroughput, prometheus.GaugeValue, float64(gpuStat.PcieThroughput), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.performanceState, prometheus.GaugeValue, float64(gpuStat.PerformanceState), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.powerManagementDefLimit, prometheus.GaugeValue, gpuStat.PowerManagementDefLimit, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.powerManagementLimit, prometheus.GaugeValue, gpuStat.PowerManagementLimit, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.powerState, prometheus.GaugeValue, float64(gpuStat.PowerState), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.powerUsage, prometheus.GaugeValue, gpuStat.PowerUsage, gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		ch <- prometheus.MustNewConstMetric(this.temperatureThreshold, prometheus.GaugeValue, float64(gpuStat.TemperatureThreshold), gpuStat.ID, gpuStat.UUID, gpuStat.Types)
+		key := gpuStat.Types
 		if _, exists := seen[key]; exists {
 			continue // 如果已经收集过则跳过
 		}
 		seen[key] = true
-		ch <- prometheus.MustNewConstMetric(this.gpuCount, prometheus.GaugeValue, float64(GpuCount), gpuStat.Host)
-		ch <- prometheus.MustNewConstMetric(this.gpuDriverVersion, prometheus.GaugeValue, 1, gpuStat.Host, gpuStat.Types, GPUDriverVersion)
+		ch <- prometheus.MustNewConstMetric(this.gpuCount, prometheus.GaugeValue, float64(GpuCount))
+		ch <- prometheus.MustNewConstMetric(this.gpuDriverVersion, prometheus.GaugeValue, 1, gpuStat.Types, gpuStat.DriverVersion)
 	}
 	return nil
 }
@@ -8,7 +8,6 @@ const (
 )
 
 var (
-	gpuLabelNames        = []string{"hostname", "id", "uuid", "type"}
-	gpuGeneralLabelNames = []string{"hostname", "type", "gpuDriverVersion"}
-	gpuCountNames = []string{"hostname"}
+	gpuLabelNames        = []string{"id", "uuid", "type"}
+	gpuGeneralLabelNames = []string{"type", "gpuDriverVersion"}
 )
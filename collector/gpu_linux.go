@@ -3,43 +3,11 @@
 package collector
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"log"
 	"github.com/prometheus/node_exporter/nvml"
 	"math"
-	"os"
-	"os/exec"
 	"strconv"
 )
 
-var hostname string
-var GPUDriverVersion string
-
-func init() {
-	var err error
-	hostname, err = os.Hostname()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := nvml.Init(); err != nil {
-		fmt.Printf("nvml error: %+v", err)
-		return
-	}
-	defer nvml.Shutdown()
-	//获取驱动版本
-	ver, err := nvml.SystemGetDriverVersion()
-	if err != nil {
-		failedMsg("SystemGetDriverVersion", err)
-	} else {
-		fmt.Printf("SystemGetDriverVersion: %s\n", ver)
-		GPUDriverVersion = ver
-
-	}
-
-}
-
 type gpuInfo struct {
 	TotalMem                 uint64  //总的显存，单位是Byte
 	UsedMem                  uint64  //使用的显存,单位是Byte
@@ -60,13 +28,17 @@ type gpuInfo struct {
 	TemperatureThreshold     uint    //gpu温度限速阈值
 	Temp                     uint    //温度
 	gpuCount				 uint    //gpu数量
-	Host                     string
 	UUID                     string
 	ID                       string
 	Types                    string
+	DriverVersion            string
 }
 
-type gpuCache struct{}
+// gpuCache reads the GPUs from NVML. Failed NVML calls for a single GPU are
+// reported to errs and leave the value at 0.
+type gpuCache struct {
+	errs *errorReporter
+}
 var GpuCount uint;
 
 func (this gpuCache) Stat() ([]gpuInfo, error) {
@@ -82,39 +54,45 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 	)
 
 	if err := nvml.Init(); err != nil {
-		fmt.Printf("nvml error: %+v", err)
 		return nil, err
 	}
 	defer nvml.Shutdown()
 
+	//获取驱动版本
+	driverVersion, err := nvml.SystemGetDriverVersion()
+	if err != nil {
+		this.errs.report("SystemGetDriverVersion", err)
+	}
+
 	result = []gpuInfo{}
 	var tmp gpuInfo
 	num, err := nvml.DeviceGetCount()
 	GpuCount=num
 	if err != nil {
 		GpuCount=0
-		failedMsg("DeviceGetCount", err)
+		this.errs.report("DeviceGetCount", err)
 	}
 
 	for i := uint(0); i < num; i++ {
 		//fmt.Println("============")
-		tmp = gpuInfo{}
+		tmp = gpuInfo{DriverVersion: driverVersion}
 		dev, err := nvml.DeviceGetHandleByIndex(i)
 		if err != nil {
-			failedMsg("DeviceGetHandleByIndex", err)
+			this.errs.report("DeviceGetHandleByIndex", err, "gpu", i)
+			continue
 		}
 
 		//获取显卡的编号
 		minor, err := dev.DeviceGetMinorNumber()
 		if err != nil {
-			failedMsg("DeviceGetMinorNumber", err)
+			this.errs.report("DeviceGetMinorNumber", err, "gpu", i)
 		} else {
 			tmp.ID = strconv.Itoa(int(minor))
 		}
 		//获取GPU里面计算运行的进程数量
 		processes, err := dev.DeviceGetComputeRunningProcesses(32)
 		if err != nil {
-			failedMsg("DeviceGetComputeRunningProcesses", err)
+			this.errs.report("DeviceGetComputeRunningProcesses", err, "gpu", i)
 		} else {
 			tmp.ComputeRunningProcesses = len(processes)
 			//for _, proc := range processes {
@@ -125,7 +103,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//获取频率抑制的原因
 		//reasons, err := dev.DeviceGetCurrentClocksThrottleReasons()
 		//if err != nil {
-		//	failedMsg("DeviceGetCurrentClocksThrottleReasons", err)
+		//	this.errs.report("DeviceGetCurrentClocksThrottleReasons", err, "gpu", i)
 		//} else {
 		//	fmt.Printf("DeviceGetCurrentClocksThrottleReasons: %d\n", len(reasons))
 		//	for _, reason := range reasons {
@@ -136,7 +114,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//nvidia-smi 里面的Display.A，是否允许显示？
 		//display, err := dev.DeviceGetDisplayMode()
 		//if err != nil {
-		//	failedMsg("DeviceGetDisplayMode", err)
+		//	this.errs.report("DeviceGetDisplayMode", err, "gpu", i)
 		//} else {
 		//	fmt.Printf("DeviceGetDisplayMode: %+v\n", display)
 		//}
@@ -144,7 +122,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//电源的上限
 		//powerLimit, err := dev.DeviceGetEnforcedPowerLimit()
 		//if err != nil {
-		//	failedMsg("DeviceGetEnforcedPowerLimit", err)
+		//	this.errs.report("DeviceGetEnforcedPowerLimit", err, "gpu", i)
 		//} else {
 		//	fmt.Printf("DeviceGetEnforcedPowerLimit: %d\n", powerLimit)
 		//}
@@ -152,7 +130,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//风扇的速度，in %
 		speed, err := dev.DeviceGetFanSpeed()
 		if err != nil {
-			failedMsg("DeviceGetFanSpeed", err)
+			this.errs.report("DeviceGetFanSpeed", err, "gpu", i)
 		} else {
 			tmp.FanSpeed = speed
 		}
@@ -160,7 +138,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//显卡的运行程序?
 		gRunningProcs, err := dev.GetGraphicsRunningProcesses(10)
 		if err != nil {
-			failedMsg("GetGraphicsRunningProcesses", err)
+			this.errs.report("GetGraphicsRunningProcesses", err, "gpu", i)
 		} else {
 			tmp.GraphicsRunningProcesses = len(gRunningProcs)
 			//
@@ -172,7 +150,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//最大时钟频率?
 		maxClock, err := dev.DeviceGetMaxClockInfo(nvml.CLOCK_MEM)
 		if err != nil {
-			failedMsg("DeviceGetMaxClockInfo", err)
+			this.errs.report("DeviceGetMaxClockInfo", err, "gpu", i)
 		} else {
 			tmp.MaxClock = maxClock
 		}
@@ -180,7 +158,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//PCIE的带宽
 		maxWidth, err := dev.DeviceGetMaxPcieLinkWidth()
 		if err != nil {
-			failedMsg("DeviceGetMaxPcieLinkWidth", err)
+			this.errs.report("DeviceGetMaxPcieLinkWidth", err, "gpu", i)
 		} else {
 			tmp.MaxPcieLinkWidth = maxWidth
 		}
@@ -188,7 +166,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//显存的使用情况
 		memFree, memUsed, memTotal, err := dev.DeviceGetMemoryInfo()
 		if err != nil {
-			failedMsg("DeviceGetMemoryInfo", err)
+			this.errs.report("DeviceGetMemoryInfo", err, "gpu", i)
 		} else {
 			tmp.TotalMem = memTotal
 			tmp.FreeMem = memFree
@@ -199,7 +177,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//显卡名称
 		name, err := dev.DeviceGetName()
 		if err != nil {
-			failedMsg("DeviceGetName", err)
+			this.errs.report("DeviceGetName", err, "gpu", i)
 		} else {
 			//fmt.Printf("DeviceGetName: %s\n", name)
 			tmp.Types = name
@@ -208,7 +186,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//pcie的吞吐
 		throughput, err := dev.DeviceGetPcieThroughput(nvml.PCIE_UTIL_RX_BYTES)
 		if err != nil {
-			failedMsg("DeviceGetPcieThroughput", err)
+			this.errs.report("DeviceGetPcieThroughput", err, "gpu", i)
 		} else {
 			tmp.PcieThroughput = throughput
 		}
@@ -216,7 +194,7 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//性能状态
 		performState, err := dev.DeviceGetPerformanceState()
 		if err != nil {
-			failedMsg("DeviceGetPerformanceState", err)
+			this.errs.report("DeviceGetPerformanceState", err, "gpu", i)
 		} else {
 			tmp.PerformanceState = performState
 		}
@@ -224,42 +202,42 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//电源的管理默认最大值
 		powerManagementDefLimit, err := dev.DeviceGetPowerManagementDefaultLimit()
 		if err != nil {
-			failedMsg("DeviceGetPowerManagementDefaultLimit", err)
+			this.errs.report("DeviceGetPowerManagementDefaultLimit", err, "gpu", i)
 		} else {
 			tmp.PowerManagementDefLimit = float64(powerManagementDefLimit/1000) / math.Pow10(0)
 		}
 		//电源的管理最大值
 		powerManagementLimit, err := dev.DeviceGetPowerManagementLimit()
 		if err != nil {
-			failedMsg("DeviceGetPowerManagementLimit", err)
+			this.errs.report("DeviceGetPowerManagementLimit", err, "gpu", i)
 		} else {
 			tmp.PowerManagementLimit = float64(powerManagementLimit/1000) / math.Pow10(0)
 		}
 		//电源使用，值/1000 = 多少瓦，56255/1000 = 56W
 		powerUsage, err := dev.DeviceGetPowerUsage()
 		if err != nil {
-			failedMsg("DeviceGetPowerUsage", err)
+			this.errs.report("DeviceGetPowerUsage", err, "gpu", i)
 		} else {
 			tmp.PowerUsage = float64(powerUsage/1000) / math.Pow10(0)
 		}
 		//管理的上下限
 		//minLimit, maxLimit, err := dev.DeviceGetPowerManagementLimitConstraints()
 		//if err != nil {
-		//	failedMsg("DeviceGetPowerManagementLimitConstraints", err)
+		//	this.errs.report("DeviceGetPowerManagementLimitConstraints", err, "gpu", i)
 		//} else {
 		//	fmt.Printf("DeviceGetPowerManagementLimitConstraints: %d, %d\n", minLimit, maxLimit)
 		//}
 		//是否电源管理模式
 		//powerManagementMode, err := dev.DeviceGetPowerManagementMode()
 		//if err != nil {
-		//	failedMsg("DeviceGetPowerManagementMode", err)
+		//	this.errs.report("DeviceGetPowerManagementMode", err, "gpu", i)
 		//} else {
 		//	fmt.Printf("DeviceGetPowerManagementMode: %+v\n", powerManagementMode)
 		//}
 		//电源状态
 		powerState, err := dev.DeviceGetPowerState()
 		if err != nil {
-			failedMsg("DeviceGetPowerState", err)
+			this.errs.report("DeviceGetPowerState", err, "gpu", i)
 		} else {
 			//fmt.Printf("DeviceGetPowerState: %d\n", powerState)
 			tmp.PowerState = powerState
@@ -268,14 +246,14 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//GPU温度
 		temper, err := dev.DeviceGetTemperature()
 		if err != nil {
-			failedMsg("DeviceGetTemperature", err)
+			this.errs.report("DeviceGetTemperature", err, "gpu", i)
 		} else {
 			tmp.Temp = temper
 		}
 		//GPU温度限速阈值
 		temperThreshold, err := dev.DeviceGetTemperatureThreshold(nvml.TEMPERATURE_THRESHOLD_SLOWDOWN)
 		if err != nil {
-			failedMsg("DeviceGetTemperatureThreshold", err)
+			this.errs.report("DeviceGetTemperatureThreshold", err, "gpu", i)
 		} else {
 			tmp.TemperatureThreshold = temperThreshold
 		}
@@ -283,73 +261,20 @@ func (this gpuCache) Stat() ([]gpuInfo, error) {
 		//gpu的UUID
 		uuid, err := dev.DeviceGetUUID()
 		if err != nil {
-			failedMsg("DeviceGetUUID", err)
+			this.errs.report("DeviceGetUUID", err, "gpu", i)
 		} else {
 			tmp.UUID = uuid
 		}
 		util, err := dev.DeviceGetUtilizationRates()
 		if err != nil {
-			failedMsg("DeviceGetUtilizationRates", err)
+			this.errs.report("DeviceGetUtilizationRates", err, "gpu", i)
 		} else {
 			tmp.Utilization = util.GPU
 			//util.Memory是内存的使用率
 			tmp.MemUtilization = util.Memory
 		}
-		tmp.Host = hostname
 		result = append(result, tmp)
 	}
 
-	//for n, x := range strings.Split(data, "\n") {
-	//	// fmt.Println(n, n%15, n/15, x)
-	//	log.Debug(n, n%15, n/15, x)
-	//	if n%15 == 0 && n != 0 {
-	//		result = append(result, tmp)
-	//		tmp = gpuInfo{}
-	//		tmp.Host = hostname
-	//		tmp.Types = strings.TrimSpace(x)
-	//	} else if n%15 == 0 && n == 0 {
-	//		tmp = gpuInfo{}
-	//		tmp.Host = hostname
-	//		tmp.Types = strings.TrimSpace(x)
-	//	} else if n%15 == 1 {
-	//		tmp.UUID = strings.TrimSpace(x)
-	//	} else if n%15 == 2 {
-	//		tmp.Count = strings.TrimSpace(x)
-	//	} else if n%15 == 3 {
-	//		tmp.TotalMem, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(x, " ")[0]), 64)
-	//	} else if n%15 == 4 {
-	//		tmp.UsedMem, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(x, " ")[0]), 64)
-	//	} else if n%15 == 5 {
-	//		tmp.FreeMem, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(x, " ")[0]), 64)
-	//	} else if n%15 == 9 {
-	//		tmp.Utilization, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(x, " ")[0]), 64)
-	//	} else if n%15 == 14 {
-	//		tmp.Temp, _ = strconv.ParseFloat(strings.TrimSpace(strings.Split(x, " ")[0]), 64)
-	//	}
-	//}
 	return result, nil
 }
-
-func execCommand(cmd string) (string, error) {
-	pipeline := exec.Command("/bin/sh", "-c", cmd)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	pipeline.Stdout = &out
-	pipeline.Stderr = &stderr
-	err := pipeline.Run()
-	if err != nil {
-		return stderr.String(), err
-	}
-	return out.String(), nil
-}
-
-func test() {
-	tmp := gpuCache{}
-	x, err := tmp.Stat()
-	if err != nil {
-		panic(err)
-	}
-
-	xxx, _ := json.Marshal(x)
-	fmt.Println(string(xxx))
-}
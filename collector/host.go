@@ -0,0 +1,50 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os"
+	"strings"
+)
+
+// HostIdentity identifies the host the exporter runs on, so pushed metrics
+// can be attributed to it.
+type HostIdentity struct {
+	Hostname  string
+	MachineID string
+	DMISerial string
+}
+
+// ReadHostIdentity reads the identity of the host from the rootfs and sysfs
+// mountpoints. Values that can't be read are left empty, e.g. the DMI serial
+// is only readable by root on most systems.
+func ReadHostIdentity() HostIdentity {
+	var id HostIdentity
+	id.Hostname, _ = os.Hostname()
+	for _, p := range []string{"etc/machine-id", "var/lib/dbus/machine-id"} {
+		if id.MachineID = readTrimmed(rootfsFilePath(p)); id.MachineID != "" {
+			break
+		}
+	}
+	id.DMISerial = readTrimmed(sysFilePath("class/dmi/id/product_serial"))
+	return id
+}
+
+func readTrimmed(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
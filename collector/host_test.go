@@ -0,0 +1,50 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadHostIdentity(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"root/var/lib/dbus/machine-id":    "0123456789abcdef\n",
+		"sys/class/dmi/id/product_serial": "SN-42\n",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	*rootfsPath = filepath.Join(dir, "root")
+	*sysPath = filepath.Join(dir, "sys")
+	defer func() { *rootfsPath, *sysPath = "/", "/sys" }()
+
+	id := ReadHostIdentity()
+	if want, got := "0123456789abcdef", id.MachineID; want != got {
+		t.Errorf("want machine-id %q, got %q", want, got)
+	}
+	if want, got := "SN-42", id.DMISerial; want != got {
+		t.Errorf("want DMI serial %q, got %q", want, got)
+	}
+	if id.Hostname == "" {
+		t.Error("want hostname, got none")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+)
+
+// InetDiagSockID (inet_diag_sockid) contains the socket identity.
+// https://github.com/torvalds/linux/blob/v4.0/include/uapi/linux/inet_diag.h#L13
+type InetDiagSockID struct {
+	SourcePort [2]byte
+	DestPort   [2]byte
+	SourceIP   [4][4]byte
+	DestIP     [4][4]byte
+	Interface  uint32
+	Cookie     [2]uint32
+}
+
+// InetDiagReqV2 (inet_diag_req_v2) is used to request diagnostic data.
+// https://github.com/torvalds/linux/blob/v4.0/include/uapi/linux/inet_diag.h#L37
+type InetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       InetDiagSockID
+}
+
+const sizeOfDiagRequest = 0x38
+
+func (req *InetDiagReqV2) Serialize() []byte {
+	return (*(*[sizeOfDiagRequest]byte)(unsafe.Pointer(req)))[:]
+}
+
+func (req *InetDiagReqV2) Len() int {
+	return sizeOfDiagRequest
+}
+
+type InetDiagMsg struct {
+	Family  uint8
+	State   uint8
+	Timer   uint8
+	Retrans uint8
+	ID      InetDiagSockID
+	Expires uint32
+	RQueue  uint32
+	WQueue  uint32
+	UID     uint32
+	Inode   uint32
+}
+
+func parseInetDiagMsg(b []byte) *InetDiagMsg {
+	return (*InetDiagMsg)(unsafe.Pointer(&b[0]))
+}
+
+// sizeOfDiagMsg is the size of the inet_diag_msg header of each socket in a
+// dump, netlink attributes follow it.
+const sizeOfDiagMsg = int(unsafe.Sizeof(InetDiagMsg{}))
+
+const (
+	// inetDiagAllStates selects the sockets in all states of a dump, other
+	// masks have the bit 1<<state set for each selected tcpConnectionState.
+	inetDiagAllStates = 0xFFF
+	// inetDiagInfo is the INET_DIAG_INFO attribute holding the tcp_info.
+	inetDiagInfo = 2
+)
+
+// inetDiagDump dumps the sockets of a family and protocol in the states set
+// in states, requesting the extensions set in ext, e.g. 1<<(inetDiagInfo-1).
+func inetDiagDump(conn *netlink.Conn, family, protocol, ext uint8, states uint32) ([]netlink.Message, error) {
+	const sockDiagByFamily = 20
+
+	msg := netlink.Message{
+		Header: netlink.Header{
+			Type:  sockDiagByFamily,
+			Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_DUMP,
+		},
+		Data: (&InetDiagReqV2{
+			Family:   family,
+			Protocol: protocol,
+			States:   states,
+			Ext:      ext,
+		}).Serialize(),
+	}
+	return conn.Execute(msg)
+}
@@ -0,0 +1,252 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nolisteners
+// +build !nolisteners
+
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"github.com/go-kit/log"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+const listenersSubsystem = "listeners"
+
+var listenerLabelNames = []string{"protocol", "family", "address", "port", "pid", "comm"}
+
+// listenersCollector exports an inventory of the listening TCP sockets and
+// bound UDP sockets, together with the process holding them open.
+type listenersCollector struct {
+	fs     procfs.FS
+	errs   *errorReporter
+	logger log.Logger
+
+	sockets     *prometheus.Desc
+	info        *prometheus.Desc
+	acceptQueue *prometheus.Desc
+	backlog     *prometheus.Desc
+	rxQueue     *prometheus.Desc
+}
+
+// listenerKey identifies one listener. Sockets sharing an address with
+// SO_REUSEPORT in the same process are reported as one listener.
+type listenerKey struct {
+	protocol string
+	family   string
+	address  string
+	port     uint16
+	pid      int
+}
+
+type listenerStats struct {
+	// acceptQueue and backlog are the current and maximum length of the
+	// accept queue of a listening TCP socket.
+	acceptQueue uint64
+	backlog     uint64
+	// hasBacklog is false if inet_diag didn't report the listener.
+	hasBacklog bool
+	// rxQueue is the memory used by the receive queue of a UDP socket.
+	rxQueue uint64
+}
+
+func init() {
+	registerCollector(listenersSubsystem, defaultDisabled, NewListenersCollector)
+}
+
+// NewListenersCollector returns a new Collector exposing the listening
+// sockets and their owning processes.
+func NewListenersCollector(logger log.Logger) (Collector, error) {
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+	return &listenersCollector{
+		fs:     fs,
+		errs:   newErrorReporter(listenersSubsystem, logger),
+		logger: logger,
+		sockets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, listenersSubsystem, "sockets"),
+			"Number of listening TCP sockets and bound UDP sockets.",
+			[]string{"protocol", "family"}, nil,
+		),
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, listenersSubsystem, "info"),
+			"Listening TCP socket or bound UDP socket and the process holding it open, pid and comm are empty if the owner couldn't be determined.",
+			listenerLabelNames, nil,
+		),
+		acceptQueue: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, listenersSubsystem, "accept_queue_length"),
+			"Number of connections waiting to be accepted by a listening TCP socket.",
+			listenerLabelNames, nil,
+		),
+		backlog: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, listenersSubsystem, "accept_queue_backlog"),
+			"Maximum length of the accept queue of a listening TCP socket.",
+			listenerLabelNames, nil,
+		),
+		rxQueue: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, listenersSubsystem, "receive_queue_bytes"),
+			"Memory allocated for datagrams queued on a bound UDP socket in bytes.",
+			listenerLabelNames, nil,
+		),
+	}, nil
+}
+
+func (c *listenersCollector) Update(ch chan<- prometheus.Metric) error {
+	table, err := readSocketTable(c.fs, true)
+	if err != nil {
+		return fmt.Errorf("couldn't read sockets: %w", err)
+	}
+
+	// The backlog is only reported by inet_diag, /proc/net/tcp shows the
+	// unacknowledged bytes of the send queue as tx_queue.
+	queues, err := readTCPListenQueues()
+	if err != nil {
+		c.errs.report("inet_diag", err)
+	}
+
+	listeners, counts := groupListeners(table, queues)
+	for family, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.sockets, prometheus.GaugeValue, float64(n), family.protocol, family.family)
+	}
+
+	comms := map[int]string{}
+	for key, stats := range listeners {
+		pid, comm := "", ""
+		if key.pid != 0 {
+			pid = strconv.Itoa(key.pid)
+			if _, ok := comms[key.pid]; !ok {
+				comms[key.pid] = c.comm(key.pid)
+			}
+			comm = comms[key.pid]
+		}
+		labels := []string{key.protocol, key.family, key.address, strconv.Itoa(int(key.port)), pid, comm}
+		ch <- prometheus.MustNewConstMetric(c.info, prometheus.GaugeValue, 1, labels...)
+		if key.protocol == "tcp" {
+			ch <- prometheus.MustNewConstMetric(c.acceptQueue, prometheus.GaugeValue, float64(stats.acceptQueue), labels...)
+			if stats.hasBacklog {
+				ch <- prometheus.MustNewConstMetric(c.backlog, prometheus.GaugeValue, float64(stats.backlog), labels...)
+			}
+		} else {
+			ch <- prometheus.MustNewConstMetric(c.rxQueue, prometheus.GaugeValue, float64(stats.rxQueue), labels...)
+		}
+	}
+	return nil
+}
+
+func (c *listenersCollector) comm(pid int) string {
+	p, err := c.fs.Proc(pid)
+	if err != nil {
+		return ""
+	}
+	comm, err := p.Comm()
+	if err != nil {
+		return ""
+	}
+	return comm
+}
+
+type listenerFamily struct {
+	protocol string
+	family   string
+}
+
+// tcpListenQueue is the accept queue of a listening TCP socket.
+type tcpListenQueue struct {
+	length  uint64
+	backlog uint64
+}
+
+// readTCPListenQueues returns the accept queues of the listening TCP sockets
+// by inode.
+func readTCPListenQueues() (map[uint64]tcpListenQueue, error) {
+	conn, err := netlink.Dial(syscall.NETLINK_INET_DIAG, nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect netlink: %w", err)
+	}
+	defer conn.Close()
+
+	queues := map[uint64]tcpListenQueue{}
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		msgs, err := inetDiagDump(conn, family, syscall.IPPROTO_TCP, 0, 1<<tcpListen)
+		if err != nil {
+			return nil, err
+		}
+		parseTCPListenQueues(msgs, queues)
+	}
+	return queues, nil
+}
+
+// parseTCPListenQueues adds the queues of a dump of listening sockets. For
+// them the kernel reports sk_ack_backlog as idiag_rqueue and
+// sk_max_ack_backlog as idiag_wqueue.
+func parseTCPListenQueues(msgs []netlink.Message, queues map[uint64]tcpListenQueue) {
+	for _, m := range msgs {
+		if len(m.Data) < sizeOfDiagMsg {
+			continue
+		}
+		msg := parseInetDiagMsg(m.Data)
+		if tcpConnectionState(msg.State) != tcpListen {
+			continue
+		}
+		queues[uint64(msg.Inode)] = tcpListenQueue{length: uint64(msg.RQueue), backlog: uint64(msg.WQueue)}
+	}
+}
+
+// groupListeners returns the listeners of the socket table and the number of
+// listening sockets per protocol and family. queues are the accept queues of
+// the listening TCP sockets by inode, if known.
+func groupListeners(t *socketTable, queues map[uint64]tcpListenQueue) (map[listenerKey]*listenerStats, map[listenerFamily]int) {
+	listeners := map[listenerKey]*listenerStats{}
+	counts := map[listenerFamily]int{}
+	for _, s := range t.sockets {
+		if !s.listening() {
+			continue
+		}
+		counts[listenerFamily{s.protocol, s.family}]++
+
+		key := listenerKey{
+			protocol: s.protocol,
+			family:   s.family,
+			address:  s.localAddr.String(),
+			port:     s.localPort,
+			pid:      s.pid,
+		}
+		stats, ok := listeners[key]
+		if !ok {
+			stats = &listenerStats{}
+			listeners[key] = stats
+		}
+		// For listening TCP sockets /proc reports the accept queue length
+		// as rx_queue, inet_diag reports the backlog as well.
+		if s.protocol == "tcp" {
+			if q, ok := queues[s.inode]; ok {
+				stats.acceptQueue += q.length
+				stats.backlog += q.backlog
+				stats.hasBacklog = true
+			} else {
+				stats.acceptQueue += s.rxQueue
+			}
+		} else {
+			stats.rxQueue += s.rxQueue
+		}
+	}
+	return listeners, counts
+}
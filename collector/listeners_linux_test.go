@@ -0,0 +1,133 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nolisteners
+// +build !nolisteners
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/josharian/native"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/procfs"
+)
+
+func TestGroupListeners(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := readSocketTable(fs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.byInode[1003].pid = 42
+
+	listeners, counts := groupListeners(table, nil)
+	wantCounts := map[listenerFamily]int{
+		{"tcp", "ipv4"}: 2,
+		{"tcp", "ipv6"}: 1,
+		{"udp", "ipv4"}: 1,
+	}
+	if len(counts) != len(wantCounts) {
+		t.Errorf("want counts %v, got %v", wantCounts, counts)
+	}
+	for family, want := range wantCounts {
+		if got := counts[family]; want != got {
+			t.Errorf("%v: want %d sockets, got %d", family, want, got)
+		}
+	}
+
+	for key, want := range map[listenerKey]listenerStats{
+		{"tcp", "ipv4", "0.0.0.0", 22, 0}:      {},
+		{"tcp", "ipv4", "127.0.0.1", 3306, 42}: {},
+		{"tcp", "ipv6", "::", 9100, 0}:         {},
+		{"udp", "ipv4", "0.0.0.0", 22, 0}:      {},
+	} {
+		got, ok := listeners[key]
+		if !ok {
+			t.Errorf("listener %+v not found", key)
+			continue
+		}
+		if want != *got {
+			t.Errorf("%+v: want %+v, got %+v", key, want, *got)
+		}
+	}
+	if want, got := 4, len(listeners); want != got {
+		t.Errorf("want %d listeners, got %d", want, got)
+	}
+}
+
+func TestGroupListenersReusePort(t *testing.T) {
+	table := &socketTable{}
+	// /proc/net/tcp shows the accept queue as rx_queue and write_seq -
+	// snd_una, always 0, as tx_queue of listening sockets.
+	for i, rx := range []uint64{3, 5} {
+		table.sockets = append(table.sockets, &socketEntry{
+			protocol:  "tcp",
+			family:    "ipv4",
+			localAddr: net.IPv4zero,
+			localPort: 443,
+			state:     tcpListen,
+			rxQueue:   rx,
+			inode:     uint64(100 + i),
+			pid:       7,
+		})
+	}
+
+	listeners, counts := groupListeners(table, nil)
+	if want, got := 2, counts[listenerFamily{"tcp", "ipv4"}]; want != got {
+		t.Errorf("want %d sockets, got %d", want, got)
+	}
+	key := listenerKey{"tcp", "ipv4", "0.0.0.0", 443, 7}
+	want := listenerStats{acceptQueue: 8}
+	if got := listeners[key]; got == nil || *got != want {
+		t.Errorf("want %+v without inet_diag, got %+v", want, got)
+	}
+
+	queues := map[uint64]tcpListenQueue{
+		100: {length: 4, backlog: 128},
+		101: {length: 5, backlog: 128},
+	}
+	listeners, _ = groupListeners(table, queues)
+	want = listenerStats{acceptQueue: 9, backlog: 256, hasBacklog: true}
+	if got := listeners[key]; got == nil || *got != want {
+		t.Errorf("want %+v with inet_diag, got %+v", want, got)
+	}
+}
+
+func TestParseTCPListenQueues(t *testing.T) {
+	encode := func(m InetDiagMsg) netlink.Message {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, native.Endian, m); err != nil {
+			t.Fatal(err)
+		}
+		return netlink.Message{Data: buf.Bytes()}
+	}
+	queues := map[uint64]tcpListenQueue{}
+	parseTCPListenQueues([]netlink.Message{
+		encode(InetDiagMsg{Family: syscall.AF_INET, State: uint8(tcpListen), RQueue: 2, WQueue: 4096, Inode: 7}),
+		encode(InetDiagMsg{Family: syscall.AF_INET, State: uint8(tcpEstablished), RQueue: 10, WQueue: 20, Inode: 8}),
+		{Data: []byte{1, 2}},
+	}, queues)
+	want := map[uint64]tcpListenQueue{7: {length: 2, backlog: 4096}}
+	if len(queues) != len(want) || queues[7] != want[7] {
+		t.Errorf("want %+v, got %+v", want, queues)
+	}
+}
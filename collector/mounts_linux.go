@@ -0,0 +1,147 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/sys/unix"
+)
+
+// The mount table helpers are shared by the filesystem, diskstats and btrfs
+// collectors, so they are built regardless of the collector build tags.
+
+const (
+	defMountPointsExcluded = "^/(dev|proc|run/credentials/.+|sys|var/lib/docker/.+|var/lib/containers/storage/.+)($|/)"
+	defFSTypesExcluded     = "^(autofs|binfmt_misc|bpf|cgroup2?|configfs|debugfs|devpts|devtmpfs|fusectl|hugetlbfs|iso9660|mqueue|nsfs|overlay|proc|procfs|pstore|rpc_pipefs|securityfs|selinuxfs|squashfs|sysfs|tracefs)$"
+)
+
+var stuckMounts = make(map[string]struct{})
+var stuckMountsMtx = &sync.Mutex{}
+
+// mountPointLabels describes an entry of the mount table.
+type mountPointLabels struct {
+	device, mountPoint, fsType, options string
+}
+
+// errMountPointStuck is returned by statfsMountPoint for mount points that
+// timed out before and are not monitored until they recover.
+var errMountPointStuck = errors.New("mount point is in an unresponsive state")
+
+// statfsMountPoint calls statfs() on a mount point, marking it as stuck if it
+// doesn't respond within timeout.
+func statfsMountPoint(mountPoint string, timeout time.Duration, logger log.Logger) (*unix.Statfs_t, error) {
+	stuckMountsMtx.Lock()
+	if _, ok := stuckMounts[mountPoint]; ok {
+		level.Debug(logger).Log("msg", "Mount point is in an unresponsive state", "mountpoint", mountPoint)
+		stuckMountsMtx.Unlock()
+		return nil, errMountPointStuck
+	}
+	stuckMountsMtx.Unlock()
+
+	// The success channel is used do tell the "watcher" that the stat
+	// finished successfully. The channel is closed on success.
+	success := make(chan struct{})
+	go stuckMountWatcher(mountPoint, timeout, success, logger)
+
+	buf := new(unix.Statfs_t)
+	err := unix.Statfs(rootfsFilePath(mountPoint), buf)
+	stuckMountsMtx.Lock()
+	close(success)
+	// If the mount has been marked as stuck, unmark it and log it's recovery.
+	if _, ok := stuckMounts[mountPoint]; ok {
+		level.Debug(logger).Log("msg", "Mount point has recovered, monitoring will resume", "mountpoint", mountPoint)
+		delete(stuckMounts, mountPoint)
+	}
+	stuckMountsMtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// stuckMountWatcher listens on the given success channel and if the channel closes
+// then the watcher does nothing. If instead the timeout is reached, the
+// mount point that is being watched is marked as stuck.
+func stuckMountWatcher(mountPoint string, timeout time.Duration, success chan struct{}, logger log.Logger) {
+	mountCheckTimer := time.NewTimer(timeout)
+	defer mountCheckTimer.Stop()
+	select {
+	case <-success:
+		// Success
+	case <-mountCheckTimer.C:
+		// Timed out, mark mount as stuck
+		stuckMountsMtx.Lock()
+		select {
+		case <-success:
+			// Success came in just after the timeout was reached, don't label the mount as stuck
+		default:
+			level.Debug(logger).Log("msg", "Mount point timed out, it is being labeled as stuck and will not be monitored", "mountpoint", mountPoint)
+			stuckMounts[mountPoint] = struct{}{}
+		}
+		stuckMountsMtx.Unlock()
+	}
+}
+
+// mountPointDetails returns the mount points of the root mount namespace.
+func mountPointDetails(logger log.Logger) ([]mountPointLabels, error) {
+	file, err := os.Open(procFilePath("1/mounts"))
+	if errors.Is(err, os.ErrNotExist) {
+		// Fallback to `/proc/mounts` if `/proc/1/mounts` is missing due hidepid.
+		level.Debug(logger).Log("msg", "Reading root mounts failed, falling back to system mounts", "err", err)
+		file, err = os.Open(procFilePath("mounts"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseFilesystemLabels(file)
+}
+
+func parseFilesystemLabels(r io.Reader) ([]mountPointLabels, error) {
+	var filesystems []mountPointLabels
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+
+		if len(parts) < 4 {
+			return nil, fmt.Errorf("malformed mount point information: %q", scanner.Text())
+		}
+
+		// Ensure we handle the translation of \040 and \011
+		// as per fstab(5).
+		parts[1] = strings.Replace(parts[1], "\\040", " ", -1)
+		parts[1] = strings.Replace(parts[1], "\\011", "\t", -1)
+
+		filesystems = append(filesystems, mountPointLabels{
+			device:     parts[0],
+			mountPoint: rootfsStripPrefix(parts[1]),
+			fsType:     parts[2],
+			options:    parts[3],
+		})
+	}
+
+	return filesystems, scanner.Err()
+}
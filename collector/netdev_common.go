@@ -39,11 +39,18 @@ var (
 	netdevDetailedMetrics  = kingpin.Flag("collector.netdev.enable-detailed-metrics", "Use (incompatible) metric names that provide more detailed stats on Linux").Bool()
 )
 
+// netDevUseNetlink returns whether getNetDevStats reads the stats with
+// netlink, only Linux has the option.
+var netDevUseNetlink = func() bool { return false }
+
 type netDevCollector struct {
 	subsystem        string
 	deviceFilter     deviceFilter
 	metricDescsMutex sync.Mutex
 	metricDescs      map[string]*prometheus.Desc
+	addressInfo      bool
+	detailedMetrics  bool
+	netlink          bool
 	logger           log.Logger
 }
 
@@ -86,10 +93,13 @@ func NewNetDevCollector(logger log.Logger) (Collector, error) {
 	}
 
 	return &netDevCollector{
-		subsystem:    "network",
-		deviceFilter: newDeviceFilter(*netdevDeviceExclude, *netdevDeviceInclude),
-		metricDescs:  map[string]*prometheus.Desc{},
-		logger:       logger,
+		subsystem:       "network",
+		deviceFilter:    newDeviceFilter(*netdevDeviceExclude, *netdevDeviceInclude),
+		metricDescs:     map[string]*prometheus.Desc{},
+		addressInfo:     *netdevAddressInfo,
+		detailedMetrics: *netdevDetailedMetrics,
+		netlink:         netDevUseNetlink(),
+		logger:          logger,
 	}, nil
 }
 
@@ -110,12 +120,12 @@ func (c *netDevCollector) metricDesc(key string) *prometheus.Desc {
 }
 
 func (c *netDevCollector) Update(ch chan<- prometheus.Metric) error {
-	netDev, err := getNetDevStats(&c.deviceFilter, c.logger)
+	netDev, err := getNetDevStats(&c.deviceFilter, c.netlink, c.logger)
 	if err != nil {
 		return fmt.Errorf("couldn't get netstats: %w", err)
 	}
 	for dev, devStats := range netDev {
-		if !*netdevDetailedMetrics {
+		if !c.detailedMetrics {
 			legacy(devStats)
 		}
 		for key, value := range devStats {
@@ -123,7 +133,7 @@ func (c *netDevCollector) Update(ch chan<- prometheus.Metric) error {
 			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), dev)
 		}
 	}
-	if *netdevAddressInfo {
+	if c.addressInfo {
 		interfaces, err := net.Interfaces()
 		if err != nil {
 			return fmt.Errorf("could not get network interfaces: %w", err)
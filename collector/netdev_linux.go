@@ -30,8 +30,12 @@ var (
 	netDevNetlink = kingpin.Flag("collector.netdev.netlink", "Use netlink to gather stats instead of /proc/net/dev.").Default("true").Bool()
 )
 
-func getNetDevStats(filter *deviceFilter, logger log.Logger) (netDevStats, error) {
-	if *netDevNetlink {
+func init() {
+	netDevUseNetlink = func() bool { return *netDevNetlink }
+}
+
+func getNetDevStats(filter *deviceFilter, netlink bool, logger log.Logger) (netDevStats, error) {
+	if netlink {
 		return netlinkStats(filter, logger)
 	}
 	return procNetDevStats(filter, logger)
@@ -18,19 +18,18 @@ package collector
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
-	"gopkg.in/alecthomas/kingpin.v2"
+	"github.com/prometheus/procfs"
 )
 
 const (
@@ -42,6 +41,7 @@ var (
 )
 
 type netStatCollector struct {
+	fs           procfs.FS
 	fieldPattern *regexp.Regexp
 	logger       log.Logger
 }
@@ -53,8 +53,13 @@ func init() {
 // NewNetStatCollector takes and returns
 // a new Collector exposing network stats.
 func NewNetStatCollector(logger log.Logger) (Collector, error) {
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
 	pattern := regexp.MustCompile(*netStatFields)
 	return &netStatCollector{
+		fs:           fs,
 		fieldPattern: pattern,
 		logger:       logger,
 	}, nil
@@ -75,7 +80,7 @@ func (c *netStatCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 	// Merge the results of snmpStats into netStats (collisions are possible, but
 	// we know that the keys are always unique for the given use case).
-	netListens, err := getNetListen()
+	netListens, err := getNetListen(c.fs)
 	if err != nil {
 		return fmt.Errorf("couldn't get netListens: %w", err)
 	}
@@ -96,10 +101,14 @@ func (c *netStatCollector) Update(ch chan<- prometheus.Metric) error {
 			if !c.fieldPattern.MatchString(key) {
 				continue
 			}
+			help := fmt.Sprintf("Statistic %s.", protocol+name)
+			if key == "NetStatExt_ListenNum" {
+				help = "DEPRECATED: Use node_listeners_sockets{protocol=\"tcp\"}. Number of listening TCP sockets."
+			}
 			ch <- prometheus.MustNewConstMetric(
 				prometheus.NewDesc(
 					prometheus.BuildFQName(namespace, netStatsSubsystem, key),
-					fmt.Sprintf("Statistic %s.", protocol+name),
+					help,
 					nil, nil,
 				),
 				prometheus.UntypedValue, v,
@@ -109,21 +118,23 @@ func (c *netStatCollector) Update(ch chan<- prometheus.Metric) error {
 	return nil
 }
 
-func getNetListen() (map[string]string, error) {
-	var (
-		netListens = map[string]string{}
-	)
-	netListenNum, err := execCommandBash(`grep ' 0A ' /proc/net/{tcp,tcp6,udp,udp6} | wc -l`)
+// getNetListen counts the listening TCP sockets. It is kept for the
+// deprecated NetStatExt_ListenNum, the listeners collector exports them by
+// protocol and family.
+func getNetListen(fs procfs.FS) (map[string]string, error) {
+	table, err := readSocketTable(fs, false)
 	if err != nil {
-		fmt.Errorf("couldn't get netListenNum: %w", err)
 		return nil, err
 	}
-
-	netListens["ListenNum"] = strings.TrimSpace(netListenNum)
-	return netListens, nil
+	listening := 0
+	for _, s := range table.sockets {
+		if s.protocol == "tcp" && s.listening() {
+			listening++
+		}
+	}
+	return map[string]string{"ListenNum": strconv.Itoa(listening)}, nil
 }
 
-
 func getNetStats(fileName string) (map[string]map[string]string, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -199,15 +210,3 @@ func parseSNMP6Stats(r io.Reader) (map[string]map[string]string, error) {
 
 	return netStats, scanner.Err()
 }
-func execCommandBash(cmd string) (string, error) {
-	pipeline := exec.Command("/bin/bash", "-c", cmd)
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	pipeline.Stdout = &out
-	pipeline.Stderr = &stderr
-	err := pipeline.Run()
-	if err != nil {
-		return stderr.String(), err
-	}
-	return out.String(), nil
-}
\ No newline at end of file
@@ -16,6 +16,8 @@ package collector
 import (
 	"os"
 	"testing"
+
+	"github.com/prometheus/procfs"
 )
 
 func TestNetStats(t *testing.T) {
@@ -94,3 +96,17 @@ func testSNMP6Stats(t *testing.T, fileName string) {
 		t.Errorf("want netstat Udp6 SndbufErrors %s, got %s", want, got)
 	}
 }
+
+func TestNetListen(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	netListens, err := getNetListen(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "3", netListens["ListenNum"]; want != got {
+		t.Errorf("want listening sockets %s, got %s", want, got)
+	}
+}
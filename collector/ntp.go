@@ -51,6 +51,9 @@ var (
 
 type ntpCollector struct {
 	stratum, leap, rtt, offset, reftime, rootDelay, rootDispersion, sanity typedDesc
+	server                                                                 string
+	queryOptions                                                           ntp.QueryOptions
+	offsetTolerance, maxDistance                                           time.Duration
 	logger                                                                 log.Logger
 }
 
@@ -122,17 +125,21 @@ func NewNtpCollector(logger log.Logger) (Collector, error) {
 			"NTPD sanity according to RFC5905 heuristics and configured limits.",
 			nil, nil,
 		), prometheus.GaugeValue},
-		logger: logger,
+		server: *ntpServer,
+		queryOptions: ntp.QueryOptions{
+			Version: *ntpProtocolVersion,
+			TTL:     *ntpIPTTL,
+			Timeout: time.Second, // default `ntpdate` timeout
+			Port:    *ntpServerPort,
+		},
+		offsetTolerance: *ntpOffsetTolerance,
+		maxDistance:     *ntpMaxDistance,
+		logger:          logger,
 	}, nil
 }
 
 func (c *ntpCollector) Update(ch chan<- prometheus.Metric) error {
-	resp, err := ntp.QueryWithOptions(*ntpServer, ntp.QueryOptions{
-		Version: *ntpProtocolVersion,
-		TTL:     *ntpIPTTL,
-		Timeout: time.Second, // default `ntpdate` timeout
-		Port:    *ntpServerPort,
-	})
+	resp, err := ntp.QueryWithOptions(c.server, c.queryOptions)
 	if err != nil {
 		return fmt.Errorf("couldn't get SNTP reply: %w", err)
 	}
@@ -156,7 +163,7 @@ func (c *ntpCollector) Update(ch chan<- prometheus.Metric) error {
 	// Here is SNTP packet sanity check that is exposed to move burden of
 	// configuration from node_exporter user to the developer.
 
-	maxerr := *ntpOffsetTolerance
+	maxerr := c.offsetTolerance
 	leapMidnightMutex.Lock()
 	if resp.Leap == ntp.LeapAddSecond || resp.Leap == ntp.LeapDelSecond {
 		// state of leapMidnight is cached as leap flag is dropped right after midnight
@@ -168,7 +175,7 @@ func (c *ntpCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 	leapMidnightMutex.Unlock()
 
-	if resp.Validate() == nil && resp.RootDistance <= *ntpMaxDistance && resp.MinError <= maxerr {
+	if resp.Validate() == nil && resp.RootDistance <= c.maxDistance && resp.MinError <= maxerr {
 		ch <- c.sanity.mustNewConstMetric(1)
 	} else {
 		ch <- c.sanity.mustNewConstMetric(0)
@@ -0,0 +1,200 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/josharian/native"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+// pidsDelayStats is the time a process spent waiting, in seconds.
+type pidsDelayStats struct {
+	// cpu is the time spent runnable on a run queue.
+	cpu float64
+	// blkio is the time spent waiting for synchronous block I/O.
+	blkio float64
+	// swapin is the time spent waiting for pages to be swapped in, only
+	// known from taskstats.
+	swapin    float64
+	hasSwapin bool
+}
+
+// pidsDelayReader reads delay accounting through the taskstats genetlink
+// family. Taskstats needs CAP_NET_ADMIN and the initial network namespace,
+// without it the run queue delay is read from /proc/<pid>/task/*/schedstat and
+// the block I/O delay from /proc/<pid>/stat.
+type pidsDelayReader struct {
+	fs     procfs.FS
+	logger log.Logger
+
+	mtx    sync.Mutex
+	conn   *genetlink.Conn
+	family genetlink.Family
+}
+
+func newPidsDelayReader(fs procfs.FS, logger log.Logger) *pidsDelayReader {
+	r := &pidsDelayReader{fs: fs, logger: logger}
+	conn, err := genetlink.Dial(nil)
+	if err != nil {
+		level.Debug(logger).Log("msg", "taskstats unavailable, falling back to schedstat", "err", err)
+		return r
+	}
+	family, err := conn.GetFamily(unix.TASKSTATS_GENL_NAME)
+	if err != nil {
+		conn.Close()
+		level.Debug(logger).Log("msg", "taskstats unavailable, falling back to schedstat", "err", err)
+		return r
+	}
+	r.conn, r.family = conn, family
+	return r
+}
+
+// close releases the taskstats connection.
+func (r *pidsDelayReader) close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+func (r *pidsDelayReader) read(pid int) (pidsDelayStats, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.conn != nil {
+		stats, err := r.taskstats(pid)
+		if err == nil {
+			return stats, nil
+		}
+		if !errors.Is(err, unix.EPERM) {
+			return stats, err
+		}
+		// Don't retry without the capability on every scrape.
+		level.Debug(r.logger).Log("msg", "taskstats not permitted, falling back to schedstat", "err", err)
+		r.conn.Close()
+		r.conn = nil
+	}
+	return r.procDelay(pid)
+}
+
+func (r *pidsDelayReader) taskstats(pid int) (pidsDelayStats, error) {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.TASKSTATS_CMD_ATTR_TGID, uint32(pid))
+	data, err := ae.Encode()
+	if err != nil {
+		return pidsDelayStats{}, err
+	}
+	msgs, err := r.conn.Execute(genetlink.Message{
+		Header: genetlink.Header{
+			Command: unix.TASKSTATS_CMD_GET,
+			Version: r.family.Version,
+		},
+		Data: data,
+	}, r.family.ID, netlink.Request)
+	if err != nil {
+		return pidsDelayStats{}, err
+	}
+	return parseTaskstats(msgs)
+}
+
+// parseTaskstats extracts the delays from the struct taskstats nested in a
+// TASKSTATS_TYPE_AGGR_TGID reply.
+func parseTaskstats(msgs []genetlink.Message) (pidsDelayStats, error) {
+	for _, m := range msgs {
+		ad, err := netlink.NewAttributeDecoder(m.Data)
+		if err != nil {
+			return pidsDelayStats{}, err
+		}
+		for ad.Next() {
+			if ad.Type() != unix.TASKSTATS_TYPE_AGGR_TGID {
+				continue
+			}
+			nad, err := netlink.NewAttributeDecoder(ad.Bytes())
+			if err != nil {
+				return pidsDelayStats{}, err
+			}
+			for nad.Next() {
+				if nad.Type() == unix.TASKSTATS_TYPE_STATS {
+					return decodeTaskstats(nad.Bytes())
+				}
+			}
+			if err := nad.Err(); err != nil {
+				return pidsDelayStats{}, err
+			}
+		}
+		if err := ad.Err(); err != nil {
+			return pidsDelayStats{}, err
+		}
+	}
+	return pidsDelayStats{}, errors.New("no taskstats in reply")
+}
+
+func decodeTaskstats(b []byte) (pidsDelayStats, error) {
+	var ts unix.Taskstats
+	if len(b) < int(unsafe.Offsetof(ts.Swapin_delay_total))+8 {
+		return pidsDelayStats{}, fmt.Errorf("taskstats too short: %d bytes", len(b))
+	}
+	delay := func(offset uintptr) float64 {
+		return float64(native.Endian.Uint64(b[offset:])) / 1e9
+	}
+	return pidsDelayStats{
+		cpu:       delay(unsafe.Offsetof(ts.Cpu_delay_total)),
+		blkio:     delay(unsafe.Offsetof(ts.Blkio_delay_total)),
+		swapin:    delay(unsafe.Offsetof(ts.Swapin_delay_total)),
+		hasSwapin: true,
+	}, nil
+}
+
+func (r *pidsDelayReader) procDelay(pid int) (pidsDelayStats, error) {
+	var stats pidsDelayStats
+	p, err := r.fs.Proc(pid)
+	if err != nil {
+		return stats, err
+	}
+	stat, err := p.Stat()
+	if err != nil {
+		return stats, fmt.Errorf("couldn't get stat: %w", err)
+	}
+	stats.blkio = float64(stat.DelayAcctBlkIOTicks) * pidsSecondsPerTick
+
+	threads, err := r.fs.AllThreads(pid)
+	if err != nil {
+		return stats, fmt.Errorf("couldn't list threads: %w", err)
+	}
+	for _, t := range threads {
+		schedstat, err := t.Schedstat()
+		if err != nil {
+			// The thread exited in the meantime.
+			continue
+		}
+		stats.cpu += float64(schedstat.WaitingNanoseconds) / 1e9
+	}
+	return stats, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/go-kit/log"
+	"github.com/josharian/native"
+	"github.com/mdlayher/genetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseTaskstats(t *testing.T) {
+	var ts unix.Taskstats
+	raw := make([]byte, unsafe.Sizeof(ts))
+	native.Endian.PutUint64(raw[unsafe.Offsetof(ts.Cpu_delay_total):], 1500000000)
+	native.Endian.PutUint64(raw[unsafe.Offsetof(ts.Blkio_delay_total):], 250000000)
+	native.Endian.PutUint64(raw[unsafe.Offsetof(ts.Swapin_delay_total):], 3000000)
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(unix.TASKSTATS_TYPE_AGGR_TGID, func(nae *netlink.AttributeEncoder) error {
+		nae.Uint32(unix.TASKSTATS_TYPE_TGID, 42)
+		nae.Bytes(unix.TASKSTATS_TYPE_STATS, raw)
+		return nil
+	})
+	data, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseTaskstats([]genetlink.Message{{Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pidsDelayStats{cpu: 1.5, blkio: 0.25, swapin: 0.003, hasSwapin: true}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	if _, err := parseTaskstats(nil); err == nil {
+		t.Error("expected error for empty reply")
+	}
+	if _, err := decodeTaskstats(raw[:32]); err == nil {
+		t.Error("expected error for truncated taskstats")
+	}
+}
+
+func TestPidsProcDelay(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &pidsDelayReader{fs: fs, logger: log.NewNopLogger()}
+
+	got, err := r.read(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pidsDelayStats{cpu: 2.5, blkio: 0.19}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
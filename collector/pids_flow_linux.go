@@ -0,0 +1,661 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/josharian/native"
+	"github.com/prometheus/procfs"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Bytes of each packet copied into the ring, enough for the IP and
+	// transport headers we look at.
+	pidsFlowSnapLen = 128
+
+	pidsFlowBlockSize = 1 << 20
+	pidsFlowBlockNr   = 4
+	pidsFlowFrameSize = 1 << 11
+	// Hand a partially filled block to userspace after this many milliseconds.
+	pidsFlowBlockTimeout = 100
+	pidsFlowPollTimeout  = 500
+
+	pidsFlowProtoTCP = 6
+	pidsFlowProtoUDP = 17
+)
+
+var (
+	pidsFlowEnabled         = kingpin.Flag("collector.pids.flow", "Account network traffic per process with a background AF_PACKET capture.").Default("false").Bool()
+	pidsFlowDeviceExclude   = kingpin.Flag("collector.pids.flow.device-exclude", "Regexp of network devices to ignore for per-process traffic accounting.").Default("^(lo|veth.*|docker.*|cni.*|flannel.*|cali.*|virbr.*|br-.*)$").String()
+	pidsFlowRefreshInterval = kingpin.Flag("collector.pids.flow.refresh-interval", "How often to refresh the socket to process mapping used for traffic accounting.").Default("5s").Duration()
+)
+
+// pidsFlowEndpoint is an address and port of a socket. IPv4 addresses are
+// stored in their IPv4-mapped IPv6 form, as dual-stack sockets in
+// /proc/net/{tcp6,udp6} show them.
+type pidsFlowEndpoint struct {
+	addr [16]byte
+	port uint16
+}
+
+type pidsFlowKey struct {
+	proto  uint8
+	local  pidsFlowEndpoint
+	remote pidsFlowEndpoint
+}
+
+// pidsFlowCounters are the traffic counters of a socket or a process.
+type pidsFlowCounters struct {
+	rxBytes   uint64
+	rxPackets uint64
+	txBytes   uint64
+	txPackets uint64
+}
+
+func (c *pidsFlowCounters) add(o pidsFlowCounters) {
+	c.rxBytes += o.rxBytes
+	c.rxPackets += o.rxPackets
+	c.txBytes += o.txBytes
+	c.txPackets += o.txPackets
+}
+
+// pidsFlowSample is the traffic of a process at the time of a scrape, kept to
+// compute the rates of the deprecated per-second gauges.
+type pidsFlowSample struct {
+	counters pidsFlowCounters
+	at       time.Time
+}
+
+// pidsFlowRates are the per-second traffic rates of a process.
+type pidsFlowRates struct {
+	rxBytes   float64
+	rxPackets float64
+	txBytes   float64
+	txPackets float64
+}
+
+type pidsFlowSocket struct {
+	counters pidsFlowCounters
+	// Traffic seen before the owning process was known, handed over to the
+	// process on the next refresh.
+	pending pidsFlowCounters
+}
+
+// pidsFlowCapture accounts traffic of local sockets from an AF_PACKET ring in
+// the background. Scrapes only read the counters.
+type pidsFlowCapture struct {
+	fs            procfs.FS
+	deviceExclude *regexp.Regexp
+	errs          *errorReporter
+	logger        log.Logger
+	ring          *pidsFlowRing
+	done          chan struct{}
+	wg            sync.WaitGroup
+
+	mtx       sync.Mutex
+	devices   map[int]bool
+	endpoints map[pidsFlowKey]uint64
+	owners    map[uint64]int
+	sockets   map[uint64]*pidsFlowSocket
+	processes map[int]*pidsFlowCounters
+	samples   map[int]pidsFlowSample
+	drops     uint64
+	up        bool
+}
+
+func newPidsFlowCapture(fs procfs.FS, errs *errorReporter, logger log.Logger) (*pidsFlowCapture, error) {
+	deviceExclude, err := regexp.Compile(*pidsFlowDeviceExclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device exclude regexp: %w", err)
+	}
+	if *pidsFlowRefreshInterval <= 0 {
+		return nil, fmt.Errorf("invalid refresh interval %s", *pidsFlowRefreshInterval)
+	}
+	ring, err := openPidsFlowRing()
+	if err != nil {
+		return nil, err
+	}
+	c := &pidsFlowCapture{
+		fs:            fs,
+		deviceExclude: deviceExclude,
+		errs:          errs,
+		logger:        logger,
+		ring:          ring,
+		done:          make(chan struct{}),
+		up:            true,
+		sockets:       map[uint64]*pidsFlowSocket{},
+		processes:     map[int]*pidsFlowCounters{},
+		samples:       map[int]pidsFlowSample{},
+	}
+	c.refresh()
+
+	c.wg.Add(2)
+	go func() {
+		defer c.wg.Done()
+		if err := c.ring.run(c.done, c.handleBlock); err != nil {
+			level.Error(c.logger).Log("msg", "Per-process traffic capture stopped", "err", err)
+			c.errs.report("flow_capture", err)
+			c.mtx.Lock()
+			c.up = false
+			c.mtx.Unlock()
+		}
+	}()
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(*pidsFlowRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				c.refresh()
+			}
+		}
+	}()
+	return c, nil
+}
+
+// close stops the capture and releases the ring.
+func (c *pidsFlowCapture) close() {
+	close(c.done)
+	c.wg.Wait()
+	c.ring.close()
+}
+
+// processCounters returns the traffic accounted to pid so far.
+func (c *pidsFlowCapture) processCounters(pid int) pidsFlowCounters {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if p, ok := c.processes[pid]; ok {
+		return *p
+	}
+	return pidsFlowCounters{}
+}
+
+// socketCounters returns the traffic of the sockets currently owned by pid,
+// keyed by socket inode.
+func (c *pidsFlowCapture) socketCounters(pid int) map[uint64]pidsFlowCounters {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	sockets := map[uint64]pidsFlowCounters{}
+	for inode, s := range c.sockets {
+		if owner, ok := c.owners[inode]; ok && owner == pid {
+			sockets[inode] = s.counters
+		}
+	}
+	return sockets
+}
+
+// processRates returns the traffic rates of pid since the previous call for
+// the same pid, or zero rates on the first call.
+func (c *pidsFlowCapture) processRates(pid int, now time.Time) pidsFlowRates {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var cur pidsFlowCounters
+	if p, ok := c.processes[pid]; ok {
+		cur = *p
+	}
+	prev, ok := c.samples[pid]
+	c.samples[pid] = pidsFlowSample{counters: cur, at: now}
+	if !ok {
+		return pidsFlowRates{}
+	}
+	seconds := now.Sub(prev.at).Seconds()
+	if seconds <= 0 {
+		return pidsFlowRates{}
+	}
+	rate := func(prev, cur uint64) float64 {
+		return counterDelta(float64(prev), float64(cur)) / seconds
+	}
+	return pidsFlowRates{
+		rxBytes:   rate(prev.counters.rxBytes, cur.rxBytes),
+		rxPackets: rate(prev.counters.rxPackets, cur.rxPackets),
+		txBytes:   rate(prev.counters.txBytes, cur.txBytes),
+		txPackets: rate(prev.counters.txPackets, cur.txPackets),
+	}
+}
+
+// droppedPackets returns the number of packets the kernel dropped because the
+// ring was full.
+func (c *pidsFlowCapture) droppedPackets() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.drops
+}
+
+// capturing reports whether packets are still read from the ring.
+func (c *pidsFlowCapture) capturing() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.up
+}
+
+// refresh rebuilds the device, socket and socket owner tables. Building them
+// walks all of /proc, so it is done outside of the lock.
+func (c *pidsFlowCapture) refresh() {
+	devices, err := c.readDevices()
+	if err != nil {
+		c.errs.report("flow_devices", err)
+	}
+	var (
+		endpoints map[pidsFlowKey]uint64
+		owners    map[uint64]int
+	)
+	table, err := readSocketTable(c.fs, true)
+	if err != nil {
+		c.errs.report("flow_sockets", err)
+	} else {
+		endpoints = pidsFlowEndpoints(table)
+		owners = make(map[uint64]int, len(table.byInode))
+		for inode, s := range table.byInode {
+			if s.pid != 0 {
+				owners[inode] = s.pid
+			}
+		}
+	}
+	stats, err := unix.GetsockoptTpacketStatsV3(c.ring.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		c.errs.report("flow_ring_statistics", err)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if devices != nil {
+		c.devices = devices
+	}
+	if endpoints != nil {
+		c.endpoints = endpoints
+	}
+	if owners != nil {
+		c.owners = owners
+		for inode, s := range c.sockets {
+			pid, ok := owners[inode]
+			if !ok {
+				delete(c.sockets, inode)
+				continue
+			}
+			if s.pending != (pidsFlowCounters{}) {
+				c.process(pid).add(s.pending)
+				s.pending = pidsFlowCounters{}
+			}
+		}
+		for pid := range c.processes {
+			if !pidsFlowProcessExists(pid) {
+				delete(c.processes, pid)
+			}
+		}
+		for pid := range c.samples {
+			if !pidsFlowProcessExists(pid) {
+				delete(c.samples, pid)
+			}
+		}
+	}
+	if stats != nil {
+		// The kernel resets the statistics on every read.
+		c.drops += uint64(stats.Drops)
+	}
+}
+
+func pidsFlowProcessExists(pid int) bool {
+	_, err := os.Stat(procFilePath(strconv.Itoa(pid)))
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+func (c *pidsFlowCapture) process(pid int) *pidsFlowCounters {
+	p, ok := c.processes[pid]
+	if !ok {
+		p = &pidsFlowCounters{}
+		c.processes[pid] = p
+	}
+	return p
+}
+
+func (c *pidsFlowCapture) readDevices() (map[int]bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[int]bool, len(ifaces))
+	for _, iface := range ifaces {
+		if c.deviceExclude.MatchString(iface.Name) {
+			continue
+		}
+		devices[iface.Index] = true
+	}
+	return devices, nil
+}
+
+func (c *pidsFlowCapture) handleBlock(packets [][]byte) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, frame := range packets {
+		c.handleFrame(frame)
+	}
+}
+
+func (c *pidsFlowCapture) handleFrame(frame []byte) {
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&frame[0]))
+	sll := frame[pidsFlowSllOffset:]
+	ifindex := int(native.Endian.Uint32(sll[4:8]))
+	pktType := sll[10]
+	if !c.devices[ifindex] {
+		return
+	}
+	if int(hdr.Net)+int(hdr.Snaplen) > len(frame) {
+		return
+	}
+	key, ok := parsePidsFlowPacket(frame[hdr.Net : uint32(hdr.Net)+hdr.Snaplen])
+	if !ok {
+		return
+	}
+
+	outgoing := pktType == unix.PACKET_OUTGOING
+	if !outgoing {
+		key.local, key.remote = key.remote, key.local
+	}
+	inode, ok := c.lookup(key)
+	if !ok {
+		return
+	}
+
+	var delta pidsFlowCounters
+	if outgoing {
+		delta = pidsFlowCounters{txBytes: uint64(hdr.Len), txPackets: 1}
+	} else {
+		delta = pidsFlowCounters{rxBytes: uint64(hdr.Len), rxPackets: 1}
+	}
+	s, ok := c.sockets[inode]
+	if !ok {
+		s = &pidsFlowSocket{}
+		c.sockets[inode] = s
+	}
+	s.counters.add(delta)
+	if pid, ok := c.owners[inode]; ok {
+		c.process(pid).add(delta)
+	} else {
+		s.pending.add(delta)
+	}
+}
+
+var (
+	pidsFlowAnyV4 = pidsFlowAddr(net.IPv4zero)
+	pidsFlowAnyV6 = pidsFlowAddr(net.IPv6unspecified)
+)
+
+// lookup finds the socket for a packet, trying connected sockets first and
+// then sockets bound to the local address or to the wildcard address.
+func (c *pidsFlowCapture) lookup(key pidsFlowKey) (uint64, bool) {
+	if inode, ok := c.endpoints[key]; ok {
+		return inode, true
+	}
+	key.remote = pidsFlowEndpoint{}
+	if inode, ok := c.endpoints[key]; ok {
+		return inode, true
+	}
+	for _, wildcard := range [][16]byte{pidsFlowAnyV4, pidsFlowAnyV6} {
+		key.local.addr = wildcard
+		if inode, ok := c.endpoints[key]; ok {
+			return inode, true
+		}
+	}
+	return 0, false
+}
+
+func pidsFlowAddr(ip net.IP) [16]byte {
+	var addr [16]byte
+	copy(addr[:], ip.To16())
+	return addr
+}
+
+// parsePidsFlowPacket extracts protocol, addresses and ports from an IPv4 or
+// IPv6 packet carrying TCP or UDP. The returned key has the source in local
+// and the destination in remote.
+func parsePidsFlowPacket(b []byte) (pidsFlowKey, bool) {
+	var (
+		key     pidsFlowKey
+		payload []byte
+	)
+	if len(b) < 1 {
+		return key, false
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return key, false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return key, false
+		}
+		// Only the first fragment carries the transport header.
+		if (uint16(b[6])<<8|uint16(b[7]))&0x1fff != 0 {
+			return key, false
+		}
+		key.proto = b[9]
+		key.local.addr = pidsFlowAddr(net.IP(b[12:16]))
+		key.remote.addr = pidsFlowAddr(net.IP(b[16:20]))
+		payload = b[ihl:]
+	case 6:
+		if len(b) < 40 {
+			return key, false
+		}
+		copy(key.local.addr[:], b[8:24])
+		copy(key.remote.addr[:], b[24:40])
+		next, off := b[6], 40
+		// Skip the extension headers that may precede the transport header.
+		for next == unix.IPPROTO_HOPOPTS || next == unix.IPPROTO_ROUTING || next == unix.IPPROTO_DSTOPTS {
+			if len(b) < off+8 {
+				return key, false
+			}
+			next, off = b[off], off+(int(b[off+1])+1)*8
+		}
+		key.proto = next
+		if len(b) < off {
+			return key, false
+		}
+		payload = b[off:]
+	default:
+		return key, false
+	}
+
+	if key.proto != pidsFlowProtoTCP && key.proto != pidsFlowProtoUDP {
+		return key, false
+	}
+	if len(payload) < 4 {
+		return key, false
+	}
+	key.local.port = uint16(payload[0])<<8 | uint16(payload[1])
+	key.remote.port = uint16(payload[2])<<8 | uint16(payload[3])
+	return key, true
+}
+
+// pidsFlowEndpoints indexes the sockets of the table by the flow key the
+// captured packets are matched against.
+func pidsFlowEndpoints(t *socketTable) map[pidsFlowKey]uint64 {
+	endpoints := make(map[pidsFlowKey]uint64, len(t.sockets))
+	for _, s := range t.sockets {
+		if s.inode == 0 {
+			// TIME_WAIT and similar sockets no longer belong to anyone.
+			continue
+		}
+		key := pidsFlowKey{
+			proto: pidsFlowProtoTCP,
+			local: pidsFlowEndpoint{addr: pidsFlowAddr(s.localAddr), port: s.localPort},
+		}
+		if s.protocol == "udp" {
+			key.proto = pidsFlowProtoUDP
+		}
+		// Listening and unconnected sockets are looked up by their local
+		// endpoint only.
+		if s.remotePort != 0 {
+			key.remote = pidsFlowEndpoint{addr: pidsFlowAddr(s.remoteAddr), port: s.remotePort}
+		}
+		endpoints[key] = s.inode
+	}
+	return endpoints
+}
+
+// pidsFlowSllOffset is where the sockaddr_ll follows the tpacket3_hdr in a
+// ring frame, TPACKET_ALIGN(sizeof(struct tpacket3_hdr)).
+const pidsFlowSllOffset = (unix.SizeofTpacket3Hdr + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+
+// pidsFlowRing is a TPACKET_V3 receive ring on an AF_PACKET socket.
+type pidsFlowRing struct {
+	fd   int
+	ring []byte
+}
+
+func openPidsFlowRing() (*pidsFlowRing, error) {
+	// SOCK_DGRAM strips the link layer header so the filter and the parser
+	// work the same on Ethernet, InfiniBand and tunnel devices.
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open AF_PACKET socket: %w", err)
+	}
+	r := &pidsFlowRing{fd: fd}
+	if err := r.setup(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *pidsFlowRing) setup() error {
+	filter, err := pidsFlowFilter()
+	if err != nil {
+		return fmt.Errorf("failed to assemble packet filter: %w", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.SetsockoptSockFprog(r.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+		return fmt.Errorf("failed to attach packet filter: %w", err)
+	}
+	if err := unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return fmt.Errorf("failed to set TPACKET_V3: %w", err)
+	}
+	req := unix.TpacketReq3{
+		Block_size:     pidsFlowBlockSize,
+		Block_nr:       pidsFlowBlockNr,
+		Frame_size:     pidsFlowFrameSize,
+		Frame_nr:       pidsFlowBlockSize / pidsFlowFrameSize * pidsFlowBlockNr,
+		Retire_blk_tov: pidsFlowBlockTimeout,
+	}
+	if err := unix.SetsockoptTpacketReq3(r.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return fmt.Errorf("failed to set up packet ring: %w", err)
+	}
+	ring, err := unix.Mmap(r.fd, 0, pidsFlowBlockSize*pidsFlowBlockNr, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("failed to map packet ring: %w", err)
+	}
+	r.ring = ring
+	return nil
+}
+
+// pidsFlowFilter accepts IPv4 TCP and UDP and all of IPv6, whose transport
+// protocol may hide behind extension headers, truncated to the headers.
+func pidsFlowFilter() ([]unix.SockFilter, error) {
+	raw, err := bpf.Assemble([]bpf.Instruction{
+		bpf.LoadExtension{Num: bpf.ExtProto},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.ETH_P_IPV6, SkipTrue: 5},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: unix.ETH_P_IP, SkipFalse: 3},
+		bpf.LoadAbsolute{Off: 9, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: pidsFlowProtoTCP, SkipTrue: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: pidsFlowProtoUDP, SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+		bpf.RetConstant{Val: pidsFlowSnapLen},
+	})
+	if err != nil {
+		return nil, err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return filter, nil
+}
+
+// run hands every block the kernel releases to handle and returns it to the
+// kernel afterwards, until done is closed or polling the ring fails.
+func (r *pidsFlowRing) run(done <-chan struct{}, handle func([][]byte)) error {
+	var (
+		block   = 0
+		packets [][]byte
+	)
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		desc := r.ring[block*pidsFlowBlockSize : (block+1)*pidsFlowBlockSize]
+		// struct tpacket_block_desc: version, offset_to_priv, then the header.
+		hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&desc[8]))
+		if atomic.LoadUint32(&hdr.Block_status)&unix.TP_STATUS_USER == 0 {
+			fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN | unix.POLLERR}}
+			if _, err := unix.Poll(fds, pidsFlowPollTimeout); err != nil && err != unix.EINTR {
+				return fmt.Errorf("failed to poll packet ring: %w", err)
+			}
+			if fds[0].Revents&unix.POLLNVAL != 0 {
+				return errors.New("packet ring socket is not open")
+			}
+			continue
+		}
+
+		packets = packets[:0]
+		off := hdr.Offset_to_first_pkt
+		for i := uint32(0); i < hdr.Num_pkts && int(off) < len(desc); i++ {
+			frame := desc[off:]
+			packets = append(packets, frame)
+			next := (*unix.Tpacket3Hdr)(unsafe.Pointer(&frame[0])).Next_offset
+			if next == 0 {
+				break
+			}
+			off += next
+		}
+		handle(packets)
+
+		atomic.StoreUint32(&hdr.Block_status, unix.TP_STATUS_KERNEL)
+		block = (block + 1) % pidsFlowBlockNr
+	}
+}
+
+func (r *pidsFlowRing) close() {
+	if r.ring != nil {
+		unix.Munmap(r.ring)
+	}
+	unix.Close(r.fd)
+}
+
+func htons(v uint16) uint16 {
+	return native.Endian.Uint16([]byte{byte(v >> 8), byte(v)})
+}
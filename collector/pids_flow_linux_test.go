@@ -0,0 +1,195 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/procfs"
+)
+
+func TestParsePidsFlowPacket(t *testing.T) {
+	ipv4TCP := []byte{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		10, 0, 0, 1, // source
+		10, 0, 0, 2, // destination
+		0x1f, 0x90, 0xc3, 0x50, // 8080 -> 50000
+	}
+	ipv6UDP := append([]byte{
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x08, 0x11, 0x40,
+	}, append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...),
+		0x00, 0x35, 0x04, 0xd2, // 53 -> 1234
+	)...)
+	ipv6HopByHopTCP := append([]byte{
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x40,
+	}, append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...),
+		0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // hop-by-hop, next header TCP
+		0x00, 0x16, 0xea, 0x60, // 22 -> 60000
+	)...)
+	fragment := append([]byte(nil), ipv4TCP...)
+	fragment[6], fragment[7] = 0x00, 0x10
+
+	for name, tc := range map[string]struct {
+		packet []byte
+		ok     bool
+		want   pidsFlowKey
+	}{
+		"ipv4 tcp": {packet: ipv4TCP, ok: true, want: pidsFlowKey{
+			proto:  pidsFlowProtoTCP,
+			local:  pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP("10.0.0.1")), port: 8080},
+			remote: pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP("10.0.0.2")), port: 50000},
+		}},
+		"ipv6 udp": {packet: ipv6UDP, ok: true, want: pidsFlowKey{
+			proto:  pidsFlowProtoUDP,
+			local:  pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP("2001:db8::1")), port: 53},
+			remote: pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP("2001:db8::2")), port: 1234},
+		}},
+		"ipv6 extension header": {packet: ipv6HopByHopTCP, ok: true, want: pidsFlowKey{
+			proto:  pidsFlowProtoTCP,
+			local:  pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP("2001:db8::1")), port: 22},
+			remote: pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP("2001:db8::2")), port: 60000},
+		}},
+		"ipv4 fragment": {packet: fragment},
+		"truncated":     {packet: ipv4TCP[:22]},
+		"empty":         {},
+	} {
+		got, ok := parsePidsFlowPacket(tc.packet)
+		if ok != tc.ok {
+			t.Errorf("%s: want ok %v, got %v", name, tc.ok, ok)
+			continue
+		}
+		if ok && got != tc.want {
+			t.Errorf("%s: want %+v, got %+v", name, tc.want, got)
+		}
+	}
+}
+
+func TestPidsFlowLookup(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := readSocketTable(fs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := pidsFlowEndpoints(table)
+	// The TIME_WAIT socket has no owner and is left out.
+	if want, got := 5, len(endpoints); want != got {
+		t.Fatalf("want %d sockets, got %d", want, got)
+	}
+
+	c := &pidsFlowCapture{endpoints: endpoints}
+	endpoint := func(ip string, port uint16) pidsFlowEndpoint {
+		return pidsFlowEndpoint{addr: pidsFlowAddr(net.ParseIP(ip)), port: port}
+	}
+	for _, tc := range []struct {
+		key  pidsFlowKey
+		want uint64
+	}{
+		// Established connection to sshd.
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("10.0.0.1", 22), endpoint("10.0.0.2", 60000)}, 1002},
+		// New connection attempt hits the listening socket.
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("10.0.0.1", 22), endpoint("10.0.0.9", 40000)}, 1001},
+		// Loopback only listener.
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("127.0.0.1", 3306), endpoint("127.0.0.1", 40000)}, 1003},
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("10.0.0.1", 3306), endpoint("10.0.0.9", 40000)}, 0},
+		// IPv4 and IPv6 clients of a dual-stack listener.
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("10.0.0.1", 9100), endpoint("10.0.0.9", 40000)}, 3001},
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("2001:db8::1", 9100), endpoint("2001:db8::9", 40000)}, 3001},
+		{pidsFlowKey{pidsFlowProtoUDP, endpoint("10.0.0.1", 22), endpoint("10.0.0.9", 40000)}, 2740},
+		// Nobody listens on 80, and TIME_WAIT sockets have no owner.
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("10.0.0.1", 80), endpoint("10.0.0.2", 60000)}, 0},
+		{pidsFlowKey{pidsFlowProtoTCP, endpoint("10.0.0.1", 50000), endpoint("10.0.0.3", 8080)}, 0},
+	} {
+		got, _ := c.lookup(tc.key)
+		if got != tc.want {
+			t.Errorf("%+v: want inode %d, got %d", tc.key, tc.want, got)
+		}
+	}
+}
+
+func TestPidsFlowRingRunFailure(t *testing.T) {
+	// No file is open under this descriptor, so polling it fails at once.
+	r := &pidsFlowRing{fd: 1 << 20, ring: make([]byte, pidsFlowBlockSize*pidsFlowBlockNr)}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- r.run(make(chan struct{}), func([][]byte) {})
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("want an error polling a closed descriptor, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want run to return on a poll failure, still running")
+	}
+}
+
+func TestPidsFlowSocketCounters(t *testing.T) {
+	c := &pidsFlowCapture{
+		owners: map[uint64]int{1001: 10, 1002: 10, 2001: 20},
+		sockets: map[uint64]*pidsFlowSocket{
+			1001: {counters: pidsFlowCounters{rxBytes: 100, rxPackets: 2}},
+			1002: {counters: pidsFlowCounters{txBytes: 60, txPackets: 1}},
+			2001: {counters: pidsFlowCounters{rxBytes: 40, rxPackets: 1}},
+			// Owner not known yet.
+			3001: {pending: pidsFlowCounters{rxBytes: 10, rxPackets: 1}},
+		},
+	}
+	want := map[uint64]pidsFlowCounters{
+		1001: {rxBytes: 100, rxPackets: 2},
+		1002: {txBytes: 60, txPackets: 1},
+	}
+	got := c.socketCounters(10)
+	if len(got) != len(want) {
+		t.Fatalf("want %d sockets, got %d", len(want), len(got))
+	}
+	for inode, w := range want {
+		if got[inode] != w {
+			t.Errorf("socket %d: want %+v, got %+v", inode, w, got[inode])
+		}
+	}
+	if got := c.socketCounters(30); len(got) != 0 {
+		t.Errorf("want no sockets for an unknown process, got %d", len(got))
+	}
+}
+
+func TestPidsFlowProcessRates(t *testing.T) {
+	c := &pidsFlowCapture{
+		processes: map[int]*pidsFlowCounters{10: {rxBytes: 1000, rxPackets: 10}},
+		samples:   map[int]pidsFlowSample{},
+	}
+	start := time.Unix(1000, 0)
+	if want, got := (pidsFlowRates{}), c.processRates(10, start); want != got {
+		t.Errorf("first scrape: want %+v, got %+v", want, got)
+	}
+
+	c.processes[10].add(pidsFlowCounters{rxBytes: 500, rxPackets: 5, txBytes: 200, txPackets: 2})
+	want := pidsFlowRates{rxBytes: 50, rxPackets: 0.5, txBytes: 20, txPackets: 0.2}
+	if got := c.processRates(10, start.Add(10*time.Second)); want != got {
+		t.Errorf("second scrape: want %+v, got %+v", want, got)
+	}
+
+	// A process without traffic has zero rates.
+	c.processRates(20, start)
+	if want, got := (pidsFlowRates{}), c.processRates(20, start.Add(10*time.Second)); want != got {
+		t.Errorf("idle process: want %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	pidsGroupsConfigFile = kingpin.Flag("collector.pids.groups-config", "Path to a YAML file defining named process groups to aggregate.").Default("").String()
+)
+
+// pidsGroupsConfig is the on-disk format of --collector.pids.groups-config.
+//
+//	groups:
+//	  - name: kubelet
+//	    comm: [kubelet]
+//	  - name: gpu-jobs
+//	    cgroup: ['^/slurm/']
+//	    user: [research]
+//
+// Within a group every configured field has to match, a field matches if any
+// of its values matches. Processes are assigned to the first matching group.
+type pidsGroupsConfig struct {
+	Groups []pidsGroupSpec `yaml:"groups"`
+}
+
+type pidsGroupSpec struct {
+	Name string `yaml:"name"`
+	// Comm matches the process name from /proc/<pid>/comm.
+	Comm []string `yaml:"comm"`
+	// Exe matches the full executable path, or its base name if the value
+	// contains no slash.
+	Exe []string `yaml:"exe"`
+	// Cmdline is a list of regular expressions matched against the
+	// space-joined command line.
+	Cmdline []string `yaml:"cmdline"`
+	// User matches the real user of the process by name or numeric UID.
+	User []string `yaml:"user"`
+	// Cgroup is a list of regular expressions matched against each cgroup
+	// path of the process.
+	Cgroup []string `yaml:"cgroup"`
+}
+
+type pidsGroup struct {
+	name    string
+	comm    map[string]bool
+	exe     map[string]bool
+	cmdline []*regexp.Regexp
+	user    map[string]bool
+	cgroup  []*regexp.Regexp
+}
+
+// pidsProcInfo holds the attributes a process is matched on.
+type pidsProcInfo struct {
+	comm    string
+	exe     string
+	cmdline string
+	uid     string
+	user    string
+	cgroups []string
+}
+
+type pidsGroupTracker struct {
+	fs         procfs.FS
+	groups     []*pidsGroup
+	needCgroup bool
+	logger     log.Logger
+
+	descs pidsGroupDescs
+
+	mtx    sync.Mutex
+	procs  map[pidsProcKey]pidsProcSample
+	totals map[string]*pidsGroupStats
+	users  pidsUserCache
+}
+
+type pidsGroupDescs struct {
+	cpuSeconds    *prometheus.Desc
+	readBytes     *prometheus.Desc
+	writeBytes    *prometheus.Desc
+	contextSwitch *prometheus.Desc
+	processes     *prometheus.Desc
+	rssBytes      *prometheus.Desc
+	threads       *prometheus.Desc
+	fds           *prometheus.Desc
+}
+
+func loadPidsGroupsConfig(path string) (*pidsGroupsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &pidsGroupsConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func compilePidsGroups(cfg *pidsGroupsConfig) ([]*pidsGroup, error) {
+	var (
+		groups = make([]*pidsGroup, 0, len(cfg.Groups))
+		seen   = map[string]bool{}
+	)
+	for i, spec := range cfg.Groups {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("group %d has no name", i)
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("duplicate group name %q", spec.Name)
+		}
+		seen[spec.Name] = true
+		if len(spec.Comm)+len(spec.Exe)+len(spec.Cmdline)+len(spec.User)+len(spec.Cgroup) == 0 {
+			return nil, fmt.Errorf("group %q has no matchers", spec.Name)
+		}
+
+		g := &pidsGroup{
+			name: spec.Name,
+			comm: stringSet(spec.Comm),
+			exe:  stringSet(spec.Exe),
+			user: stringSet(spec.User),
+		}
+		for _, expr := range spec.Cmdline {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("group %q: invalid cmdline regexp %q: %w", spec.Name, expr, err)
+			}
+			g.cmdline = append(g.cmdline, re)
+		}
+		for _, expr := range spec.Cgroup {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("group %q: invalid cgroup regexp %q: %w", spec.Name, expr, err)
+			}
+			g.cgroup = append(g.cgroup, re)
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+func stringSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+func (g *pidsGroup) matches(info *pidsProcInfo) bool {
+	if g.comm != nil && !g.comm[info.comm] {
+		return false
+	}
+	if g.exe != nil && !g.exe[info.exe] && !g.exe[filepath.Base(info.exe)] {
+		return false
+	}
+	if g.user != nil && !g.user[info.uid] && !g.user[info.user] {
+		return false
+	}
+	if len(g.cmdline) > 0 && !anyRegexpMatches(g.cmdline, info.cmdline) {
+		return false
+	}
+	if len(g.cgroup) > 0 {
+		matched := false
+		for _, cg := range info.cgroups {
+			if anyRegexpMatches(g.cgroup, cg) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func anyRegexpMatches(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func newPidsGroupTracker(fs procfs.FS, path string, logger log.Logger) (*pidsGroupTracker, error) {
+	cfg, err := loadPidsGroupsConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := compilePidsGroups(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid process groups in %s: %w", path, err)
+	}
+
+	t := &pidsGroupTracker{
+		fs:     fs,
+		groups: groups,
+		logger: logger,
+		procs:  map[pidsProcKey]pidsProcSample{},
+		totals: map[string]*pidsGroupStats{},
+	}
+	for _, g := range groups {
+		if len(g.cgroup) > 0 {
+			t.needCgroup = true
+		}
+		// Export configured groups even before their first member shows up.
+		t.totals[g.name] = &pidsGroupStats{}
+	}
+
+	subsystem := "pids_group"
+	labels := []string{"group"}
+	t.descs = pidsGroupDescs{
+		cpuSeconds: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "cpu_seconds_total"),
+			"CPU time consumed by the processes of the group.",
+			[]string{"group", "mode"}, nil,
+		),
+		readBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "read_bytes_total"),
+			"Bytes read from storage by the processes of the group.",
+			labels, nil,
+		),
+		writeBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "write_bytes_total"),
+			"Bytes written to storage by the processes of the group.",
+			labels, nil,
+		),
+		contextSwitch: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "context_switches_total"),
+			"Context switches of the processes of the group.",
+			[]string{"group", "ctxswitchtype"}, nil,
+		),
+		processes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "processes"),
+			"Number of processes in the group.",
+			labels, nil,
+		),
+		rssBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "memory_rss_bytes"),
+			"Resident set size of the processes of the group.",
+			labels, nil,
+		),
+		threads: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "threads"),
+			"Number of threads of the processes of the group.",
+			labels, nil,
+		),
+		fds: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "open_fds"),
+			"Number of open file descriptors of the processes of the group.",
+			labels, nil,
+		),
+	}
+	return t, nil
+}
+
+func (t *pidsGroupTracker) Update(ch chan<- prometheus.Metric) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	samples, err := t.readSamples()
+	if err != nil {
+		return err
+	}
+	t.observe(samples)
+
+	for name, s := range t.totals {
+		ch <- prometheus.MustNewConstMetric(t.descs.cpuSeconds, prometheus.CounterValue, s.userSeconds, name, "user")
+		ch <- prometheus.MustNewConstMetric(t.descs.cpuSeconds, prometheus.CounterValue, s.systemSeconds, name, "system")
+		ch <- prometheus.MustNewConstMetric(t.descs.readBytes, prometheus.CounterValue, s.readBytes, name)
+		ch <- prometheus.MustNewConstMetric(t.descs.writeBytes, prometheus.CounterValue, s.writeBytes, name)
+		ch <- prometheus.MustNewConstMetric(t.descs.contextSwitch, prometheus.CounterValue, s.voluntaryCtxt, name, "voluntary")
+		ch <- prometheus.MustNewConstMetric(t.descs.contextSwitch, prometheus.CounterValue, s.nonvoluntaryCtxt, name, "nonvoluntary")
+		ch <- prometheus.MustNewConstMetric(t.descs.processes, prometheus.GaugeValue, s.processes, name)
+		ch <- prometheus.MustNewConstMetric(t.descs.rssBytes, prometheus.GaugeValue, s.rssBytes, name)
+		ch <- prometheus.MustNewConstMetric(t.descs.threads, prometheus.GaugeValue, s.threads, name)
+		ch <- prometheus.MustNewConstMetric(t.descs.fds, prometheus.GaugeValue, s.fds, name)
+	}
+	return nil
+}
+
+// observe folds a new set of samples into the group totals. Counters only
+// grow by the delta of a process since its previous sample, so a process that
+// exits keeps its share and a new one is added in full.
+func (t *pidsGroupTracker) observe(samples []pidsProcSample) {
+	t.procs = observePidsSamples(t.procs, t.totals, samples)
+}
+
+func (t *pidsGroupTracker) readSamples() ([]pidsProcSample, error) {
+	procs, err := t.fs.AllProcs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list all processes: %w", err)
+	}
+
+	samples := make([]pidsProcSample, 0, len(procs))
+	for _, p := range procs {
+		sample, ok, err := t.readSample(p)
+		if err != nil {
+			// Processes can vanish between listing and reading them.
+			if !errors.Is(err, os.ErrNotExist) {
+				level.Debug(t.logger).Log("msg", "failed to read process", "pid", p.PID, "err", err)
+			}
+			continue
+		}
+		if ok {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+func (t *pidsGroupTracker) readSample(p procfs.Proc) (pidsProcSample, bool, error) {
+	stat, err := p.Stat()
+	if err != nil {
+		return pidsProcSample{}, false, err
+	}
+	status, err := p.NewStatus()
+	if err != nil {
+		return pidsProcSample{}, false, err
+	}
+
+	info := &pidsProcInfo{
+		comm: stat.Comm,
+		uid:  status.UIDs[0],
+	}
+	info.user = t.users.lookup(info.uid)
+	// The exe link is unreadable for kernel threads and for processes of
+	// other users when running unprivileged, match on the rest then.
+	info.exe, _ = p.Executable()
+	if cmdline, err := p.CmdLine(); err == nil {
+		info.cmdline = strings.Join(cmdline, " ")
+	}
+	if t.needCgroup {
+		if cgroups, err := p.Cgroups(); err == nil {
+			for _, cg := range cgroups {
+				info.cgroups = append(info.cgroups, cg.Path)
+			}
+		}
+	}
+
+	group := t.match(info)
+	if group == "" {
+		return pidsProcSample{}, false, nil
+	}
+
+	sample := readPidsProcSample(p, stat, status)
+	sample.group = group
+	return sample, true, nil
+}
+
+func (t *pidsGroupTracker) match(info *pidsProcInfo) string {
+	for _, g := range t.groups {
+		if g.matches(info) {
+			return g.name
+		}
+	}
+	return ""
+}
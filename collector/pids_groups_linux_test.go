@@ -0,0 +1,101 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"testing"
+)
+
+func TestPidsGroupMatch(t *testing.T) {
+	groups, err := compilePidsGroups(&pidsGroupsConfig{Groups: []pidsGroupSpec{
+		{Name: "kubelet", Comm: []string{"kubelet"}},
+		{Name: "ceph", Exe: []string{"/usr/bin/ceph-osd", "ceph-mon"}},
+		{Name: "gpu-jobs", User: []string{"research"}, Cgroup: []string{"^/slurm/"}},
+		{Name: "java-agent", Cmdline: []string{`-javaagent:\S+agent\.jar`}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := &pidsGroupTracker{groups: groups}
+
+	for _, tc := range []struct {
+		info pidsProcInfo
+		want string
+	}{
+		{info: pidsProcInfo{comm: "kubelet", exe: "/usr/bin/kubelet"}, want: "kubelet"},
+		{info: pidsProcInfo{comm: "ceph-osd", exe: "/usr/bin/ceph-osd"}, want: "ceph"},
+		{info: pidsProcInfo{comm: "ceph-mon", exe: "/opt/ceph/bin/ceph-mon"}, want: "ceph"},
+		{info: pidsProcInfo{comm: "python3", uid: "1001", user: "research", cgroups: []string{"/slurm/uid_1001/job_42"}}, want: "gpu-jobs"},
+		{info: pidsProcInfo{comm: "python3", uid: "1001", user: "research", cgroups: []string{"/user.slice"}}, want: ""},
+		{info: pidsProcInfo{comm: "java", cmdline: "java -javaagent:/opt/apm/agent.jar -jar app.jar"}, want: "java-agent"},
+		{info: pidsProcInfo{comm: "bash"}, want: ""},
+	} {
+		info := tc.info
+		if got := tracker.match(&info); got != tc.want {
+			t.Errorf("process %+v: want group %q, got %q", tc.info, tc.want, got)
+		}
+	}
+}
+
+func TestPidsGroupConfigErrors(t *testing.T) {
+	for _, cfg := range []pidsGroupsConfig{
+		{Groups: []pidsGroupSpec{{Comm: []string{"sshd"}}}},
+		{Groups: []pidsGroupSpec{{Name: "empty"}}},
+		{Groups: []pidsGroupSpec{{Name: "a", Comm: []string{"a"}}, {Name: "a", Comm: []string{"b"}}}},
+		{Groups: []pidsGroupSpec{{Name: "bad", Cmdline: []string{"("}}}},
+	} {
+		if _, err := compilePidsGroups(&cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestPidsGroupCountersSurviveExit(t *testing.T) {
+	tracker := &pidsGroupTracker{
+		procs:  map[pidsProcKey]pidsProcSample{},
+		totals: map[string]*pidsGroupStats{},
+	}
+
+	tracker.observe([]pidsProcSample{
+		{pid: 10, startTime: 1, group: "agents", userSeconds: 5, readBytes: 100, threads: 4},
+		{pid: 11, startTime: 1, group: "agents", userSeconds: 2, readBytes: 50, threads: 1},
+	})
+	// pid 11 exits, pid 10 keeps running and a new pid 12 shows up.
+	tracker.observe([]pidsProcSample{
+		{pid: 10, startTime: 1, group: "agents", userSeconds: 6, readBytes: 150, threads: 4},
+		{pid: 12, startTime: 3, group: "agents", userSeconds: 1, readBytes: 10, threads: 2},
+	})
+
+	got := tracker.totals["agents"]
+	if want := 5.0 + 2 + 1 + 1; got.userSeconds != want {
+		t.Errorf("want user seconds %v, got %v", want, got.userSeconds)
+	}
+	if want := 100.0 + 50 + 50 + 10; got.readBytes != want {
+		t.Errorf("want read bytes %v, got %v", want, got.readBytes)
+	}
+	if got.processes != 2 || got.threads != 6 {
+		t.Errorf("want 2 processes and 6 threads, got %v and %v", got.processes, got.threads)
+	}
+
+	// A recycled pid with a different start time is a new process.
+	tracker.observe([]pidsProcSample{
+		{pid: 10, startTime: 9, group: "agents", userSeconds: 1},
+	})
+	if want := 9.0 + 1; got.userSeconds != want {
+		t.Errorf("want user seconds %v after pid reuse, got %v", want, got.userSeconds)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/procfs"
+)
+
+var (
+	pidsLabelUser         = kingpin.Flag("collector.pids.label.user", "Add the uid and user labels to the per-process metrics.").Default("false").Bool()
+	pidsLabelExe          = kingpin.Flag("collector.pids.label.exe", "Add the full executable path as the exe label to the per-process metrics.").Default("false").Bool()
+	pidsLabelSystemdUnit  = kingpin.Flag("collector.pids.label.systemd-unit", "Add the systemd unit, or slice outside of units, as the systemd_unit label to the per-process metrics.").Default("false").Bool()
+	pidsLabelContainerID  = kingpin.Flag("collector.pids.label.container-id", "Add the docker, containerd or cri-o container ID as the container_id label to the per-process metrics.").Default("false").Bool()
+	pidsLabelPodUID       = kingpin.Flag("collector.pids.label.pod-uid", "Add the Kubernetes pod UID as the pod_uid label to the per-process metrics.").Default("false").Bool()
+	pidsSystemdUnitSuffix = regexp.MustCompile(`\.(service|scope|socket|mount|swap)$`)
+	// Container scopes are named after the runtime, e.g. docker-<id>.scope,
+	// cri-containerd-<id>.scope and crio-<id>.scope with the systemd cgroup
+	// driver, or the bare ID with cgroupfs.
+	pidsContainerIDPattern = regexp.MustCompile(`^(?:docker-|cri-containerd-|crio-)?([0-9a-f]{64})(?:\.scope)?$`)
+	// The systemd cgroup driver escapes the dashes of the pod UID as
+	// underscores, e.g. kubepods-burstable-pod<uid>.slice.
+	pidsPodUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+)
+
+// pidsIdentity resolves the optional identity labels of a process.
+type pidsIdentity struct {
+	fs    procfs.FS
+	users *pidsUserCache
+
+	user         bool
+	exe          bool
+	systemdUnit  bool
+	containerID  bool
+	podUID       bool
+	needsCgroups bool
+}
+
+func newPidsIdentity(fs procfs.FS) *pidsIdentity {
+	return &pidsIdentity{
+		fs:           fs,
+		users:        &pidsUserCache{},
+		user:         *pidsLabelUser,
+		exe:          *pidsLabelExe,
+		systemdUnit:  *pidsLabelSystemdUnit,
+		containerID:  *pidsLabelContainerID,
+		podUID:       *pidsLabelPodUID,
+		needsCgroups: *pidsLabelSystemdUnit || *pidsLabelContainerID || *pidsLabelPodUID,
+	}
+}
+
+// labelNames returns the names of the enabled identity labels, in the order
+// of the values returned by labelValues.
+func (i *pidsIdentity) labelNames() []string {
+	var names []string
+	if i.user {
+		names = append(names, "uid", "user")
+	}
+	if i.exe {
+		names = append(names, "exe")
+	}
+	if i.systemdUnit {
+		names = append(names, "systemd_unit")
+	}
+	if i.containerID {
+		names = append(names, "container_id")
+	}
+	if i.podUID {
+		names = append(names, "pod_uid")
+	}
+	return names
+}
+
+// labelValues resolves the enabled identity labels of pid. Anything that
+// can't be read, e.g. the exe of another user's process when running
+// unprivileged, is left empty.
+func (i *pidsIdentity) labelValues(pid int) []string {
+	var values []string
+	p, err := i.fs.Proc(pid)
+	if err != nil {
+		return make([]string, len(i.labelNames()))
+	}
+	if i.user {
+		uid := ""
+		if status, err := p.NewStatus(); err == nil {
+			uid = status.UIDs[0]
+		}
+		values = append(values, uid, i.users.lookup(uid))
+	}
+	if i.exe {
+		exe, _ := p.Executable()
+		values = append(values, exe)
+	}
+	var unit, containerID, podUID string
+	if i.needsCgroups {
+		if cgroups, err := p.Cgroups(); err == nil {
+			paths := make([]string, 0, len(cgroups))
+			for _, cg := range cgroups {
+				paths = append(paths, cg.Path)
+			}
+			unit, containerID, podUID = parsePidsCgroupIdentity(paths)
+		}
+	}
+	if i.systemdUnit {
+		values = append(values, unit)
+	}
+	if i.containerID {
+		values = append(values, containerID)
+	}
+	if i.podUID {
+		values = append(values, podUID)
+	}
+	return values
+}
+
+// parsePidsCgroupIdentity extracts the systemd unit, container ID and pod UID
+// from the cgroup paths of a process. The unified hierarchy comes last in
+// /proc/<pid>/cgroup and wins over the v1 controllers.
+func parsePidsCgroupIdentity(paths []string) (unit, containerID, podUID string) {
+	for _, path := range paths {
+		var slice string
+		for _, component := range strings.Split(path, "/") {
+			switch {
+			case pidsSystemdUnitSuffix.MatchString(component):
+				unit = component
+			case strings.HasSuffix(component, ".slice"):
+				slice = component
+			}
+			if m := pidsContainerIDPattern.FindStringSubmatch(component); m != nil {
+				containerID = m[1]
+			}
+			if m := pidsPodUIDPattern.FindStringSubmatch(component); m != nil {
+				podUID = strings.ReplaceAll(m[1], "_", "-")
+			}
+		}
+		if unit == "" {
+			unit = slice
+		}
+	}
+	return unit, containerID, podUID
+}
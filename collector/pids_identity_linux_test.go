@@ -0,0 +1,128 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/procfs"
+)
+
+const testContainerID = "0f6a2b0e5cbb5a0e0ad6b8e0bc8b4bb3c5d1e3c3f9a3d6f2e1d0c9b8a7f6e5d4"
+
+func TestParsePidsCgroupIdentity(t *testing.T) {
+	for name, tc := range map[string]struct {
+		paths                     []string
+		unit, containerID, podUID string
+	}{
+		"systemd service": {
+			paths: []string{"/system.slice/sshd.service"},
+			unit:  "sshd.service",
+		},
+		"user session": {
+			paths: []string{"/user.slice/user-1000.slice/session-3.scope"},
+			unit:  "session-3.scope",
+		},
+		"slice only": {
+			paths: []string{"/machine.slice"},
+			unit:  "machine.slice",
+		},
+		"docker systemd driver": {
+			paths:       []string{"/system.slice/docker-" + testContainerID + ".scope"},
+			unit:        "docker-" + testContainerID + ".scope",
+			containerID: testContainerID,
+		},
+		"docker cgroupfs driver": {
+			paths:       []string{"/docker/" + testContainerID},
+			containerID: testContainerID,
+		},
+		"kubernetes containerd": {
+			paths: []string{
+				"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod2b1a4c4e_6d8f_4a3b_9c2d_1e0f3a4b5c6d.slice/cri-containerd-" + testContainerID + ".scope",
+			},
+			unit:        "cri-containerd-" + testContainerID + ".scope",
+			containerID: testContainerID,
+			podUID:      "2b1a4c4e-6d8f-4a3b-9c2d-1e0f3a4b5c6d",
+		},
+		"kubernetes cri-o cgroupfs v1": {
+			paths: []string{
+				"/",
+				"/kubepods/besteffort/pod2b1a4c4e-6d8f-4a3b-9c2d-1e0f3a4b5c6d/crio-" + testContainerID,
+			},
+			containerID: testContainerID,
+			podUID:      "2b1a4c4e-6d8f-4a3b-9c2d-1e0f3a4b5c6d",
+		},
+		"conmon is not a container": {
+			paths: []string{"/machine.slice/crio-conmon-" + testContainerID + ".scope"},
+			unit:  "crio-conmon-" + testContainerID + ".scope",
+		},
+	} {
+		unit, containerID, podUID := parsePidsCgroupIdentity(tc.paths)
+		if unit != tc.unit || containerID != tc.containerID || podUID != tc.podUID {
+			t.Errorf("%s: want (%q, %q, %q), got (%q, %q, %q)", name,
+				tc.unit, tc.containerID, tc.podUID, unit, containerID, podUID)
+		}
+	}
+}
+
+func TestPidsIdentityLabels(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "42")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{
+		"status": "Name:\tnginx\nUid:\t101\t101\t101\t101\nGid:\t101\t101\t101\t101\n",
+		"cgroup": "0::/kubepods.slice/kubepods-pod2b1a4c4e_6d8f_4a3b_9c2d_1e0f3a4b5c6d.slice/cri-containerd-" + testContainerID + ".scope\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/usr/sbin/nginx", filepath.Join(dir, "exe")); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := procfs.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := &pidsIdentity{
+		fs: fs,
+		// Avoid depending on the user database of the test host.
+		users:        &pidsUserCache{names: map[string]string{"101": "nginx"}},
+		user:         true,
+		exe:          true,
+		containerID:  true,
+		podUID:       true,
+		needsCgroups: true,
+	}
+	if want, got := []string{"uid", "user", "exe", "container_id", "pod_uid"}, identity.labelNames(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want label names %v, got %v", want, got)
+	}
+	want := []string{"101", "nginx", "/usr/sbin/nginx", testContainerID, "2b1a4c4e-6d8f-4a3b-9c2d-1e0f3a4b5c6d"}
+	if got := identity.labelValues(42); !reflect.DeepEqual(want, got) {
+		t.Errorf("want label values %v, got %v", want, got)
+	}
+	// Processes that are gone still get a value for every label.
+	if want, got := 5, len(identity.labelValues(43)); want != got {
+		t.Errorf("want %d label values for a missing process, got %d", want, got)
+	}
+}
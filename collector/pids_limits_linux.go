@@ -0,0 +1,265 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+var (
+	pidsLimitsEnabled = kingpin.Flag("collector.pids.limits", "Export resource limit usage of the tracked processes and the processes nearest to a limit, walks all processes.").Default("false").Bool()
+	pidsLimitsTop     = kingpin.Flag("collector.pids.limits.top", "Number of processes nearest to one of their soft limits to report.").Default("10").Int()
+)
+
+// pidsLimitResources maps the resources we track to their name in
+// /proc/<pid>/limits.
+var pidsLimitResources = []struct {
+	resource string
+	name     string
+}{
+	{"open_files", "Max open files"},
+	{"processes", "Max processes"},
+	{"locked_memory_bytes", "Max locked memory"},
+	{"address_space_bytes", "Max address space"},
+}
+
+// pidsLimit is a soft and hard resource limit, +Inf if unlimited.
+type pidsLimit struct {
+	soft float64
+	hard float64
+}
+
+// pidsLimitUsage is the usage of a resource against its limit.
+type pidsLimitUsage struct {
+	resource string
+	usage    float64
+	pidsLimit
+}
+
+// ratio returns the usage relative to the soft limit, the limit the process
+// runs into first.
+func (u pidsLimitUsage) ratio() float64 {
+	if math.IsInf(u.soft, 1) || u.soft == 0 {
+		return 0
+	}
+	return u.usage / u.soft
+}
+
+// pidsProcLimits is the limit usage of a single process.
+type pidsProcLimits struct {
+	pid    int
+	comm   string
+	usages []pidsLimitUsage
+}
+
+func parsePidsLimits(r io.Reader) (map[string]pidsLimit, error) {
+	limits := map[string]pidsLimit{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, res := range pidsLimitResources {
+			if !strings.HasPrefix(line, res.name+" ") {
+				continue
+			}
+			fields := strings.Fields(line[len(res.name):])
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid limit line %q", line)
+			}
+			soft, err := parsePidsLimitValue(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			hard, err := parsePidsLimitValue(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			limits[res.resource] = pidsLimit{soft: soft, hard: hard}
+		}
+	}
+	return limits, scanner.Err()
+}
+
+func parsePidsLimitValue(s string) (float64, error) {
+	if s == "unlimited" {
+		return math.Inf(1), nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit %q: %w", s, err)
+	}
+	return float64(v), nil
+}
+
+// readPidsHostLimits reads the limit usage of all processes. The process
+// limit is per real user, so its usage is the number of threads of the user.
+// Processes whose limits can't be read are passed to report and left out.
+func readPidsHostLimits(fs procfs.FS, report func(operation, pid string, err error)) (map[int]*pidsProcLimits, error) {
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list all processes: %w", err)
+	}
+
+	type procInfo struct {
+		uid    string
+		status procfs.ProcStatus
+		stat   procfs.ProcStat
+	}
+	infos := make(map[int]procInfo, len(procs))
+	threadsByUID := map[string]float64{}
+	for _, p := range procs {
+		stat, err := p.Stat()
+		if err != nil {
+			continue
+		}
+		status, err := p.NewStatus()
+		if err != nil {
+			continue
+		}
+		infos[p.PID] = procInfo{uid: status.UIDs[0], status: status, stat: stat}
+		threadsByUID[status.UIDs[0]] += float64(stat.NumThreads)
+	}
+
+	result := make(map[int]*pidsProcLimits, len(infos))
+	for _, p := range procs {
+		info, ok := infos[p.PID]
+		if !ok {
+			continue
+		}
+		f, err := os.Open(procFilePath(strconv.Itoa(p.PID) + "/limits"))
+		if err != nil {
+			report("limits", strconv.Itoa(p.PID), err)
+			continue
+		}
+		limits, err := parsePidsLimits(f)
+		f.Close()
+		if err != nil {
+			report("limits", strconv.Itoa(p.PID), fmt.Errorf("couldn't parse limits: %w", err))
+			continue
+		}
+
+		usage := map[string]float64{
+			"processes":           threadsByUID[info.uid],
+			"locked_memory_bytes": float64(info.status.VmLck),
+			"address_space_bytes": float64(info.status.VmSize),
+		}
+		// The fds of other users' processes are unreadable when running
+		// unprivileged.
+		if fds, err := p.FileDescriptorsLen(); err == nil {
+			usage["open_files"] = float64(fds)
+		}
+
+		pl := &pidsProcLimits{pid: p.PID, comm: info.stat.Comm}
+		for _, res := range pidsLimitResources {
+			u, hasUsage := usage[res.resource]
+			l, hasLimit := limits[res.resource]
+			if !hasUsage || !hasLimit {
+				continue
+			}
+			pl.usages = append(pl.usages, pidsLimitUsage{resource: res.resource, usage: u, pidsLimit: l})
+		}
+		result[p.PID] = pl
+	}
+	return result, nil
+}
+
+// pidsNearestLimit is a process resource close to its soft limit.
+type pidsNearestLimit struct {
+	pid      int
+	comm     string
+	resource string
+	ratio    float64
+}
+
+// nearestPidsLimits returns the n process resources with the highest usage
+// relative to their soft limit.
+func nearestPidsLimits(limits map[int]*pidsProcLimits, n int) []pidsNearestLimit {
+	var nearest []pidsNearestLimit
+	for _, pl := range limits {
+		for _, u := range pl.usages {
+			if r := u.ratio(); r > 0 {
+				nearest = append(nearest, pidsNearestLimit{pid: pl.pid, comm: pl.comm, resource: u.resource, ratio: r})
+			}
+		}
+	}
+	sort.Slice(nearest, func(i, j int) bool {
+		if nearest[i].ratio != nearest[j].ratio {
+			return nearest[i].ratio > nearest[j].ratio
+		}
+		if nearest[i].pid != nearest[j].pid {
+			return nearest[i].pid < nearest[j].pid
+		}
+		return nearest[i].resource < nearest[j].resource
+	})
+	if len(nearest) > n {
+		nearest = nearest[:n]
+	}
+	return nearest
+}
+
+// pidsLimitsMetrics exports the limit usage of processes.
+type pidsLimitsMetrics struct {
+	usage   *prometheus.Desc
+	soft    *prometheus.Desc
+	hard    *prometheus.Desc
+	nearest *prometheus.Desc
+}
+
+func newPidsLimitsMetrics(subsystem string, labelNames []string) *pidsLimitsMetrics {
+	labelNames = append(append([]string{}, labelNames...), "resource")
+	return &pidsLimitsMetrics{
+		usage: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "limit_usage"),
+			"Usage of a resource limited by rlimit, threads of the user for the processes limit.",
+			labelNames, nil,
+		),
+		soft: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "limit_soft"),
+			"Soft resource limit of the process, absent if unlimited.",
+			labelNames, nil,
+		),
+		hard: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "limit_hard"),
+			"Hard resource limit of the process, absent if unlimited.",
+			labelNames, nil,
+		),
+		nearest: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "limit_nearest_ratio"),
+			"Usage relative to the soft limit of the process resources on the host nearest to their limit.",
+			labelNames, nil,
+		),
+	}
+}
+
+func (d *pidsLimitsMetrics) update(ch chan<- prometheus.Metric, pl *pidsProcLimits, labels ...string) {
+	for _, u := range pl.usages {
+		l := append(append([]string{}, labels...), u.resource)
+		ch <- prometheus.MustNewConstMetric(d.usage, prometheus.GaugeValue, u.usage, l...)
+		if !math.IsInf(u.soft, 1) {
+			ch <- prometheus.MustNewConstMetric(d.soft, prometheus.GaugeValue, u.soft, l...)
+		}
+		if !math.IsInf(u.hard, 1) {
+			ch <- prometheus.MustNewConstMetric(d.hard, prometheus.GaugeValue, u.hard, l...)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/procfs"
+)
+
+func TestReadPidsHostLimits(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc"}); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limits, err := readPidsHostLimits(fs, func(operation, pid string, err error) {
+		t.Errorf("unexpected %s error of pid %s: %v", operation, pid, err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only pid 1 has a status and limits file.
+	if want, got := 1, len(limits); want != got {
+		t.Fatalf("want limits of %d process, got %d", want, got)
+	}
+	pl := limits[1]
+	if pl.comm != "systemd" {
+		t.Errorf("want comm systemd, got %q", pl.comm)
+	}
+	// The fixture has no fd directory, so open files are left out.
+	want := []pidsLimitUsage{
+		{resource: "processes", usage: 1, pidsLimit: pidsLimit{soft: 62898, hard: 62898}},
+		{resource: "locked_memory_bytes", usage: 0, pidsLimit: pidsLimit{soft: 8388608, hard: 8388608}},
+		{resource: "address_space_bytes", usage: 170740 * 1024, pidsLimit: pidsLimit{soft: math.Inf(1), hard: math.Inf(1)}},
+	}
+	if !reflect.DeepEqual(want, pl.usages) {
+		t.Errorf("want %+v, got %+v", want, pl.usages)
+	}
+}
+
+func TestReadPidsHostLimitsSkipsBadProcess(t *testing.T) {
+	// pid 1 is copied from the fixtures, pid 2 has garbled limits.
+	dir := t.TempDir()
+	for _, pid := range []string{"1", "2"} {
+		if err := os.Mkdir(filepath.Join(dir, pid), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"stat", "status", "limits"} {
+			data, err := os.ReadFile(filepath.Join("fixtures/proc/1", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pid == "2" && name == "limits" {
+				data = []byte("Max processes             lots                 62898                processes\n")
+			}
+			if err := os.WriteFile(filepath.Join(dir, pid, name), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", dir}); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []string
+	limits, err := readPidsHostLimits(fs, func(operation, pid string, err error) {
+		reported = append(reported, operation+" "+pid)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := limits[1]; !ok || len(limits) != 1 {
+		t.Errorf("want limits of pid 1 only, got %v", limits)
+	}
+	if want := []string{"limits 2"}; !reflect.DeepEqual(want, reported) {
+		t.Errorf("want errors %v, got %v", want, reported)
+	}
+}
+
+func TestNearestPidsLimits(t *testing.T) {
+	limits := map[int]*pidsProcLimits{
+		100: {pid: 100, comm: "postgres", usages: []pidsLimitUsage{
+			{resource: "open_files", usage: 1000, pidsLimit: pidsLimit{soft: 1024, hard: 4096}},
+			{resource: "address_space_bytes", usage: 1 << 30, pidsLimit: pidsLimit{soft: math.Inf(1), hard: math.Inf(1)}},
+		}},
+		200: {pid: 200, comm: "java", usages: []pidsLimitUsage{
+			{resource: "open_files", usage: 100, pidsLimit: pidsLimit{soft: 1024, hard: 4096}},
+			{resource: "processes", usage: 3000, pidsLimit: pidsLimit{soft: 4096, hard: 4096}},
+		}},
+		300: {pid: 300, comm: "sleep", usages: []pidsLimitUsage{
+			{resource: "open_files", usage: 3, pidsLimit: pidsLimit{soft: 0, hard: 0}},
+		}},
+	}
+
+	got := nearestPidsLimits(limits, 2)
+	want := []pidsNearestLimit{
+		{pid: 100, comm: "postgres", resource: "open_files", ratio: 1000.0 / 1024},
+		{pid: 200, comm: "java", resource: "processes", ratio: 3000.0 / 4096},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+	// Unlimited and zero limits are never near.
+	if got := nearestPidsLimits(limits, 10); len(got) != 3 {
+		t.Errorf("want 3 limited resources, got %+v", got)
+	}
+}
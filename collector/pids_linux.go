@@ -18,21 +18,31 @@ package collector
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/go-kit/kit/log"
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/procfs"
+	"golang.org/x/sys/unix"
 )
 
 var (
-	pidsLabelNames = []string{"pid", "cmd"}
+	pidsLabelNames  = []string{"pid", "cmd"}
+	pidsTopCacheTTL = kingpin.Flag("collector.pids.top-cache-ttl", "How long the output of top is reused by later scrapes, 0 runs it on every scrape.").Default("0s").Duration()
 )
 
+// pidsTopCount is the number of processes using the most CPU that are exported.
+const pidsTopCount = 5
+
 type pidsCollector struct {
 	fs                       procfs.FS
 	pidsCpuUtilization       *prometheus.Desc // 进程CPU利用率    %
@@ -43,12 +53,38 @@ type pidsCollector struct {
 	pidsWriteDiskCount       *prometheus.Desc // 进程写入磁盘的次数   Count
 	pidsReadDiskBytes        *prometheus.Desc // 进程读取磁盘的字节数  Bytes
 	pidsWriteDiskBytes       *prometheus.Desc // 进程写入磁盘的字节数  Bytes
-	pidsNetworkReceiveBytes  *prometheus.Desc // 进程接收的网络字节数  Bytes/s
-	pidsNetworkTransmitBytes *prometheus.Desc // 进程发送的网络字节数  Bytes/s
+	pidsCancelledWriteBytes  *prometheus.Desc // 进程取消写入磁盘的字节数  Bytes
+	pidsCpuDelay             *prometheus.Desc // 进程在运行队列中等待的时间  Seconds
+	pidsBlkioDelay           *prometheus.Desc // 进程等待块设备I/O的时间  Seconds
+	pidsSwapinDelay          *prometheus.Desc // 进程等待换入内存页的时间  Seconds
+	pidsNetworkReceiveBytes  *prometheus.Desc // 进程接收的网络字节数  Bytes
+	pidsNetworkTransmitBytes *prometheus.Desc // 进程发送的网络字节数  Bytes
 	pidsNetworkReceivePkg    *prometheus.Desc // 进程接收的网络包数量  Count
 	pidsNetworktransmitPkg   *prometheus.Desc // 进程发送的网络包数量  Count
+	pidsNetworkCaptureDrops  *prometheus.Desc // 抓包环形缓冲区丢弃的包数量  Count
+	pidsSocketReceiveBytes   *prometheus.Desc // 套接字接收的网络字节数  Bytes
+	pidsSocketTransmitBytes  *prometheus.Desc // 套接字发送的网络字节数  Bytes
+	pidsSocketReceivePkg     *prometheus.Desc // 套接字接收的网络包数量  Count
+	pidsSocketTransmitPkg    *prometheus.Desc // 套接字发送的网络包数量  Count
+	oldNetworkReceiveBytes   *prometheus.Desc // 已废弃，进程接收的网络字节数  Bytes/s
+	oldNetworkTransmitBytes  *prometheus.Desc // 已废弃，进程发送的网络字节数  Bytes/s
+	oldNetworkReceivePkg     *prometheus.Desc // 已废弃，进程接收的网络包数量  Count/s
+	oldNetworkTransmitPkg    *prometheus.Desc // 已废弃，进程发送的网络包数量  Count/s
+	pidsNetworkCaptureUp     *prometheus.Desc // 抓包是否正常运行
 	voluntaryCtxtSwitches    *prometheus.Desc // 进程切换上下文数
 	nonvoluntaryCtxtSwitches *prometheus.Desc // 进程切换上下文数
+	memory                   *pidsMemoryMetrics
+	identity                 *pidsIdentity
+	limits                   *pidsLimitsMetrics
+	delay                    *pidsDelayReader
+	groups                   *pidsGroupTracker
+	flow                     *pidsFlowCapture
+	flowEnabled              bool
+	commands                 commandRunner
+	topCacheTTL              time.Duration
+	oomTop                   int
+	limitsTop                int
+	errs                     *errorReporter
 	logger                   log.Logger
 }
 
@@ -56,30 +92,48 @@ func init() {
 	registerCollector("pids", defaultEnabled, NewPidsStatCollector)
 }
 
-func getCpuUtilizationTop5() (map[string][]string, []string, error) {
-	top5Msg, err := execCommand("top -b -n 1 -o %CPU | grep -v top| head -10 | tail -5")
+func (c *pidsCollector) getCpuUtilizationTop5() (map[string][]string, []string, error) {
+	out, err := c.commands.run(context.Background(), command{
+		name:     "top",
+		args:     []string{"top", "-b", "-n", "1", "-o", "%CPU"},
+		cacheTTL: c.topCacheTTL,
+	})
 	if err != nil {
-		fmt.Errorf("couldn't get diskInode: %w", err)
 		return nil, nil, err
 	}
-	return parseTopCmd(top5Msg)
+	return parseTopCmd(string(out))
 }
 
+// parseTopCmd returns the fields of the first pidsTopCount processes listed by
+// top in batch mode, keyed by pid. top itself is skipped.
 func parseTopCmd(str string) (map[string][]string, []string, error) {
 	var (
 		pidsMsg  = map[string][]string{}
 		pidsList = []string{}
+		inTasks  bool
 	)
 
 	for _, pidLine := range strings.Split(str, "\n") {
-
 		parts := strings.Fields(pidLine)
-		if len(parts) != 0 {
-			dev := parts[0]
-			pidsList = append(pidsList, parts[0])
-			pidsMsg[dev] = parts[1:]
+		if len(parts) == 0 {
+			continue
+		}
+		// The summary area ends with the header of the task area.
+		if !inTasks {
+			inTasks = parts[0] == "PID"
+			continue
+		}
+		if len(parts) < 12 {
+			return nil, nil, fmt.Errorf("invalid top line %q", pidLine)
+		}
+		if parts[11] == "top" {
+			continue
+		}
+		pidsList = append(pidsList, parts[0])
+		pidsMsg[parts[0]] = parts[1:]
+		if len(pidsList) == pidsTopCount {
+			break
 		}
-
 	}
 
 	return pidsMsg, pidsList, nil
@@ -91,114 +145,170 @@ func NewPidsStatCollector(logger log.Logger) (Collector, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open procfs: %w", err)
 	}
+	errs := newErrorReporter("pids", logger)
+	var groups *pidsGroupTracker
+	if *pidsGroupsConfigFile != "" {
+		groups, err = newPidsGroupTracker(fs, *pidsGroupsConfigFile, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load process groups: %w", err)
+		}
+	}
+	var flow *pidsFlowCapture
+	if *pidsFlowEnabled {
+		flow, err = newPidsFlowCapture(fs, errs, logger)
+		if err != nil {
+			// Capturing needs CAP_NET_RAW, keep the rest of the collector working without it.
+			level.Warn(logger).Log("msg", "Per-process traffic accounting disabled", "err", err)
+			flow = nil
+		}
+	}
+	identity := newPidsIdentity(fs)
+	labelNames := append(append([]string{}, pidsLabelNames...), identity.labelNames()...)
+	socketLabelNames := append(append([]string{}, labelNames...), "inode")
 	subsystem := "pids"
+	var limits *pidsLimitsMetrics
+	if *pidsLimitsEnabled {
+		limits = newPidsLimitsMetrics(subsystem, labelNames)
+	}
 	return &pidsCollector{
-		fs: fs,
+		fs:          fs,
+		memory:      newPidsMemoryMetrics(subsystem, labelNames),
+		identity:    identity,
+		limits:      limits,
+		delay:       newPidsDelayReader(fs, logger),
+		groups:      groups,
+		flow:        flow,
+		flowEnabled: *pidsFlowEnabled,
+		commands:    getCommandRunner(),
+		topCacheTTL: *pidsTopCacheTTL,
+		oomTop:      *pidsOOMTop,
+		limitsTop:   *pidsLimitsTop,
 		pidsCpuUtilization: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, subsystem, "pidsCpuUtilization"),
 			"CPU utilization of processes",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		pidsMemUtilization: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, subsystem, "pidsMemUtilization"),
 			"Process memory usage rate",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		pidsThreadNum: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, subsystem, "pidsThreadNum"),
 			"The number of threads used by the process.",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		pidsFdUsed: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsFdUsed"),
 			"The number of file descriptors used by the process",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		voluntaryCtxtSwitches: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "voluntaryCtxtSwitches"),
 			"voluntaryCtxtSwitches",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		nonvoluntaryCtxtSwitches: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "nonvoluntaryCtxtSwitches"),
 			"nonvoluntaryCtxtSwitches",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		pidsReadDiskCount: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsReadDiskCount"),
 			"pidsReadDiskCount",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		pidsWriteDiskCount: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsWriteDiskCount"),
 			"pidsWriteDisk",
-			pidsLabelNames, nil,
+			labelNames, nil,
 		),
 		pidsReadDiskBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsReadDiskBytes"),
-			"pidsReadDiskBytes",
-			pidsLabelNames, nil,
+			"Bytes the process caused to be fetched from the storage layer.",
+			labelNames, nil,
 		),
 		pidsWriteDiskBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsWriteDiskBytes"),
-			"pidsWriteDiskBytes",
-			pidsLabelNames, nil,
+			"Bytes the process caused to be sent to the storage layer.",
+			labelNames, nil,
+		),
+		pidsCancelledWriteBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "cancelled_write_bytes_total"),
+			"Bytes the process did not write to the storage layer after all because the dirty page cache was truncated.",
+			labelNames, nil,
+		),
+		pidsCpuDelay: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "cpu_delay_seconds_total"),
+			"Time the process spent runnable waiting on a run queue.",
+			labelNames, nil,
+		),
+		pidsBlkioDelay: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "blkio_delay_seconds_total"),
+			"Time the process spent waiting for synchronous block I/O to complete, needs delay accounting.",
+			labelNames, nil,
+		),
+		pidsSwapinDelay: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "swapin_delay_seconds_total"),
+			"Time the process spent waiting for pages to be swapped in, needs taskstats delay accounting.",
+			labelNames, nil,
+		),
+		pidsNetworkReceiveBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "network_receive_bytes_total"),
+			"Network bytes received by the sockets of the process.",
+			labelNames, nil,
+		),
+		pidsNetworkTransmitBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "network_transmit_bytes_total"),
+			"Network bytes transmitted by the sockets of the process.",
+			labelNames, nil,
+		),
+		pidsNetworkReceivePkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "network_receive_packets_total"),
+			"Network packets received by the sockets of the process.",
+			labelNames, nil,
+		),
+		pidsNetworktransmitPkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "network_transmit_packets_total"),
+			"Network packets transmitted by the sockets of the process.",
+			labelNames, nil,
+		),
+		pidsSocketReceiveBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "socket_receive_bytes_total"),
+			"Network bytes received by a socket of the process.",
+			socketLabelNames, nil,
 		),
-		pidsNetworkReceiveBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworkReceiveBytes"),
-			"pidsNetworkReceiveBytes",
-			pidsLabelNames, nil,
+		pidsSocketTransmitBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "socket_transmit_bytes_total"),
+			"Network bytes transmitted by a socket of the process.",
+			socketLabelNames, nil,
 		),
-		pidsNetworkTransmitBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworkTransmitBytes"),
-			"pidsNetworkTransmit",
-			pidsLabelNames, nil,
+		pidsSocketReceivePkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "socket_receive_packets_total"),
+			"Network packets received by a socket of the process.",
+			socketLabelNames, nil,
 		),
-		pidsNetworkReceivePkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworkReceivePkg"),
-			"pidsNetworkReceivePkg",
-			pidsLabelNames, nil,
+		pidsSocketTransmitPkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "socket_transmit_packets_total"),
+			"Network packets transmitted by a socket of the process.",
+			socketLabelNames, nil,
 		),
-		pidsNetworktransmitPkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworktransmitPkg"),
-			"pidsNetworktransmitPkg",
-			pidsLabelNames, nil,
+		oldNetworkReceiveBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworkReceiveBytes"),
+			"DEPRECATED: Use node_pids_network_receive_bytes_total. Network bytes per second received by the sockets of the process.",
+			labelNames, nil,
 		),
+		oldNetworkTransmitBytes: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworkTransmitBytes"),
+			"DEPRECATED: Use node_pids_network_transmit_bytes_total. Network bytes per second transmitted by the sockets of the process.",
+			labelNames, nil,
+		),
+		oldNetworkReceivePkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworkReceivePkg"),
+			"DEPRECATED: Use node_pids_network_receive_packets_total. Network packets per second received by the sockets of the process.",
+			labelNames, nil,
+		),
+		oldNetworkTransmitPkg: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "pidsNetworktransmitPkg"),
+			"DEPRECATED: Use node_pids_network_transmit_packets_total. Network packets per second transmitted by the sockets of the process.",
+			labelNames, nil,
+		),
+		pidsNetworkCaptureDrops: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "network_capture_dropped_packets_total"),
+			"Packets dropped by the kernel before per-process traffic accounting could see them.",
+			nil, nil,
+		),
+		pidsNetworkCaptureUp: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "network_capture_up"),
+			"Whether per-process traffic accounting is capturing packets.",
+			nil, nil,
+		),
+		errs:   errs,
 		logger: logger,
 	}, nil
 }
 
-func (c *pidsCollector) getPidIoFile(pidStr string) (map[string]string, error) {
-	file, err := os.Open(procFilePath(pidStr + "/io"))
-
+func (c *pidsCollector) getPidIo(pid int) (procfs.ProcIO, error) {
+	p, err := c.fs.Proc(pid)
 	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	return c.parsePidIoFile(file)
-}
-
-func (c *pidsCollector) parsePidIoFile(r io.Reader) (map[string]string, error) {
-	var (
-		pidIoMap = map[string]string{
-			"rchar": "0",
-			"wchar": "0",
-			"syscr": "0",
-			"syscw": "0",
-			// "read_bytes":   "0",
-			// "writes_bytes": "0",
-		}
-		scanner = bufio.NewScanner(r)
-	)
-
-	for scanner.Scan() {
-		textLine := scanner.Text()
-		parts := strings.Fields(textLine)
-		if strings.Contains(textLine, "rchar") {
-			pidIoMap["rchar"] = parts[1]
-		}
-		if strings.Contains(textLine, "wchar") {
-			pidIoMap["wchar"] = parts[1]
-		}
-		if strings.Contains(textLine, "syscr") {
-			pidIoMap["syscr"] = parts[1]
-		}
-		if strings.Contains(textLine, "syscw") {
-			pidIoMap["syscw"] = parts[1]
-		}
+		return procfs.ProcIO{}, err
 	}
-
-	return pidIoMap, scanner.Err()
+	return p.IO()
 }
 
 func (c *pidsCollector) getPidStatusFile(pidStr string) (map[string]string, error) {
@@ -240,73 +350,165 @@ func (c *pidsCollector) parsePidStatus(r io.Reader) (map[string]string, error) {
 func (c *pidsCollector) getFdNum(pidStr string) (float64, error) {
 	fdFiles, err := os.ReadDir(procFilePath(pidStr + "/fd"))
 	if err != nil {
-		fmt.Errorf("getFdNum err:", err)
 		return 0, err
 	}
 	return float64(len(fdFiles)), err
 }
 
+// Close stops the traffic accounting and releases the taskstats connection.
+func (c *pidsCollector) Close() error {
+	if c.flow != nil {
+		c.flow.close()
+	}
+	return c.delay.close()
+}
+
+// reportProcessError reports a failed read of a process. Processes which
+// exited since they were listed aren't errors.
+// pidsComm returns the comm of pid, or fallback if the process is gone. All
+// series of a process use the comm, the COMMAND column of top is cut to the
+// width of the terminal.
+func pidsComm(fs procfs.FS, pid int, fallback string) string {
+	p, err := fs.Proc(pid)
+	if err != nil {
+		return fallback
+	}
+	comm, err := p.Comm()
+	if err != nil {
+		return fallback
+	}
+	return comm
+}
+
+func (c *pidsCollector) reportProcessError(operation, pid string, err error) {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, unix.ESRCH) {
+		level.Debug(c.logger).Log("msg", "process exited before it was read", "operation", operation, "pid", pid, "err", err)
+		return
+	}
+	c.errs.report(operation, err, "pid", pid)
+}
+
 func (c *pidsCollector) Update(ch chan<- prometheus.Metric) error {
-	pidsStats, pidsList, err := getCpuUtilizationTop5()
+	if c.groups != nil {
+		if err := c.groups.Update(ch); err != nil {
+			return fmt.Errorf("couldn't get process groups: %w", err)
+		}
+	}
+
+	pidsStats, _, err := c.getCpuUtilizationTop5()
 	if err != nil {
 		return fmt.Errorf("couldn't get pidsStats: %w", err)
 	}
-	recFlow, recPkg, tmtFlow, tmtPkg, flowSec := sumPidFlow(pidsList)
+	var hostLimits map[int]*pidsProcLimits
+	if c.limits != nil {
+		if hostLimits, err = readPidsHostLimits(c.fs, c.reportProcessError); err != nil {
+			return fmt.Errorf("couldn't get process limits: %w", err)
+		}
+	}
+	if c.flowEnabled {
+		up := 0.0
+		if c.flow != nil && c.flow.capturing() {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.pidsNetworkCaptureUp, prometheus.GaugeValue, up)
+	}
+	if c.flow != nil {
+		ch <- prometheus.MustNewConstMetric(c.pidsNetworkCaptureDrops, prometheus.CounterValue, float64(c.flow.droppedPackets()))
+	}
 
+	now := time.Now()
 	for ppid, stats := range pidsStats {
 
-		cmd_name := stats[10]
+		pid, err := strconv.Atoi(ppid)
+		if err != nil {
+			continue
+		}
+		cmd_name := pidsComm(c.fs, pid, stats[10])
+		labels := append([]string{ppid, cmd_name}, c.identity.labelValues(pid)...)
 
 		pCpuUtil, _ := strconv.ParseFloat(stats[7], 64)
 		pMemUtil, _ := strconv.ParseFloat(stats[8], 64)
 
-		ch <- prometheus.MustNewConstMetric(c.pidsCpuUtilization, prometheus.GaugeValue, pCpuUtil, ppid, cmd_name)
-		ch <- prometheus.MustNewConstMetric(c.pidsMemUtilization, prometheus.GaugeValue, pMemUtil, ppid, cmd_name)
+		ch <- prometheus.MustNewConstMetric(c.pidsCpuUtilization, prometheus.GaugeValue, pCpuUtil, labels...)
+		ch <- prometheus.MustNewConstMetric(c.pidsMemUtilization, prometheus.GaugeValue, pMemUtil, labels...)
 
 		// 从/proc/pid/status 获取结果
 		pidThreadContxt, err := c.getPidStatusFile(ppid)
 		if err != nil {
-			fmt.Errorf("couldn't get pidThreadContxt: %w", err)
+			c.reportProcessError("status", ppid, err)
 		} else {
 			threadNum, _ := strconv.ParseFloat(pidThreadContxt["Threads"], 64)
 			vCtxtSwitch, _ := strconv.ParseFloat(pidThreadContxt["voluntary_ctxt_switches"], 64)
 			uvCtxtSwitch, _ := strconv.ParseFloat(pidThreadContxt["nonvoluntary_ctxt_switches"], 64)
-			ch <- prometheus.MustNewConstMetric(c.pidsThreadNum, prometheus.GaugeValue, threadNum, ppid, cmd_name)
-			ch <- prometheus.MustNewConstMetric(c.voluntaryCtxtSwitches, prometheus.CounterValue, vCtxtSwitch, ppid, cmd_name)
-			ch <- prometheus.MustNewConstMetric(c.nonvoluntaryCtxtSwitches, prometheus.CounterValue, uvCtxtSwitch, ppid, cmd_name)
+			ch <- prometheus.MustNewConstMetric(c.pidsThreadNum, prometheus.GaugeValue, threadNum, labels...)
+			ch <- prometheus.MustNewConstMetric(c.voluntaryCtxtSwitches, prometheus.CounterValue, vCtxtSwitch, labels...)
+			ch <- prometheus.MustNewConstMetric(c.nonvoluntaryCtxtSwitches, prometheus.CounterValue, uvCtxtSwitch, labels...)
 		}
 
 		// 从/proc/pid/fd 获取结果
 		pidFdNum, err := c.getFdNum(ppid)
 		if err != nil {
-			fmt.Errorf("couldn't get pidFdNum: %w", err)
+			c.reportProcessError("fd", ppid, err)
 		} else {
-			ch <- prometheus.MustNewConstMetric(c.pidsFdUsed, prometheus.GaugeValue, pidFdNum, ppid, cmd_name)
+			ch <- prometheus.MustNewConstMetric(c.pidsFdUsed, prometheus.GaugeValue, pidFdNum, labels...)
 		}
 
 		// 从/proc/pid/io 获取结果
-		pidsIoMap, err := c.getPidIoFile(ppid)
-		if err != nil {
-			fmt.Errorf("couldn't get pidIo: %w", err)
+		if pidsIo, err := c.getPidIo(pid); err != nil {
+			c.reportProcessError("io", ppid, err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(c.pidsReadDiskBytes, prometheus.CounterValue, float64(pidsIo.ReadBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsWriteDiskBytes, prometheus.CounterValue, float64(pidsIo.WriteBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsCancelledWriteBytes, prometheus.CounterValue, float64(pidsIo.CancelledWriteBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsReadDiskCount, prometheus.CounterValue, float64(pidsIo.SyscR), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsWriteDiskCount, prometheus.CounterValue, float64(pidsIo.SyscW), labels...)
+		}
+
+		// 从taskstats或/proc/pid/schedstat 获取延迟结果
+		if delay, err := c.delay.read(pid); err != nil {
+			c.reportProcessError("delay", ppid, err)
 		} else {
-			rchar, _ := strconv.ParseFloat(pidsIoMap["rchar"], 64)
-			wchar, _ := strconv.ParseFloat(pidsIoMap["wchar"], 64)
-			syscr, _ := strconv.ParseFloat(pidsIoMap["syscr"], 64)
-			syscw, _ := strconv.ParseFloat(pidsIoMap["syscw"], 64)
-			ch <- prometheus.MustNewConstMetric(c.pidsReadDiskBytes, prometheus.CounterValue, rchar, ppid, cmd_name)
-			ch <- prometheus.MustNewConstMetric(c.pidsWriteDiskBytes, prometheus.CounterValue, wchar, ppid, cmd_name)
-			ch <- prometheus.MustNewConstMetric(c.pidsReadDiskCount, prometheus.CounterValue, syscr, ppid, cmd_name)
-			ch <- prometheus.MustNewConstMetric(c.pidsWriteDiskCount, prometheus.CounterValue, syscw, ppid, cmd_name)
+			ch <- prometheus.MustNewConstMetric(c.pidsCpuDelay, prometheus.CounterValue, delay.cpu, labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsBlkioDelay, prometheus.CounterValue, delay.blkio, labels...)
+			if delay.hasSwapin {
+				ch <- prometheus.MustNewConstMetric(c.pidsSwapinDelay, prometheus.CounterValue, delay.swapin, labels...)
+			}
+		}
+
+		// 从/proc/pid/status, smaps_rollup, oom_score 获取内存结果
+		if mem, err := readPidsMemory(c.fs, pid); err != nil {
+			c.reportProcessError("memory", ppid, err)
+		} else {
+			c.memory.update(ch, mem, labels...)
+		}
+
+		// 从/proc/pid/limits 获取资源限制结果
+		if pl, ok := hostLimits[pid]; ok {
+			c.limits.update(ch, pl, labels...)
 		}
 
-		pidsNetworkReceiveBytes := float64(recFlow[ppid])/flowSec
-		pidsNetworkReceivePkg := float64(recPkg[ppid])/flowSec
-		pidsNetworkTransmitBytes := float64(tmtFlow[ppid])/flowSec
-		pidsNetworktransmitPkg := float64(tmtPkg[ppid])/flowSec
-		ch <- prometheus.MustNewConstMetric(c.pidsNetworkReceiveBytes, prometheus.GaugeValue, pidsNetworkReceiveBytes, ppid, cmd_name)
-		ch <- prometheus.MustNewConstMetric(c.pidsNetworkReceivePkg, prometheus.GaugeValue, pidsNetworkReceivePkg, ppid, cmd_name)
-		ch <- prometheus.MustNewConstMetric(c.pidsNetworkTransmitBytes, prometheus.GaugeValue, pidsNetworkTransmitBytes, ppid, cmd_name)
-		ch <- prometheus.MustNewConstMetric(c.pidsNetworktransmitPkg, prometheus.GaugeValue, pidsNetworktransmitPkg, ppid, cmd_name)
+		// 从后台抓包累计的计数器获取结果
+		if c.flow != nil {
+			flow := c.flow.processCounters(pid)
+			ch <- prometheus.MustNewConstMetric(c.pidsNetworkReceiveBytes, prometheus.CounterValue, float64(flow.rxBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsNetworkReceivePkg, prometheus.CounterValue, float64(flow.rxPackets), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsNetworkTransmitBytes, prometheus.CounterValue, float64(flow.txBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pidsNetworktransmitPkg, prometheus.CounterValue, float64(flow.txPackets), labels...)
+
+			rates := c.flow.processRates(pid, now)
+			ch <- prometheus.MustNewConstMetric(c.oldNetworkReceiveBytes, prometheus.GaugeValue, rates.rxBytes, labels...)
+			ch <- prometheus.MustNewConstMetric(c.oldNetworkReceivePkg, prometheus.GaugeValue, rates.rxPackets, labels...)
+			ch <- prometheus.MustNewConstMetric(c.oldNetworkTransmitBytes, prometheus.GaugeValue, rates.txBytes, labels...)
+			ch <- prometheus.MustNewConstMetric(c.oldNetworkTransmitPkg, prometheus.GaugeValue, rates.txPackets, labels...)
+
+			for inode, s := range c.flow.socketCounters(pid) {
+				socketLabels := append(append([]string{}, labels...), strconv.FormatUint(inode, 10))
+				ch <- prometheus.MustNewConstMetric(c.pidsSocketReceiveBytes, prometheus.CounterValue, float64(s.rxBytes), socketLabels...)
+				ch <- prometheus.MustNewConstMetric(c.pidsSocketReceivePkg, prometheus.CounterValue, float64(s.rxPackets), socketLabels...)
+				ch <- prometheus.MustNewConstMetric(c.pidsSocketTransmitBytes, prometheus.CounterValue, float64(s.txBytes), socketLabels...)
+				ch <- prometheus.MustNewConstMetric(c.pidsSocketTransmitPkg, prometheus.CounterValue, float64(s.txPackets), socketLabels...)
+			}
+		}
 
 		// // 从/proc/pid/net/dev
 		// pidNetStatus, err := c.getPidNetDevFile(ppid)
@@ -326,5 +528,31 @@ func (c *pidsCollector) Update(ch chan<- prometheus.Metric) error {
 		// }
 	}
 
+	// The OOM killer picks by oom_score, not by CPU usage, so make sure the
+	// next victims are reported too.
+	candidates, err := topOOMCandidates(c.fs, c.oomTop)
+	if err != nil {
+		return fmt.Errorf("couldn't get OOM candidates: %w", err)
+	}
+	for _, p := range candidates {
+		pid := strconv.Itoa(p.pid)
+		if _, ok := pidsStats[pid]; ok {
+			continue
+		}
+		mem, err := readPidsMemory(c.fs, p.pid)
+		if err != nil {
+			c.reportProcessError("memory", pid, err)
+			continue
+		}
+		c.memory.update(ch, mem, append([]string{pid, p.comm}, c.identity.labelValues(p.pid)...)...)
+	}
+
+	if c.limits != nil {
+		for _, n := range nearestPidsLimits(hostLimits, c.limitsTop) {
+			labels := append([]string{strconv.Itoa(n.pid), n.comm}, c.identity.labelValues(n.pid)...)
+			ch <- prometheus.MustNewConstMetric(c.limits.nearest, prometheus.GaugeValue, n.ratio, append(labels, n.resource)...)
+		}
+	}
+
 	return nil
 }
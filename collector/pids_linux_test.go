@@ -0,0 +1,93 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/procfs"
+)
+
+const pidsTopOutput = `top - 10:21:07 up 12 days,  3:02,  1 user,  load average: 1.51, 1.12, 0.98
+Tasks: 312 total,   2 running, 310 sleeping,   0 stopped,   0 zombie
+%Cpu(s):  6.2 us,  1.6 sy,  0.0 ni, 92.2 id,  0.0 wa,  0.0 hi,  0.0 si,  0.0 st
+MiB Mem :  64215.3 total,  40111.9 free,  12207.0 used,  11896.4 buff/cache
+MiB Swap:   8192.0 total,   8192.0 free,      0.0 used.  51243.2 avail Mem
+
+    PID USER      PR  NI    VIRT    RES    SHR S  %CPU  %MEM     TIME+ COMMAND
+   4242 root      20   0 2817564 512004  48212 S  93.8   0.8 120:11.02 ceph-osd
+   9100 nobody    20   0  733120  24500  12004 S  12.5   0.0   3:01.45 node_exporter
+  31337 agent     20   0   10932   4100   3300 R   6.2   0.0   0:00.01 top
+      1 root      20   0  168532  13200   8400 S   0.0   0.0   0:21.77 systemd
+      2 root      20   0       0      0      0 S   0.0   0.0   0:00.12 kthreadd
+     11 root      20   0       0      0      0 I   0.0   0.0   1:02.00 rcu_preempt
+     12 root      20   0       0      0      0 S   0.0   0.0   0:00.00 migration/0
+`
+
+type fakeCommandRunner struct {
+	out  map[string]string
+	runs []command
+}
+
+func (r *fakeCommandRunner) run(ctx context.Context, cmd command) ([]byte, error) {
+	r.runs = append(r.runs, cmd)
+	return []byte(r.out[cmd.name]), nil
+}
+
+func TestPidsCpuUtilizationTop5(t *testing.T) {
+	runner := &fakeCommandRunner{out: map[string]string{"top": pidsTopOutput}}
+	c := &pidsCollector{commands: runner}
+
+	stats, pids, err := c.getCpuUtilizationTop5()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"4242", "9100", "1", "2", "11"}, pids; !reflect.DeepEqual(want, got) {
+		t.Errorf("want pids %v, got %v", want, got)
+	}
+	if want, got := "ceph-osd", stats["4242"][10]; want != got {
+		t.Errorf("want command %q, got %q", want, got)
+	}
+	if want, got := "93.8", stats["4242"][7]; want != got {
+		t.Errorf("want %%CPU %q, got %q", want, got)
+	}
+	if want, got := []string{"top", "-b", "-n", "1", "-o", "%CPU"}, runner.runs[0].args; !reflect.DeepEqual(want, got) {
+		t.Errorf("want argv %v, got %v", want, got)
+	}
+}
+
+func TestParseTopCmdError(t *testing.T) {
+	if _, _, err := parseTopCmd("    PID USER\n   1 root 20\n"); err == nil {
+		t.Error("want error for a truncated task line")
+	}
+}
+
+func TestPidsComm(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// top cuts long commands, the comm is used instead.
+	if want, got := "rcu_preempt", pidsComm(fs, 11, "rcu_pree+"); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := "ceph-osd", pidsComm(fs, 4242, "ceph-osd"); want != got {
+		t.Errorf("want the fallback %q for an exited process, got %q", want, got)
+	}
+}
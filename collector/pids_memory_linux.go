@@ -0,0 +1,184 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+var (
+	pidsOOMTop = kingpin.Flag("collector.pids.oom-top", "Also report the memory of this many processes with the highest OOM score.").Default("5").Int()
+)
+
+// pidsMemoryStats is the memory usage of a single process.
+type pidsMemoryStats struct {
+	rss      uint64
+	rssAnon  uint64
+	rssFile  uint64
+	rssShmem uint64
+	swap     uint64
+	// pss and uss come from smaps_rollup, which needs ptrace access to the
+	// process and is skipped otherwise.
+	hasRollup   bool
+	pss         uint64
+	uss         uint64
+	minorFaults uint
+	majorFaults uint
+	oomScore    int64
+	oomScoreAdj int64
+}
+
+func readPidsMemory(fs procfs.FS, pid int) (pidsMemoryStats, error) {
+	var m pidsMemoryStats
+	p, err := fs.Proc(pid)
+	if err != nil {
+		return m, err
+	}
+	status, err := p.NewStatus()
+	if err != nil {
+		return m, fmt.Errorf("couldn't get status: %w", err)
+	}
+	m.rss, m.rssAnon, m.rssFile, m.rssShmem = status.VmRSS, status.RssAnon, status.RssFile, status.RssShmem
+	m.swap = status.VmSwap
+
+	stat, err := p.Stat()
+	if err != nil {
+		return m, fmt.Errorf("couldn't get stat: %w", err)
+	}
+	m.minorFaults, m.majorFaults = stat.MinFlt, stat.MajFlt
+
+	if m.oomScore, err = readIntFromFile(procFilePath(strconv.Itoa(pid) + "/oom_score")); err != nil {
+		return m, fmt.Errorf("couldn't get oom_score: %w", err)
+	}
+	if m.oomScoreAdj, err = readIntFromFile(procFilePath(strconv.Itoa(pid) + "/oom_score_adj")); err != nil {
+		return m, fmt.Errorf("couldn't get oom_score_adj: %w", err)
+	}
+
+	if rollup, err := p.ProcSMapsRollup(); err == nil {
+		m.hasRollup = true
+		m.pss = rollup.Pss
+		m.uss = rollup.PrivateClean + rollup.PrivateDirty
+	}
+	return m, nil
+}
+
+func readIntFromFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// pidsOOMCandidate is a process the OOM killer is likely to pick.
+type pidsOOMCandidate struct {
+	pid      int
+	comm     string
+	oomScore int64
+}
+
+// topOOMCandidates returns the n processes with the highest oom_score, which
+// are the ones the OOM killer picks first.
+func topOOMCandidates(fs procfs.FS, n int) ([]pidsOOMCandidate, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list all processes: %w", err)
+	}
+	candidates := make([]pidsOOMCandidate, 0, len(procs))
+	for _, p := range procs {
+		score, err := readIntFromFile(procFilePath(strconv.Itoa(p.PID) + "/oom_score"))
+		if err != nil {
+			// The process exited in the meantime.
+			continue
+		}
+		candidates = append(candidates, pidsOOMCandidate{pid: p.PID, oomScore: score})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].oomScore != candidates[j].oomScore {
+			return candidates[i].oomScore > candidates[j].oomScore
+		}
+		return candidates[i].pid < candidates[j].pid
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	for i := range candidates {
+		candidates[i].comm = pidsComm(fs, candidates[i].pid, "")
+	}
+	return candidates, nil
+}
+
+// pidsMemoryMetrics exports pidsMemoryStats.
+type pidsMemoryMetrics struct {
+	rss         *prometheus.Desc
+	rssAnon     *prometheus.Desc
+	rssFile     *prometheus.Desc
+	rssShmem    *prometheus.Desc
+	pss         *prometheus.Desc
+	uss         *prometheus.Desc
+	swap        *prometheus.Desc
+	minorFaults *prometheus.Desc
+	majorFaults *prometheus.Desc
+	oomScore    *prometheus.Desc
+	oomScoreAdj *prometheus.Desc
+}
+
+func newPidsMemoryMetrics(subsystem string, labelNames []string) *pidsMemoryMetrics {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labelNames, nil)
+	}
+	return &pidsMemoryMetrics{
+		rss:         desc("memory_rss_bytes", "Resident set size of the process."),
+		rssAnon:     desc("memory_rss_anon_bytes", "Resident anonymous memory of the process."),
+		rssFile:     desc("memory_rss_file_bytes", "Resident file mappings of the process."),
+		rssShmem:    desc("memory_rss_shmem_bytes", "Resident shared memory of the process."),
+		pss:         desc("memory_pss_bytes", "Proportional set size of the process, shared pages are split between the processes mapping them."),
+		uss:         desc("memory_uss_bytes", "Unique set size of the process, the memory freed if the process exits."),
+		swap:        desc("memory_swap_bytes", "Anonymous memory of the process swapped out."),
+		minorFaults: desc("minor_page_faults_total", "Page faults of the process that did not need to load a page from disk."),
+		majorFaults: desc("major_page_faults_total", "Page faults of the process that loaded a page from disk."),
+		oomScore:    desc("oom_score", "Badness of the process for the OOM killer, the highest score is killed first."),
+		oomScoreAdj: desc("oom_score_adj", "Adjustment of the OOM score of the process, -1000 disables OOM killing."),
+	}
+}
+
+func (d *pidsMemoryMetrics) update(ch chan<- prometheus.Metric, m pidsMemoryStats, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(d.rss, prometheus.GaugeValue, float64(m.rss), labels...)
+	ch <- prometheus.MustNewConstMetric(d.rssAnon, prometheus.GaugeValue, float64(m.rssAnon), labels...)
+	ch <- prometheus.MustNewConstMetric(d.rssFile, prometheus.GaugeValue, float64(m.rssFile), labels...)
+	ch <- prometheus.MustNewConstMetric(d.rssShmem, prometheus.GaugeValue, float64(m.rssShmem), labels...)
+	ch <- prometheus.MustNewConstMetric(d.swap, prometheus.GaugeValue, float64(m.swap), labels...)
+	if m.hasRollup {
+		ch <- prometheus.MustNewConstMetric(d.pss, prometheus.GaugeValue, float64(m.pss), labels...)
+		ch <- prometheus.MustNewConstMetric(d.uss, prometheus.GaugeValue, float64(m.uss), labels...)
+	}
+	ch <- prometheus.MustNewConstMetric(d.minorFaults, prometheus.CounterValue, float64(m.minorFaults), labels...)
+	ch <- prometheus.MustNewConstMetric(d.majorFaults, prometheus.CounterValue, float64(m.majorFaults), labels...)
+	ch <- prometheus.MustNewConstMetric(d.oomScore, prometheus.GaugeValue, float64(m.oomScore), labels...)
+	ch <- prometheus.MustNewConstMetric(d.oomScoreAdj, prometheus.GaugeValue, float64(m.oomScoreAdj), labels...)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nopids
+// +build !nopids
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/procfs"
+)
+
+func TestReadPidsMemory(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc"}); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readPidsMemory(fs, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := pidsMemoryStats{
+		rss:         10028 * 1024,
+		rssAnon:     3368 * 1024,
+		rssFile:     6660 * 1024,
+		swap:        236 * 1024,
+		hasRollup:   true,
+		pss:         4096 * 1024,
+		uss:         (324 + 3164) * 1024,
+		minorFaults: 9061,
+		majorFaults: 94,
+		oomScore:    0,
+		oomScoreAdj: -1000,
+	}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	// pid 10 has no status file.
+	if _, err := readPidsMemory(fs, 10); err == nil {
+		t.Error("expected error for process without status")
+	}
+}
+
+func TestTopOOMCandidates(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc"}); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := topOOMCandidates(fs, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pidsOOMCandidate{
+		{pid: 11, comm: "rcu_preempt", oomScore: 667},
+		{pid: 1, comm: "systemd", oomScore: 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %+v, got %+v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want candidate %+v, got %+v", want[i], got[i])
+		}
+	}
+
+	if got, _ := topOOMCandidates(fs, 1); len(got) != 1 || got[0].pid != 11 {
+		t.Errorf("want only pid 11, got %+v", got)
+	}
+}
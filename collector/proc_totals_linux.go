@@ -0,0 +1,162 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"os/user"
+	"sync"
+
+	"github.com/prometheus/procfs"
+)
+
+// pidsSecondsPerTick converts the clock ticks of /proc/<pid>/stat to seconds,
+// using the USER_HZ procfs converts the CPU times of the rest of the repo with.
+var pidsSecondsPerTick = procfs.ProcStat{UTime: 1}.CPUTime()
+
+// pidsProcSample is one observation of a process assigned to a group.
+type pidsProcSample struct {
+	pid       int
+	startTime uint64
+	group     string
+
+	userSeconds      float64
+	systemSeconds    float64
+	readBytes        float64
+	writeBytes       float64
+	voluntaryCtxt    float64
+	nonvoluntaryCtxt float64
+
+	rssBytes float64
+	threads  float64
+	fds      float64
+}
+
+type pidsProcKey struct {
+	pid       int
+	startTime uint64
+}
+
+// pidsGroupStats is the aggregated state of one group. Counters include the
+// contribution of processes that have already exited, so they stay monotonic
+// while group members come and go.
+type pidsGroupStats struct {
+	userSeconds      float64
+	systemSeconds    float64
+	readBytes        float64
+	writeBytes       float64
+	voluntaryCtxt    float64
+	nonvoluntaryCtxt float64
+
+	processes float64
+	rssBytes  float64
+	threads   float64
+	fds       float64
+}
+
+// readPidsProcSample reads the accounting of a process. I/O and fds are left
+// at zero if they are unreadable, e.g. for processes of other users when
+// running unprivileged.
+func readPidsProcSample(p procfs.Proc, stat procfs.ProcStat, status procfs.ProcStatus) pidsProcSample {
+	sample := pidsProcSample{
+		pid:              p.PID,
+		startTime:        stat.Starttime,
+		userSeconds:      float64(stat.UTime) * pidsSecondsPerTick,
+		systemSeconds:    float64(stat.STime) * pidsSecondsPerTick,
+		voluntaryCtxt:    float64(status.VoluntaryCtxtSwitches),
+		nonvoluntaryCtxt: float64(status.NonVoluntaryCtxtSwitches),
+		rssBytes:         float64(stat.ResidentMemory()),
+		threads:          float64(stat.NumThreads),
+	}
+	if io, err := p.IO(); err == nil {
+		sample.readBytes = float64(io.ReadBytes)
+		sample.writeBytes = float64(io.WriteBytes)
+	}
+	if fds, err := p.FileDescriptorsLen(); err == nil {
+		sample.fds = float64(fds)
+	}
+	return sample
+}
+
+// observePidsSamples folds a new set of samples into totals and returns them
+// as procs for the next call. Counters only grow by the delta of a process
+// since its previous sample, so a process that exits keeps its share and a
+// new one is added in full.
+func observePidsSamples(procs map[pidsProcKey]pidsProcSample, totals map[string]*pidsGroupStats, samples []pidsProcSample) map[pidsProcKey]pidsProcSample {
+	for _, s := range totals {
+		s.processes, s.rssBytes, s.threads, s.fds = 0, 0, 0, 0
+	}
+
+	next := make(map[pidsProcKey]pidsProcSample, len(samples))
+	for _, cur := range samples {
+		key := pidsProcKey{pid: cur.pid, startTime: cur.startTime}
+		total, ok := totals[cur.group]
+		if !ok {
+			total = &pidsGroupStats{}
+			totals[cur.group] = total
+		}
+
+		prev, ok := procs[key]
+		if !ok || prev.group != cur.group {
+			prev = pidsProcSample{}
+		}
+		total.userSeconds += counterDelta(prev.userSeconds, cur.userSeconds)
+		total.systemSeconds += counterDelta(prev.systemSeconds, cur.systemSeconds)
+		total.readBytes += counterDelta(prev.readBytes, cur.readBytes)
+		total.writeBytes += counterDelta(prev.writeBytes, cur.writeBytes)
+		total.voluntaryCtxt += counterDelta(prev.voluntaryCtxt, cur.voluntaryCtxt)
+		total.nonvoluntaryCtxt += counterDelta(prev.nonvoluntaryCtxt, cur.nonvoluntaryCtxt)
+
+		total.processes++
+		total.rssBytes += cur.rssBytes
+		total.threads += cur.threads
+		total.fds += cur.fds
+
+		next[key] = cur
+	}
+	return next
+}
+
+func counterDelta(prev, cur float64) float64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// pidsUserCache caches the user names of uids, looking them up in the user
+// database can be slow.
+type pidsUserCache struct {
+	mtx   sync.Mutex
+	names map[string]string
+}
+
+func (c *pidsUserCache) lookup(uid string) string {
+	if uid == "" {
+		return ""
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if name, ok := c.names[uid]; ok {
+		return name
+	}
+	if c.names == nil {
+		c.names = map[string]string{}
+	}
+	name := ""
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	c.names[uid] = name
+	return name
+}
@@ -39,6 +39,7 @@ type processCollector struct {
 	procsState   *prometheus.Desc
 	pidUsed      *prometheus.Desc
 	pidMax       *prometheus.Desc
+	users        *processUsers
 	logger       log.Logger
 }
 
@@ -53,8 +54,13 @@ func NewProcessStatCollector(logger log.Logger) (Collector, error) {
 		return nil, fmt.Errorf("failed to open procfs: %w", err)
 	}
 	subsystem := "processes"
+	var users *processUsers
+	if *processesByUser {
+		users = newProcessUsers(subsystem, *processesBySession)
+	}
 	return &processCollector{
-		fs: fs,
+		fs:    fs,
+		users: users,
 		threadAlloc: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, subsystem, "threads"),
 			"Allocated threads in system",
@@ -112,6 +118,9 @@ func (c *processCollector) Update(ch chan<- prometheus.Metric) error {
 	ch <- prometheus.MustNewConstMetric(c.pidUsed, prometheus.GaugeValue, float64(pids))
 	ch <- prometheus.MustNewConstMetric(c.pidMax, prometheus.GaugeValue, float64(pidM))
 
+	if c.users != nil {
+		c.users.update(ch)
+	}
 	return nil
 }
 
@@ -124,6 +133,7 @@ func (c *processCollector) getAllocatedThreads() (int, map[string]int32, int, ma
 	thread := 0
 	procStates := make(map[string]int32)
 	threadStates := make(map[string]int32)
+	var samples []pidsProcSample
 
 	for _, pid := range p {
 		stat, err := pid.Stat()
@@ -143,6 +153,17 @@ func (c *processCollector) getAllocatedThreads() (int, map[string]int32, int, ma
 		if err != nil {
 			return 0, nil, 0, nil, err
 		}
+		if c.users != nil {
+			sample, err := c.users.sample(pid, stat)
+			if err != nil {
+				level.Debug(c.logger).Log("msg", "error reading status for pid", "pid", pid.PID, "err", err)
+				continue
+			}
+			samples = append(samples, sample)
+		}
+	}
+	if c.users != nil {
+		c.users.observe(samples)
 	}
 	return pids, procStates, thread, threadStates, nil
 }
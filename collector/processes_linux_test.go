@@ -17,6 +17,8 @@
 package collector
 
 import (
+	"os"
+	"reflect"
 	"testing"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -53,3 +55,42 @@ func TestReadProcessStatus(t *testing.T) {
 		t.Fatalf("Total running pids cannot be greater than %d or equals to 0", maxPid)
 	}
 }
+
+func TestProcessUsers(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc"}); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := processCollector{fs: fs, users: newProcessUsers("processes", true), logger: log.NewNopLogger()}
+	if _, _, _, _, err := c.getAllocatedThreads(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only pid 1 has a status file in the fixtures.
+	if want, got := 1, len(c.users.totals); want != got {
+		t.Fatalf("want %d users, got %d", want, got)
+	}
+	for group, total := range c.users.totals {
+		if want, got := []string{"0", c.users.users.lookup("0"), "3"}, c.users.labels[group]; !reflect.DeepEqual(want, got) {
+			t.Errorf("want labels %v, got %v", want, got)
+		}
+		if want, got := 1.0, total.processes; want != got {
+			t.Errorf("want %v processes, got %v", want, got)
+		}
+		if want, got := 0.98, total.systemSeconds; want != got {
+			t.Errorf("want %v system seconds, got %v", want, got)
+		}
+		if want, got := float64(2507*os.Getpagesize()), total.rssBytes; want != got {
+			t.Errorf("want %v rss bytes, got %v", want, got)
+		}
+	}
+
+	// A session without processes is dropped.
+	c.users.observe(nil)
+	if want, got := 0, len(c.users.totals); want != got {
+		t.Errorf("want %d users after all processes exited, got %d", want, got)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noprocesses
+// +build !noprocesses
+
+package collector
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+)
+
+var (
+	processesByUser    = kingpin.Flag("collector.processes.by-user", "Aggregate CPU, memory, threads, file descriptors and I/O of all processes by user.").Default("false").Bool()
+	processesBySession = kingpin.Flag("collector.processes.by-session", "Split the per-user aggregation by logind session.").Default("false").Bool()
+	// logind places the processes of a session into session-<id>.scope.
+	processesSessionPattern = regexp.MustCompile(`/session-([^/]+)\.scope`)
+)
+
+// processUsers aggregates the resource usage of all processes by user.
+type processUsers struct {
+	bySession bool
+	users     pidsUserCache
+
+	cpuSeconds *prometheus.Desc
+	readBytes  *prometheus.Desc
+	writeBytes *prometheus.Desc
+	processes  *prometheus.Desc
+	rssBytes   *prometheus.Desc
+	threads    *prometheus.Desc
+	fds        *prometheus.Desc
+
+	mtx    sync.Mutex
+	procs  map[pidsProcKey]pidsProcSample
+	totals map[string]*pidsGroupStats
+	// labels holds the label values of each group of totals.
+	labels map[string][]string
+}
+
+func newProcessUsers(subsystem string, bySession bool) *processUsers {
+	labels := []string{"uid", "user"}
+	if bySession {
+		labels = append(labels, "session")
+	}
+	desc := func(name, help string, extra ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help,
+			append(append([]string{}, labels...), extra...), nil)
+	}
+	return &processUsers{
+		bySession:  bySession,
+		cpuSeconds: desc("user_cpu_seconds_total", "CPU time consumed by the processes of the user.", "mode"),
+		readBytes:  desc("user_read_bytes_total", "Bytes read from storage by the processes of the user."),
+		writeBytes: desc("user_write_bytes_total", "Bytes written to storage by the processes of the user."),
+		processes:  desc("user_processes", "Number of processes of the user."),
+		rssBytes:   desc("user_memory_rss_bytes", "Resident set size of the processes of the user."),
+		threads:    desc("user_threads", "Number of threads of the processes of the user."),
+		fds:        desc("user_open_fds", "Number of open file descriptors of the processes of the user."),
+		procs:      map[pidsProcKey]pidsProcSample{},
+		totals:     map[string]*pidsGroupStats{},
+		labels:     map[string][]string{},
+	}
+}
+
+// sample reads the accounting of a process, keyed by its real user and
+// session.
+func (u *processUsers) sample(p procfs.Proc, stat procfs.ProcStat) (pidsProcSample, error) {
+	status, err := p.NewStatus()
+	if err != nil {
+		return pidsProcSample{}, err
+	}
+	uid := status.UIDs[0]
+	values := []string{uid, u.users.lookup(uid)}
+	if u.bySession {
+		session := ""
+		if cgroups, err := p.Cgroups(); err == nil {
+			for _, cg := range cgroups {
+				if m := processesSessionPattern.FindStringSubmatch(cg.Path); m != nil {
+					session = m[1]
+				}
+			}
+		}
+		values = append(values, session)
+	}
+
+	sample := readPidsProcSample(p, stat, status)
+	sample.group = strings.Join(values, "\x00")
+	u.mtx.Lock()
+	u.labels[sample.group] = values
+	u.mtx.Unlock()
+	return sample, nil
+}
+
+// observe folds the samples of a full walk of /proc into the totals.
+func (u *processUsers) observe(samples []pidsProcSample) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.procs = observePidsSamples(u.procs, u.totals, samples)
+	if !u.bySession {
+		return
+	}
+	// Sessions come and go, forget them once their last process is gone.
+	for group, t := range u.totals {
+		if t.processes == 0 {
+			delete(u.totals, group)
+			delete(u.labels, group)
+		}
+	}
+}
+
+func (u *processUsers) update(ch chan<- prometheus.Metric) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	for group, t := range u.totals {
+		labels := u.labels[group]
+		ch <- prometheus.MustNewConstMetric(u.cpuSeconds, prometheus.CounterValue, t.userSeconds, append(labels, "user")...)
+		ch <- prometheus.MustNewConstMetric(u.cpuSeconds, prometheus.CounterValue, t.systemSeconds, append(labels, "system")...)
+		ch <- prometheus.MustNewConstMetric(u.readBytes, prometheus.CounterValue, t.readBytes, labels...)
+		ch <- prometheus.MustNewConstMetric(u.writeBytes, prometheus.CounterValue, t.writeBytes, labels...)
+		ch <- prometheus.MustNewConstMetric(u.processes, prometheus.GaugeValue, t.processes, labels...)
+		ch <- prometheus.MustNewConstMetric(u.rssBytes, prometheus.GaugeValue, t.rssBytes, labels...)
+		ch <- prometheus.MustNewConstMetric(u.threads, prometheus.GaugeValue, t.threads, labels...)
+		ch <- prometheus.MustNewConstMetric(u.fds, prometheus.GaugeValue, t.fds, labels...)
+	}
+}
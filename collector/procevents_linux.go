@@ -0,0 +1,421 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noprocevents
+// +build !noprocevents
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/josharian/native"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	procEventsSubsystem = "procevents"
+
+	// From linux/connector.h and linux/cn_proc.h.
+	cnIdxProc           = 0x1
+	cnValProc           = 0x1
+	procCnMcastListen   = 0x1
+	procEventFork       = 0x1
+	procEventExec       = 0x2
+	procEventComm       = 0x200
+	procEventExit       = 0x80000000
+	procEventsCnMsgSize = 20
+	procEventsHdrSize   = 16
+
+	// procEventsOtherComm replaces comms beyond the maximum number tracked.
+	procEventsOtherComm = "other"
+)
+
+var (
+	procEventsMaxComms       = kingpin.Flag("collector.procevents.max-comms", "Maximum number of distinct comm label values, further processes are counted as \"other\".").Default("256").Int()
+	procEventsRecentCrashes  = kingpin.Flag("collector.procevents.recent-crashes", "Number of recently crashed processes to report.").Default("10").Int()
+	procEventsCrashRetention = kingpin.Flag("collector.procevents.recent-crashes.retention", "How long a crashed process is reported.").Default("15m").Duration()
+)
+
+// procEventsCrashSignals are the signals whose default action is to dump
+// core, i.e. the process crashed rather than being told to terminate.
+var procEventsCrashSignals = map[syscall.Signal]bool{
+	unix.SIGQUIT: true,
+	unix.SIGILL:  true,
+	unix.SIGTRAP: true,
+	unix.SIGABRT: true,
+	unix.SIGBUS:  true,
+	unix.SIGFPE:  true,
+	unix.SIGSEGV: true,
+	unix.SIGXCPU: true,
+	unix.SIGXFSZ: true,
+	unix.SIGSYS:  true,
+}
+
+// procEvent is a fork, exec, comm change or exit reported by the proc
+// connector.
+type procEvent struct {
+	what uint32
+	// pid and tgid are those of the child for fork events.
+	pid        uint32
+	tgid       uint32
+	parentTgid uint32
+	exitCode   uint32
+	comm       string
+}
+
+type procEventsKey struct {
+	comm string
+	code string
+}
+
+type procEventsCrash struct {
+	pid        uint32
+	comm       string
+	signal     string
+	coreDumped bool
+	time       time.Time
+}
+
+type procEventsCollector struct {
+	conn   *netlink.Conn
+	done   chan struct{}
+	logger log.Logger
+
+	maxComms       int
+	recentCrashes  int
+	crashRetention time.Duration
+
+	forks       *prometheus.Desc
+	execs       *prometheus.Desc
+	exits       *prometheus.Desc
+	exitCodes   *prometheus.Desc
+	signals     *prometheus.Desc
+	recentCrash *prometheus.Desc
+	overruns    *prometheus.Desc
+
+	mtx sync.Mutex
+	// comms of the live processes, tracked from their fork, exec and comm
+	// events because the exit event comes after /proc/<pid> may be gone.
+	procs      map[uint32]string
+	knownComms map[string]bool
+	forkCount  map[string]float64
+	execCount  map[string]float64
+	exitCount  map[string]float64
+	codeCount  map[procEventsKey]float64
+	sigCount   map[procEventsKey]float64
+	crashes    []procEventsCrash
+	overrun    float64
+	now        func() time.Time
+}
+
+func init() {
+	registerCollector(procEventsSubsystem, defaultDisabled, NewProcEventsCollector)
+}
+
+// NewProcEventsCollector returns a new Collector counting process lifecycle
+// events from the kernel proc connector. It needs CAP_NET_ADMIN.
+func NewProcEventsCollector(logger log.Logger) (Collector, error) {
+	conn, err := netlink.Dial(unix.NETLINK_CONNECTOR, &netlink.Config{Groups: cnIdxProc})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open proc connector: %w", err)
+	}
+	if _, err := conn.Send(netlink.Message{
+		Header: netlink.Header{Type: netlink.Done},
+		Data:   procEventsListenMessage(),
+	}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to subscribe to process events: %w", err)
+	}
+
+	c := newProcEventsCollector(logger)
+	c.conn = conn
+	go c.run()
+	return c, nil
+}
+
+func newProcEventsCollector(logger log.Logger) *procEventsCollector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, procEventsSubsystem, name), help, labels, nil)
+	}
+	return &procEventsCollector{
+		done:           make(chan struct{}),
+		logger:         logger,
+		maxComms:       *procEventsMaxComms,
+		recentCrashes:  *procEventsRecentCrashes,
+		crashRetention: *procEventsCrashRetention,
+		forks:          desc("forks_total", "Processes created, by comm of the parent.", "comm"),
+		execs:          desc("execs_total", "Programs executed, by comm of the new program.", "comm"),
+		exits:          desc("exits_total", "Processes exited.", "comm"),
+		exitCodes:      desc("exit_codes_total", "Processes exited with a non-zero exit code.", "comm", "code"),
+		signals:        desc("signal_deaths_total", "Processes killed by a signal.", "comm", "signal"),
+		recentCrash:    desc("recent_crash_info", "Processes recently killed by a signal that dumps core.", "pid", "comm", "signal", "core_dumped"),
+		overruns:       desc("overruns_total", "Times the kernel dropped process events because the exporter fell behind."),
+		procs:          map[uint32]string{},
+		knownComms:     map[string]bool{},
+		forkCount:      map[string]float64{},
+		execCount:      map[string]float64{},
+		exitCount:      map[string]float64{},
+		codeCount:      map[procEventsKey]float64{},
+		sigCount:       map[procEventsKey]float64{},
+		now:            time.Now,
+	}
+}
+
+// procEventsListenMessage is the cn_msg subscribing to process events.
+func procEventsListenMessage() []byte {
+	b := make([]byte, procEventsCnMsgSize+4)
+	native.Endian.PutUint32(b[0:4], cnIdxProc)
+	native.Endian.PutUint32(b[4:8], cnValProc)
+	native.Endian.PutUint16(b[16:18], 4)
+	native.Endian.PutUint32(b[20:24], procCnMcastListen)
+	return b
+}
+
+// Close unsubscribes from the process events and stops receiving them.
+func (c *procEventsCollector) Close() error {
+	close(c.done)
+	return c.conn.Close()
+}
+
+func (c *procEventsCollector) run() {
+	for {
+		msgs, err := c.conn.Receive()
+		if err != nil {
+			if errors.Is(err, unix.ENOBUFS) {
+				c.mtx.Lock()
+				c.overrun++
+				c.mtx.Unlock()
+				c.pruneProcs()
+				continue
+			}
+			select {
+			case <-c.done:
+				return
+			default:
+			}
+			level.Error(c.logger).Log("msg", "failed to receive process events, stopping", "err", err)
+			return
+		}
+		for _, m := range msgs {
+			if ev, ok := parseProcEvent(m.Data); ok {
+				c.handle(ev)
+			}
+		}
+	}
+}
+
+// parseProcEvent parses a cn_msg carrying a struct proc_event.
+func parseProcEvent(b []byte) (procEvent, bool) {
+	if len(b) < procEventsCnMsgSize+procEventsHdrSize ||
+		native.Endian.Uint32(b[0:4]) != cnIdxProc || native.Endian.Uint32(b[4:8]) != cnValProc {
+		return procEvent{}, false
+	}
+	b = b[procEventsCnMsgSize:]
+	ev := procEvent{what: native.Endian.Uint32(b[0:4])}
+	data := b[procEventsHdrSize:]
+	u32 := func(i int) uint32 { return native.Endian.Uint32(data[i*4:]) }
+	switch ev.what {
+	case procEventFork:
+		if len(data) < 16 {
+			return procEvent{}, false
+		}
+		ev.parentTgid, ev.pid, ev.tgid = u32(1), u32(2), u32(3)
+	case procEventExec:
+		if len(data) < 8 {
+			return procEvent{}, false
+		}
+		ev.pid, ev.tgid = u32(0), u32(1)
+	case procEventComm:
+		if len(data) < 24 {
+			return procEvent{}, false
+		}
+		ev.pid, ev.tgid = u32(0), u32(1)
+		comm := data[8:24]
+		if i := strings.IndexByte(string(comm), 0); i >= 0 {
+			comm = comm[:i]
+		}
+		ev.comm = string(comm)
+	case procEventExit:
+		if len(data) < 12 {
+			return procEvent{}, false
+		}
+		ev.pid, ev.tgid, ev.exitCode = u32(0), u32(1), u32(2)
+	default:
+		return procEvent{}, false
+	}
+	return ev, true
+}
+
+func (c *procEventsCollector) handle(ev procEvent) {
+	// Thread creation and exits are reported too, only count processes.
+	if ev.pid != ev.tgid {
+		return
+	}
+	// Read /proc outside of the lock, exec and comm events are rare enough
+	// compared to the lookup cost.
+	comm := ev.comm
+	if ev.what == procEventExec {
+		comm = readProcEventsComm(ev.pid)
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	switch ev.what {
+	case procEventFork:
+		parent, ok := c.procs[ev.parentTgid]
+		if !ok {
+			parent = readProcEventsComm(ev.parentTgid)
+		}
+		c.procs[ev.pid] = parent
+		c.forkCount[c.label(parent)]++
+	case procEventExec:
+		// Short lived programs may be gone before we read their comm, keep
+		// the one inherited from the parent then.
+		if comm == "" {
+			comm = c.procs[ev.pid]
+		}
+		c.procs[ev.pid] = comm
+		c.execCount[c.label(comm)]++
+	case procEventComm:
+		c.procs[ev.pid] = comm
+	case procEventExit:
+		comm, ok := c.procs[ev.pid]
+		if !ok {
+			comm = readProcEventsComm(ev.pid)
+		}
+		delete(c.procs, ev.pid)
+		c.exit(ev.pid, c.label(comm), ev.exitCode)
+	}
+}
+
+// pruneProcs forgets the processes that are gone. After an overrun their exit
+// events may have been lost, and they would otherwise be tracked forever. It
+// runs on the receiving goroutine, so no events are handled meanwhile.
+func (c *procEventsCollector) pruneProcs() {
+	c.mtx.Lock()
+	pids := make([]uint32, 0, len(c.procs))
+	for pid := range c.procs {
+		pids = append(pids, pid)
+	}
+	c.mtx.Unlock()
+
+	var gone []uint32
+	for _, pid := range pids {
+		if _, err := os.Stat(procFilePath(strconv.FormatUint(uint64(pid), 10))); errors.Is(err, os.ErrNotExist) {
+			gone = append(gone, pid)
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, pid := range gone {
+		delete(c.procs, pid)
+	}
+}
+
+// exit accounts an exit with the given wait(2) status.
+func (c *procEventsCollector) exit(pid uint32, comm string, status uint32) {
+	c.exitCount[comm]++
+	ws := unix.WaitStatus(status)
+	switch {
+	case ws.Signaled():
+		sig := ws.Signal()
+		name := unix.SignalName(sig)
+		if name == "" {
+			name = strconv.Itoa(int(sig))
+		}
+		c.sigCount[procEventsKey{comm: comm, code: name}]++
+		if procEventsCrashSignals[sig] || ws.CoreDump() {
+			c.crashes = append(c.crashes, procEventsCrash{
+				pid: pid, comm: comm, signal: name, coreDumped: ws.CoreDump(), time: c.now(),
+			})
+			if n := c.recentCrashes; len(c.crashes) > n {
+				c.crashes = c.crashes[len(c.crashes)-n:]
+			}
+		}
+	case ws.ExitStatus() != 0:
+		c.codeCount[procEventsKey{comm: comm, code: strconv.Itoa(ws.ExitStatus())}]++
+	}
+}
+
+// label bounds the number of distinct comm label values.
+func (c *procEventsCollector) label(comm string) string {
+	if c.knownComms[comm] {
+		return comm
+	}
+	if len(c.knownComms) >= c.maxComms {
+		return procEventsOtherComm
+	}
+	c.knownComms[comm] = true
+	return comm
+}
+
+func readProcEventsComm(pid uint32) string {
+	comm, err := os.ReadFile(procFilePath(strconv.FormatUint(uint64(pid), 10) + "/comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+func (c *procEventsCollector) Update(ch chan<- prometheus.Metric) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for comm, v := range c.forkCount {
+		ch <- prometheus.MustNewConstMetric(c.forks, prometheus.CounterValue, v, comm)
+	}
+	for comm, v := range c.execCount {
+		ch <- prometheus.MustNewConstMetric(c.execs, prometheus.CounterValue, v, comm)
+	}
+	for comm, v := range c.exitCount {
+		ch <- prometheus.MustNewConstMetric(c.exits, prometheus.CounterValue, v, comm)
+	}
+	for k, v := range c.codeCount {
+		ch <- prometheus.MustNewConstMetric(c.exitCodes, prometheus.CounterValue, v, k.comm, k.code)
+	}
+	for k, v := range c.sigCount {
+		ch <- prometheus.MustNewConstMetric(c.signals, prometheus.CounterValue, v, k.comm, k.code)
+	}
+	ch <- prometheus.MustNewConstMetric(c.overruns, prometheus.CounterValue, c.overrun)
+
+	cutoff := c.now().Add(-c.crashRetention)
+	i := sort.Search(len(c.crashes), func(i int) bool { return c.crashes[i].time.After(cutoff) })
+	c.crashes = c.crashes[i:]
+	seen := map[procEventsCrash]bool{}
+	for _, crash := range c.crashes {
+		// A reused pid can crash the same way twice.
+		crash.time = time.Time{}
+		if seen[crash] {
+			continue
+		}
+		seen[crash] = true
+		ch <- prometheus.MustNewConstMetric(c.recentCrash, prometheus.GaugeValue, 1,
+			strconv.FormatUint(uint64(crash.pid), 10), crash.comm, crash.signal, strconv.FormatBool(crash.coreDumped))
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !noprocevents
+// +build !noprocevents
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/josharian/native"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func procEventMessage(what uint32, data ...uint32) []byte {
+	b := make([]byte, procEventsCnMsgSize+procEventsHdrSize+4*len(data))
+	native.Endian.PutUint32(b[0:], cnIdxProc)
+	native.Endian.PutUint32(b[4:], cnValProc)
+	native.Endian.PutUint32(b[procEventsCnMsgSize:], what)
+	for i, v := range data {
+		native.Endian.PutUint32(b[procEventsCnMsgSize+procEventsHdrSize+4*i:], v)
+	}
+	return b
+}
+
+func TestParseProcEvent(t *testing.T) {
+	comm := procEventMessage(procEventComm, 42, 42, 0, 0, 0, 0)
+	copy(comm[procEventsCnMsgSize+procEventsHdrSize+8:], "worker\x00")
+
+	for name, tc := range map[string]struct {
+		msg  []byte
+		ok   bool
+		want procEvent
+	}{
+		"fork":      {procEventMessage(procEventFork, 1, 1, 42, 42), true, procEvent{what: procEventFork, parentTgid: 1, pid: 42, tgid: 42}},
+		"exec":      {procEventMessage(procEventExec, 42, 42), true, procEvent{what: procEventExec, pid: 42, tgid: 42}},
+		"comm":      {comm, true, procEvent{what: procEventComm, pid: 42, tgid: 42, comm: "worker"}},
+		"exit":      {procEventMessage(procEventExit, 42, 42, 0x8b, 17), true, procEvent{what: procEventExit, pid: 42, tgid: 42, exitCode: 0x8b}},
+		"truncated": {procEventMessage(procEventExit, 42, 42), false, procEvent{}},
+		"uid":       {procEventMessage(0x4, 42, 42, 0, 0), false, procEvent{}},
+		"empty":     {nil, false, procEvent{}},
+	} {
+		got, ok := parseProcEvent(tc.msg)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("%s: want %+v (%v), got %+v (%v)", name, tc.want, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestProcEventsAccounting(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{
+		"--path.procfs", "fixtures/proc",
+		"--collector.procevents.max-comms", "3",
+		"--collector.procevents.recent-crashes", "2",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	c := newProcEventsCollector(log.NewNopLogger())
+	c.now = func() time.Time { return now }
+
+	for _, ev := range []procEvent{
+		// pid 1 is systemd in the fixtures.
+		{what: procEventFork, parentTgid: 1, pid: 100, tgid: 100},
+		{what: procEventExec, pid: 100, tgid: 100},
+		{what: procEventComm, pid: 100, tgid: 100, comm: "helper"},
+		// A thread of pid 100 is not a process.
+		{what: procEventFork, parentTgid: 100, pid: 101, tgid: 100},
+		{what: procEventExit, pid: 101, tgid: 100},
+		// exit(3)
+		{what: procEventExit, pid: 100, tgid: 100, exitCode: 3 << 8},
+		{what: procEventFork, parentTgid: 1, pid: 200, tgid: 200},
+		{what: procEventComm, pid: 200, tgid: 200, comm: "crashy"},
+		// SIGSEGV with core dump.
+		{what: procEventExit, pid: 200, tgid: 200, exitCode: 0x80 | 11},
+		{what: procEventFork, parentTgid: 1, pid: 300, tgid: 300},
+		{what: procEventComm, pid: 300, tgid: 300, comm: "victim"},
+		// SIGKILL is not a crash.
+		{what: procEventExit, pid: 300, tgid: 300, exitCode: 9},
+	} {
+		c.handle(ev)
+	}
+
+	if want, got := 3.0, c.forkCount["systemd"]; want != got {
+		t.Errorf("want %v forks by systemd, got %v", want, got)
+	}
+	if want, got := 1.0, c.exitCount["helper"]; want != got {
+		t.Errorf("want %v exits of helper, got %v", want, got)
+	}
+	if want, got := 1.0, c.codeCount[procEventsKey{comm: "helper", code: "3"}]; want != got {
+		t.Errorf("want %v exit code 3 of helper, got %v", want, got)
+	}
+	if want, got := 1.0, c.sigCount[procEventsKey{comm: "crashy", code: "SIGSEGV"}]; want != got {
+		t.Errorf("want %v SIGSEGV deaths of crashy, got %v", want, got)
+	}
+	// Only three comms are tracked, the fourth one is "other".
+	if want, got := 1.0, c.sigCount[procEventsKey{comm: procEventsOtherComm, code: "SIGKILL"}]; want != got {
+		t.Errorf("want %v SIGKILL deaths of other, got %v (%v)", want, got, c.sigCount)
+	}
+	if len(c.procs) != 0 {
+		t.Errorf("want no live processes left, got %v", c.procs)
+	}
+	if want, got := 1, len(c.crashes); want != got {
+		t.Fatalf("want %d crash, got %+v", want, c.crashes)
+	}
+	if crash := c.crashes[0]; crash.pid != 200 || crash.signal != "SIGSEGV" || !crash.coreDumped {
+		t.Errorf("unexpected crash %+v", crash)
+	}
+
+	// Crashes are bounded in number and age.
+	for pid := uint32(400); pid < 403; pid++ {
+		c.handle(procEvent{what: procEventExit, pid: pid, tgid: pid, exitCode: 6})
+	}
+	if want, got := 2, len(c.crashes); want != got {
+		t.Errorf("want %d crashes, got %d", want, got)
+	}
+	now = now.Add(time.Hour)
+	ch := make(chan prometheus.Metric, 100)
+	if err := c.Update(ch); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.crashes) != 0 {
+		t.Errorf("want expired crashes to be dropped, got %+v", c.crashes)
+	}
+}
+
+func TestProcEventsPruneProcs(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{"--path.procfs", "fixtures/proc"}); err != nil {
+		t.Fatal(err)
+	}
+	c := newProcEventsCollector(log.NewNopLogger())
+	// The exit of pid 100 was lost in an overrun, pids 1 and 10 are in the
+	// fixtures.
+	c.procs = map[uint32]string{1: "systemd", 10: "snmpd", 100: "helper"}
+	c.pruneProcs()
+	if want, got := map[uint32]string{1: "systemd", 10: "snmpd"}, c.procs; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -38,6 +38,7 @@ type qdiscStatCollector struct {
 	overlimits   typedDesc
 	qlength      typedDesc
 	backlog      typedDesc
+	fixtures     string
 }
 
 var (
@@ -92,6 +93,7 @@ func NewQdiscStatCollector(logger log.Logger) (Collector, error) {
 			"Number of bytes currently in queue to be sent.",
 			[]string{"device", "kind"}, nil,
 		), prometheus.GaugeValue},
+		fixtures:     *collectorQdisc,
 		logger:       logger,
 		deviceFilter: newDeviceFilter(*collectorQdiskDeviceExclude, *collectorQdiskDeviceExclude),
 	}, nil
@@ -113,7 +115,7 @@ func (c *qdiscStatCollector) Update(ch chan<- prometheus.Metric) error {
 	var msgs []qdisc.QdiscInfo
 	var err error
 
-	fixtures := *collectorQdisc
+	fixtures := c.fixtures
 
 	if fixtures == "" {
 		msgs, err = qdisc.Get()
@@ -31,6 +31,7 @@ type runitCollector struct {
 	stateDesired   typedDesc
 	stateNormal    typedDesc
 	stateTimestamp typedDesc
+	serviceDir     string
 	logger         log.Logger
 }
 
@@ -69,12 +70,13 @@ func NewRunitCollector(logger log.Logger) (Collector, error) {
 			"Unix timestamp of the last runit service state change.",
 			labelNames, constLabels,
 		), prometheus.GaugeValue},
-		logger: logger,
+		serviceDir: *runitServiceDir,
+		logger:     logger,
 	}, nil
 }
 
 func (c *runitCollector) Update(ch chan<- prometheus.Metric) error {
-	services, err := runit.GetServices(*runitServiceDir)
+	services, err := runit.GetServices(c.serviceDir)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,254 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosocket_states
+// +build !nosocket_states
+
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const socketStatesSubsystem = "socket_states"
+
+// socketStatesCollector counts the sockets of each protocol, family and state
+// through sock_diag netlink dumps, falling back to /proc/net for protocols the
+// kernel can't dump, e.g. without the udp_diag or raw_diag modules.
+type socketStatesCollector struct {
+	logger log.Logger
+
+	sockets    *prometheus.Desc
+	rxBytes    *prometheus.Desc
+	txBytes    *prometheus.Desc
+	rxNonEmpty *prometheus.Desc
+}
+
+// socketProtocol is a protocol dumped through sock_diag. procFile is the
+// /proc/net table of the IPv4 sockets, the IPv6 one has a 6 suffix.
+type socketProtocol struct {
+	name     string
+	protocol uint8
+	procFile string
+}
+
+var socketProtocols = []socketProtocol{
+	{"tcp", syscall.IPPROTO_TCP, "tcp"},
+	{"udp", syscall.IPPROTO_UDP, "udp"},
+	{"raw", syscall.IPPROTO_RAW, "raw"},
+	// SCTP associations have no /proc/net table in the same format.
+	{"sctp", syscall.IPPROTO_SCTP, ""},
+}
+
+var socketFamilies = []struct {
+	name   string
+	family uint8
+	suffix string
+}{
+	{"ipv4", syscall.AF_INET, ""},
+	{"ipv6", syscall.AF_INET6, "6"},
+}
+
+type socketFamily struct {
+	protocol string
+	family   string
+}
+
+type socketStateKey struct {
+	socketFamily
+	state tcpConnectionState
+}
+
+type socketQueueTotals struct {
+	rxBytes    float64
+	txBytes    float64
+	rxNonEmpty float64
+}
+
+// socketStates is the number of sockets per state and their queue totals.
+type socketStates struct {
+	sockets map[socketStateKey]float64
+	queues  map[socketFamily]*socketQueueTotals
+}
+
+func newSocketStates() *socketStates {
+	return &socketStates{
+		sockets: map[socketStateKey]float64{},
+		queues:  map[socketFamily]*socketQueueTotals{},
+	}
+}
+
+func (s *socketStates) add(f socketFamily, state tcpConnectionState, rx, tx uint64) {
+	s.sockets[socketStateKey{f, state}]++
+	q, ok := s.queues[f]
+	if !ok {
+		q = &socketQueueTotals{}
+		s.queues[f] = q
+	}
+	// The receive queue of a listening socket is its accept queue, not bytes.
+	if f.protocol == "tcp" && state == tcpListen {
+		return
+	}
+	q.rxBytes += float64(rx)
+	q.txBytes += float64(tx)
+	if rx > 0 {
+		q.rxNonEmpty++
+	}
+}
+
+func init() {
+	registerCollector(socketStatesSubsystem, defaultDisabled, NewSocketStatesCollector)
+}
+
+// NewSocketStatesCollector returns a new Collector exposing the number of
+// sockets per protocol, family and state.
+func NewSocketStatesCollector(logger log.Logger) (Collector, error) {
+	labels := []string{"protocol", "family"}
+	return &socketStatesCollector{
+		logger: logger,
+		sockets: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, socketStatesSubsystem, "sockets"),
+			"Number of sockets in each state. UDP and raw sockets are established when connected and close otherwise.",
+			append(labels, "state"), nil,
+		),
+		rxBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, socketStatesSubsystem, "receive_queue_bytes"),
+			"Bytes queued for reading by the application, excluding the accept queue of listening sockets.",
+			labels, nil,
+		),
+		txBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, socketStatesSubsystem, "send_queue_bytes"),
+			"Bytes queued for sending, excluding listening sockets.",
+			labels, nil,
+		),
+		rxNonEmpty: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, socketStatesSubsystem, "receive_queue_nonempty_sockets"),
+			"Number of sockets with a non-empty receive queue, excluding listening sockets.",
+			labels, nil,
+		),
+	}, nil
+}
+
+func (c *socketStatesCollector) Update(ch chan<- prometheus.Metric) error {
+	states := newSocketStates()
+
+	conn, err := netlink.Dial(syscall.NETLINK_INET_DIAG, nil)
+	if err != nil {
+		level.Debug(c.logger).Log("msg", "sock_diag unavailable, falling back to /proc/net", "err", err)
+	} else {
+		defer conn.Close()
+	}
+
+	for _, p := range socketProtocols {
+		for _, f := range socketFamilies {
+			family := socketFamily{protocol: p.name, family: f.name}
+			if conn != nil {
+				msgs, err := inetDiagDump(conn, f.family, p.protocol, 0, inetDiagAllStates)
+				if err == nil {
+					if err := parseSocketStates(msgs, family, states); err != nil {
+						return fmt.Errorf("couldn't parse %s %s sockets: %w", f.name, p.name, err)
+					}
+					continue
+				}
+				level.Debug(c.logger).Log("msg", "couldn't dump sockets", "protocol", p.name, "family", f.name, "err", err)
+			}
+			if p.procFile == "" {
+				continue
+			}
+			err := readProcSocketStates(procFilePath("net/"+p.procFile+f.suffix), family, states)
+			if errors.Is(err, os.ErrNotExist) {
+				// The protocol or IPv6 isn't available.
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("couldn't read %s %s sockets: %w", f.name, p.name, err)
+			}
+		}
+	}
+
+	for key, n := range states.sockets {
+		ch <- prometheus.MustNewConstMetric(c.sockets, prometheus.GaugeValue, n, key.protocol, key.family, key.state.String())
+	}
+	for f, q := range states.queues {
+		ch <- prometheus.MustNewConstMetric(c.rxBytes, prometheus.GaugeValue, q.rxBytes, f.protocol, f.family)
+		ch <- prometheus.MustNewConstMetric(c.txBytes, prometheus.GaugeValue, q.txBytes, f.protocol, f.family)
+		ch <- prometheus.MustNewConstMetric(c.rxNonEmpty, prometheus.GaugeValue, q.rxNonEmpty, f.protocol, f.family)
+	}
+	return nil
+}
+
+func parseSocketStates(msgs []netlink.Message, f socketFamily, states *socketStates) error {
+	for _, m := range msgs {
+		if len(m.Data) < sizeOfDiagMsg {
+			return fmt.Errorf("short inet_diag_msg: %d bytes", len(m.Data))
+		}
+		msg := parseInetDiagMsg(m.Data)
+		states.add(f, tcpConnectionState(msg.State), uint64(msg.RQueue), uint64(msg.WQueue))
+	}
+	return nil
+}
+
+func readProcSocketStates(path string, f socketFamily, states *socketStates) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return parseProcSocketStates(file, f, states)
+}
+
+// parseProcSocketStates reads the state and queues of each socket in a
+// /proc/net/{tcp,udp,raw}{,6} table. Only the columns needed are parsed, the
+// tables of busy hosts are large.
+func parseProcSocketStates(r io.Reader, f socketFamily, states *socketStates) error {
+	scanner := bufio.NewScanner(r)
+	// Skip the header.
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return fmt.Errorf("invalid socket line %q", scanner.Text())
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid state in %q: %w", scanner.Text(), err)
+		}
+		tx, rx, ok := strings.Cut(fields[4], ":")
+		if !ok {
+			return fmt.Errorf("invalid queues in %q", scanner.Text())
+		}
+		txQueue, err := strconv.ParseUint(tx, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid tx_queue in %q: %w", scanner.Text(), err)
+		}
+		rxQueue, err := strconv.ParseUint(rx, 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid rx_queue in %q: %w", scanner.Text(), err)
+		}
+		states.add(f, tcpConnectionState(state), rxQueue, txQueue)
+	}
+	return scanner.Err()
+}
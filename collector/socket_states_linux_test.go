@@ -0,0 +1,88 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !nosocket_states
+// +build !nosocket_states
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"github.com/josharian/native"
+	"github.com/mdlayher/netlink"
+)
+
+func TestParseProcSocketStates(t *testing.T) {
+	states := newSocketStates()
+	tcp := socketFamily{"tcp", "ipv4"}
+	if err := readProcSocketStates("fixtures/proc/net/tcp", tcp, states); err != nil {
+		t.Fatal(err)
+	}
+	for state, want := range map[tcpConnectionState]float64{
+		tcpListen:      2,
+		tcpEstablished: 1,
+		tcpTimeWait:    1,
+	} {
+		if got := states.sockets[socketStateKey{tcp, state}]; want != got {
+			t.Errorf("want %v %s sockets, got %v", want, state, got)
+		}
+	}
+	want := socketQueueTotals{txBytes: 36}
+	if got := states.queues[tcp]; *got != want {
+		t.Errorf("want queues %+v, got %+v", want, *got)
+	}
+
+	udp := socketFamily{"udp", "ipv4"}
+	if err := readProcSocketStates("fixtures/proc/net/udp", udp, states); err != nil {
+		t.Fatal(err)
+	}
+	want = socketQueueTotals{txBytes: 21}
+	if got := states.queues[udp]; *got != want {
+		t.Errorf("want udp queues %+v, got %+v", want, *got)
+	}
+}
+
+func TestParseSocketStates(t *testing.T) {
+	encode := func(m InetDiagMsg) netlink.Message {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, native.Endian, m); err != nil {
+			t.Fatal(err)
+		}
+		return netlink.Message{Data: buf.Bytes()}
+	}
+	msgs := []netlink.Message{
+		encode(InetDiagMsg{Family: syscall.AF_INET6, State: uint8(tcpCloseWait), RQueue: 512}),
+		encode(InetDiagMsg{Family: syscall.AF_INET6, State: uint8(tcpCloseWait), WQueue: 20}),
+		encode(InetDiagMsg{Family: syscall.AF_INET6, State: uint8(tcpListen), RQueue: 3, WQueue: 128}),
+	}
+	states := newSocketStates()
+	tcp6 := socketFamily{"tcp", "ipv6"}
+	if err := parseSocketStates(msgs, tcp6, states); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2.0, states.sockets[socketStateKey{tcp6, tcpCloseWait}]; want != got {
+		t.Errorf("want %v close_wait sockets, got %v", want, got)
+	}
+	want := socketQueueTotals{rxBytes: 512, txBytes: 20, rxNonEmpty: 1}
+	if got := states.queues[tcp6]; *got != want {
+		t.Errorf("want queues %+v, got %+v", want, *got)
+	}
+
+	if err := parseSocketStates([]netlink.Message{{Data: []byte{1, 2}}}, tcp6, states); err == nil {
+		t.Error("want error for a short message")
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/procfs"
+)
+
+type tcpConnectionState int
+
+const (
+	// TCP_ESTABLISHED
+	tcpEstablished tcpConnectionState = iota + 1
+	// TCP_SYN_SENT
+	tcpSynSent
+	// TCP_SYN_RECV
+	tcpSynRecv
+	// TCP_FIN_WAIT1
+	tcpFinWait1
+	// TCP_FIN_WAIT2
+	tcpFinWait2
+	// TCP_TIME_WAIT
+	tcpTimeWait
+	// TCP_CLOSE
+	tcpClose
+	// TCP_CLOSE_WAIT
+	tcpCloseWait
+	// TCP_LAST_ACK
+	tcpLastAck
+	// TCP_LISTEN
+	tcpListen
+	// TCP_CLOSING
+	tcpClosing
+	// TCP_RX_BUFFER
+	tcpRxQueuedBytes
+	// TCP_TX_BUFFER
+	tcpTxQueuedBytes
+)
+
+func (st tcpConnectionState) String() string {
+	switch st {
+	case tcpEstablished:
+		return "established"
+	case tcpSynSent:
+		return "syn_sent"
+	case tcpSynRecv:
+		return "syn_recv"
+	case tcpFinWait1:
+		return "fin_wait1"
+	case tcpFinWait2:
+		return "fin_wait2"
+	case tcpTimeWait:
+		return "time_wait"
+	case tcpClose:
+		return "close"
+	case tcpCloseWait:
+		return "close_wait"
+	case tcpLastAck:
+		return "last_ack"
+	case tcpListen:
+		return "listen"
+	case tcpClosing:
+		return "closing"
+	case tcpRxQueuedBytes:
+		return "rx_queued_bytes"
+	case tcpTxQueuedBytes:
+		return "tx_queued_bytes"
+	default:
+		return "unknown"
+	}
+}
+
+// socketEntry is one TCP or UDP socket from /proc/net/{tcp,udp}{,6}.
+type socketEntry struct {
+	protocol   string
+	family     string
+	localAddr  net.IP
+	localPort  uint16
+	remoteAddr net.IP
+	remotePort uint16
+	// state is a tcpConnectionState, UDP sockets are either established
+	// (connected) or close (bound only).
+	state   tcpConnectionState
+	txQueue uint64
+	rxQueue uint64
+	uid     uint64
+	inode   uint64
+	// pid of the process holding the socket open, 0 if unknown.
+	pid int
+}
+
+// listening reports whether the socket accepts new peers, i.e. a listening
+// TCP socket or a bound but unconnected UDP socket.
+func (s *socketEntry) listening() bool {
+	if s.protocol == "udp" {
+		return s.remotePort == 0
+	}
+	return s.state == tcpListen
+}
+
+// socketTable is a snapshot of the TCP and UDP sockets in the network
+// namespace of the exporter. It replaces grepping /proc/net and listing
+// /proc/<pid>/fd from the shell and is shared by the collectors that need to
+// know which process owns a socket.
+type socketTable struct {
+	sockets []*socketEntry
+	byInode map[uint64]*socketEntry
+}
+
+// readSocketTable reads the socket table, resolving socket owners when
+// withOwners is set, which walks the fd directories of all processes.
+func readSocketTable(fs procfs.FS, withOwners bool) (*socketTable, error) {
+	t := &socketTable{byInode: map[uint64]*socketEntry{}}
+	for _, src := range []struct {
+		protocol string
+		family   string
+		read     func() (procfs.NetIPSocket, error)
+	}{
+		{"tcp", "ipv4", func() (procfs.NetIPSocket, error) {
+			s, err := fs.NetTCP()
+			return procfs.NetIPSocket(s), err
+		}},
+		{"tcp", "ipv6", func() (procfs.NetIPSocket, error) {
+			s, err := fs.NetTCP6()
+			return procfs.NetIPSocket(s), err
+		}},
+		{"udp", "ipv4", func() (procfs.NetIPSocket, error) {
+			s, err := fs.NetUDP()
+			return procfs.NetIPSocket(s), err
+		}},
+		{"udp", "ipv6", func() (procfs.NetIPSocket, error) {
+			s, err := fs.NetUDP6()
+			return procfs.NetIPSocket(s), err
+		}},
+	} {
+		lines, err := src.read()
+		if err != nil {
+			// The IPv6 tables are missing when IPv6 is disabled.
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("couldn't read %s %s sockets: %w", src.family, src.protocol, err)
+		}
+		for _, e := range socketEntries(lines) {
+			e.protocol, e.family = src.protocol, src.family
+			t.sockets = append(t.sockets, e)
+			if e.inode != 0 {
+				t.byInode[e.inode] = e
+			}
+		}
+	}
+
+	if withOwners {
+		owners, err := readSocketOwners(fs)
+		if err != nil {
+			return nil, err
+		}
+		for inode, pid := range owners {
+			if e, ok := t.byInode[inode]; ok {
+				e.pid = pid
+			}
+		}
+	}
+	return t, nil
+}
+
+func socketEntries(lines procfs.NetIPSocket) []*socketEntry {
+	entries := make([]*socketEntry, 0, len(lines))
+	for _, l := range lines {
+		entries = append(entries, &socketEntry{
+			localAddr:  l.LocalAddr,
+			localPort:  uint16(l.LocalPort),
+			remoteAddr: l.RemAddr,
+			remotePort: uint16(l.RemPort),
+			state:      tcpConnectionState(l.St),
+			txQueue:    l.TxQueue,
+			rxQueue:    l.RxQueue,
+			uid:        l.UID,
+			inode:      l.Inode,
+		})
+	}
+	return entries
+}
+
+// readSocketOwners maps socket inodes to the pid holding them open. Sockets
+// shared between processes, e.g. after fork, are attributed to one of them.
+func readSocketOwners(fs procfs.FS) (map[uint64]int, error) {
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list all processes: %w", err)
+	}
+	owners := map[uint64]int{}
+	for _, p := range procs {
+		targets, err := p.FileDescriptorTargets()
+		if err != nil {
+			// The process exited or we lack the permissions to look at it.
+			continue
+		}
+		for _, target := range targets {
+			if inode, ok := parseSocketInode(target); ok {
+				owners[inode] = p.PID
+			}
+		}
+	}
+	return owners, nil
+}
+
+// parseSocketInode parses the "socket:[12345]" target of a socket fd.
+func parseSocketInode(target string) (uint64, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(target[len("socket:["):len(target)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return inode, true
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/procfs"
+)
+
+func TestReadSocketTable(t *testing.T) {
+	fs, err := procfs.NewFS("fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := readSocketTable(fs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 6, len(table.sockets); want != got {
+		t.Fatalf("want %d sockets, got %d", want, got)
+	}
+	// The TIME_WAIT socket has no inode.
+	if want, got := 5, len(table.byInode); want != got {
+		t.Errorf("want %d sockets by inode, got %d", want, got)
+	}
+
+	s, ok := table.byInode[1002]
+	if !ok {
+		t.Fatal("socket 1002 not found")
+	}
+	want := socketEntry{
+		protocol:   "tcp",
+		family:     "ipv4",
+		localAddr:  net.ParseIP("10.0.0.1"),
+		localPort:  22,
+		remoteAddr: net.ParseIP("10.0.0.2"),
+		remotePort: 60000,
+		state:      tcpEstablished,
+		txQueue:    36,
+		inode:      1002,
+	}
+	if s.protocol != want.protocol || s.family != want.family ||
+		!s.localAddr.Equal(want.localAddr) || s.localPort != want.localPort ||
+		!s.remoteAddr.Equal(want.remoteAddr) || s.remotePort != want.remotePort ||
+		s.state != want.state || s.txQueue != want.txQueue || s.inode != want.inode {
+		t.Errorf("want socket %+v, got %+v", want, *s)
+	}
+
+	if s := table.byInode[3001]; s.family != "ipv6" || s.localPort != 9100 || s.uid != 65534 || !s.listening() {
+		t.Errorf("unexpected ipv6 socket %+v", *s)
+	}
+	if s := table.byInode[2740]; s.protocol != "udp" || s.localPort != 22 {
+		t.Errorf("unexpected udp socket %+v", *s)
+	}
+}
+
+func TestSocketListening(t *testing.T) {
+	for _, tc := range []struct {
+		socket socketEntry
+		want   bool
+	}{
+		{socketEntry{protocol: "tcp", state: tcpListen}, true},
+		{socketEntry{protocol: "tcp", state: tcpEstablished, remotePort: 443}, false},
+		{socketEntry{protocol: "udp", state: tcpClose}, true},
+		{socketEntry{protocol: "udp", state: tcpEstablished, remotePort: 53}, false},
+	} {
+		if got := tc.socket.listening(); got != tc.want {
+			t.Errorf("%+v: want listening %v, got %v", tc.socket, tc.want, got)
+		}
+	}
+}
+
+func TestParseSocketInode(t *testing.T) {
+	for target, want := range map[string]uint64{
+		"socket:[12345]":     12345,
+		"socket:[]":          0,
+		"socket:[12345":      0,
+		"pipe:[12345]":       0,
+		"anon_inode:[event]": 0,
+		"/dev/null":          0,
+	} {
+		got, ok := parseSocketInode(target)
+		if got != want || ok != (want != 0) {
+			t.Errorf("%q: want %d, got %d (%v)", target, want, got, ok)
+		}
+	}
+}
+
+func TestReadSocketOwners(t *testing.T) {
+	root := t.TempDir()
+	for pid, targets := range map[string][]string{
+		"1":   {"/dev/null", "socket:[1001]"},
+		"42":  {"socket:[1002]", "pipe:[7]", "socket:[3001]"},
+		"100": {},
+	} {
+		dir := filepath.Join(root, pid, "fd")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for i, target := range targets {
+			if err := os.Symlink(target, filepath.Join(dir, string(rune('0'+i)))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fs, err := procfs.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err := readSocketOwners(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint64]int{1001: 1, 1002: 42, 3001: 42}
+	if len(owners) != len(want) {
+		t.Errorf("want owners %v, got %v", want, owners)
+	}
+	for inode, pid := range want {
+		if owners[inode] != pid {
+			t.Errorf("want socket %d owned by %d, got %d", inode, pid, owners[inode])
+		}
+	}
+}
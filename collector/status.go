@@ -0,0 +1,98 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectorStatus is the outcome of the runs of a collector, by scrapes or in
+// the background.
+type CollectorStatus struct {
+	Name string `json:"name"`
+	// LastRun is the start of the last run, nil if the collector didn't
+	// run yet.
+	LastRun         *time.Time `json:"last_run,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	// Success is false if the last run failed. A run without data is
+	// successful.
+	Success             bool       `json:"success"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time `json:"last_error_time,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+var (
+	statusesMtx sync.Mutex
+	statuses    = map[string]*CollectorStatus{}
+)
+
+// recordRun updates the status of a collector after a run. Runs skipped
+// while an abandoned run is still running aren't recorded, the abandoned run
+// already counted as failed.
+func recordRun(name string, begin time.Time, duration time.Duration, err error) {
+	if errors.Is(err, errStillRunning) {
+		return
+	}
+	statusesMtx.Lock()
+	defer statusesMtx.Unlock()
+	s, ok := statuses[name]
+	if !ok {
+		s = &CollectorStatus{Name: name}
+		statuses[name] = s
+	}
+	s.LastRun, s.DurationSeconds = &begin, duration.Seconds()
+	s.Success = err == nil || IsNoDataError(err)
+	if s.Success {
+		s.ConsecutiveFailures = 0
+		return
+	}
+	s.LastError, s.LastErrorTime = err.Error(), &begin
+	s.ConsecutiveFailures++
+}
+
+// CollectorStatuses returns the status of the enabled collectors, sorted by
+// name. Collectors which didn't run yet are successful.
+func CollectorStatuses() []CollectorStatus {
+	initiatedCollectorsMtx.Lock()
+	var enabled []string
+	for name, state := range collectorState {
+		if *state {
+			enabled = append(enabled, name)
+		}
+	}
+	initiatedCollectorsMtx.Unlock()
+	sort.Strings(enabled)
+
+	statusesMtx.Lock()
+	defer statusesMtx.Unlock()
+	result := make([]CollectorStatus, 0, len(enabled))
+	for _, name := range enabled {
+		if s, ok := statuses[name]; ok {
+			result = append(result, *s)
+		} else {
+			result = append(result, CollectorStatus{Name: name, Success: true})
+		}
+	}
+	return result
+}
+
+// CollectorExists reports whether a collector of this name is compiled in.
+func CollectorExists(name string) bool {
+	_, ok := factories[name]
+	return ok
+}
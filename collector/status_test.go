@@ -0,0 +1,61 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecordRun(t *testing.T) {
+	statusOf := func(name string) CollectorStatus {
+		statusesMtx.Lock()
+		defer statusesMtx.Unlock()
+		return *statuses[name]
+	}
+	defer func() {
+		statusesMtx.Lock()
+		delete(statuses, "test_status")
+		statusesMtx.Unlock()
+	}()
+
+	begin := time.Unix(1000, 0)
+	recordRun("test_status", begin, time.Second, errors.New("boom"))
+	recordRun("test_status", begin.Add(time.Minute), time.Second, errors.New("boom again"))
+	s := statusOf("test_status")
+	if s.Success || s.ConsecutiveFailures != 2 || s.LastError != "boom again" {
+		t.Errorf("want 2 consecutive failures with the last error, got %+v", s)
+	}
+
+	recordRun("test_status", begin.Add(2*time.Minute), time.Second, ErrNoData)
+	s = statusOf("test_status")
+	if !s.Success || s.ConsecutiveFailures != 0 {
+		t.Errorf("want success resetting the failures, got %+v", s)
+	}
+	if want, got := "boom again", s.LastError; want != got {
+		t.Errorf("want last error %q kept, got %q", want, got)
+	}
+	if want, got := begin.Add(2*time.Minute), *s.LastRun; !want.Equal(got) {
+		t.Errorf("want last run %s, got %s", want, got)
+	}
+
+	recordRun("test_status", begin.Add(3*time.Minute), time.Second, errTimeout)
+	recordRun("test_status", begin.Add(4*time.Minute), 0, errStillRunning)
+	recordRun("test_status", begin.Add(5*time.Minute), 0, errStillRunning)
+	s = statusOf("test_status")
+	if s.ConsecutiveFailures != 1 {
+		t.Errorf("want skipped runs not counted as failures, got %d consecutive failures", s.ConsecutiveFailures)
+	}
+}
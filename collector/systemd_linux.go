@@ -77,6 +77,10 @@ type systemdCollector struct {
 	systemdVersionDesc            *prometheus.Desc
 	unitIncludePattern            *regexp.Regexp
 	unitExcludePattern            *regexp.Regexp
+	private                       bool
+	taskMetrics                   bool
+	restartsMetrics               bool
+	startTimeMetrics              bool
 	logger                        log.Logger
 }
 
@@ -169,6 +173,10 @@ func NewSystemdCollector(logger log.Logger) (Collector, error) {
 		systemdVersionDesc:            systemdVersionDesc,
 		unitIncludePattern:            unitIncludePattern,
 		unitExcludePattern:            unitExcludePattern,
+		private:                       *systemdPrivate,
+		taskMetrics:                   *enableTaskMetrics,
+		restartsMetrics:               *enableRestartsMetrics,
+		startTimeMetrics:              *enableStartTimeMetrics,
 		logger:                        logger,
 	}, nil
 }
@@ -177,7 +185,7 @@ func NewSystemdCollector(logger log.Logger) (Collector, error) {
 // to reduce wait time for responses.
 func (c *systemdCollector) Update(ch chan<- prometheus.Metric) error {
 	begin := time.Now()
-	conn, err := newSystemdDbusConn()
+	conn, err := newSystemdDbusConn(c.private)
 	if err != nil {
 		return fmt.Errorf("couldn't get dbus connection: %w", err)
 	}
@@ -220,7 +228,7 @@ func (c *systemdCollector) Update(ch chan<- prometheus.Metric) error {
 		level.Debug(c.logger).Log("msg", "collectUnitStatusMetrics took", "duration_seconds", time.Since(begin).Seconds())
 	}()
 
-	if *enableStartTimeMetrics {
+	if c.startTimeMetrics {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -230,7 +238,7 @@ func (c *systemdCollector) Update(ch chan<- prometheus.Metric) error {
 		}()
 	}
 
-	if *enableTaskMetrics {
+	if c.taskMetrics {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -294,7 +302,7 @@ func (c *systemdCollector) collectUnitStatusMetrics(conn *dbus.Conn, ch chan<- p
 				c.unitDesc, prometheus.GaugeValue, isActive,
 				unit.Name, stateName, serviceType)
 		}
-		if *enableRestartsMetrics && strings.HasSuffix(unit.Name, ".service") {
+		if c.restartsMetrics && strings.HasSuffix(unit.Name, ".service") {
 			// NRestarts wasn't added until systemd 235.
 			restartsCount, err := conn.GetUnitTypePropertyContext(context.TODO(), unit.Name, "Service", "NRestarts")
 			if err != nil {
@@ -435,8 +443,8 @@ func (c *systemdCollector) collectSystemState(conn *dbus.Conn, ch chan<- prometh
 	return nil
 }
 
-func newSystemdDbusConn() (*dbus.Conn, error) {
-	if *systemdPrivate {
+func newSystemdDbusConn(private bool) (*dbus.Conn, error) {
+	if private {
 		return dbus.NewSystemdConnectionContext(context.TODO())
 	}
 	return dbus.NewWithContext(context.TODO())
@@ -0,0 +1,289 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notcpinfo
+// +build !notcpinfo
+
+package collector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/mdlayher/netlink"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sys/unix"
+)
+
+const tcpInfoSubsystem = "tcpinfo"
+
+var (
+	tcpInfoPeerGroups = kingpin.Flag("collector.tcpinfo.peer-group", "Group of remote peers to aggregate connections by, as name=cidr[,cidr...]. Peers outside all groups are reported as other, can be repeated.").Strings()
+	tcpInfoLocalPorts = kingpin.Flag("collector.tcpinfo.local-ports", "Comma separated local ports to aggregate connections by, connections on other ports are reported as other.").Default("").String()
+	// tcpInfoRTTBuckets are the upper bounds of the round trip time histogram
+	// in seconds, from the same rack up to intercontinental paths.
+	tcpInfoRTTBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+)
+
+// tcpInfoPeerGroup is a named set of remote networks.
+type tcpInfoPeerGroup struct {
+	name string
+	nets []*net.IPNet
+}
+
+// tcpInfoKey is the aggregation of a connection. Both values are bounded by
+// the configured groups and ports, plus other.
+type tcpInfoKey struct {
+	peerGroup string
+	localPort string
+}
+
+type tcpInfoTotals struct {
+	connections   uint64
+	rttSum        float64
+	rttBuckets    map[float64]uint64
+	rttvarSum     float64
+	cwndSum       float64
+	rtoRetrans    float64
+	retransSegs   float64
+	lostSegs      float64
+	deliveryRate  float64
+	bytesAcked    float64
+	bytesReceived float64
+}
+
+type tcpInfoCollector struct {
+	peerGroups []tcpInfoPeerGroup
+	localPorts map[uint16]bool
+	logger     log.Logger
+
+	connections   *prometheus.Desc
+	rtt           *prometheus.Desc
+	rttvar        *prometheus.Desc
+	cwnd          *prometheus.Desc
+	rtoRetrans    *prometheus.Desc
+	retransSegs   *prometheus.Desc
+	lostSegs      *prometheus.Desc
+	deliveryRate  *prometheus.Desc
+	bytesAcked    *prometheus.Desc
+	bytesReceived *prometheus.Desc
+}
+
+func init() {
+	registerCollector(tcpInfoSubsystem, defaultDisabled, NewTCPInfoCollector)
+}
+
+// NewTCPInfoCollector returns a new Collector exposing the tcp_info of the
+// established TCP connections aggregated by remote peer group and local port.
+func NewTCPInfoCollector(logger log.Logger) (Collector, error) {
+	groups, err := parseTCPInfoPeerGroups(*tcpInfoPeerGroups)
+	if err != nil {
+		return nil, err
+	}
+	ports, err := parseTCPInfoLocalPorts(*tcpInfoLocalPorts)
+	if err != nil {
+		return nil, err
+	}
+	labels := []string{"peer_group", "local_port"}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, tcpInfoSubsystem, name), help, labels, nil)
+	}
+	return &tcpInfoCollector{
+		peerGroups:    groups,
+		localPorts:    ports,
+		logger:        logger,
+		connections:   desc("connections", "Number of established TCP connections."),
+		rtt:           desc("rtt_seconds", "Smoothed round trip time of the established TCP connections."),
+		rttvar:        desc("rtt_variance_average_seconds", "Average round trip time variance of the established TCP connections."),
+		cwnd:          desc("congestion_window_average_segments", "Average congestion window of the established TCP connections."),
+		rtoRetrans:    desc("rto_retransmits", "Unrecovered retransmission timeouts of the established TCP connections."),
+		retransSegs:   desc("retransmitted_segments", "Segments retransmitted over the lifetime of the established TCP connections."),
+		lostSegs:      desc("lost_segments", "Segments currently considered lost on the established TCP connections."),
+		deliveryRate:  desc("delivery_rate_bytes_per_second", "Sum of the recent delivery rates of the established TCP connections."),
+		bytesAcked:    desc("acked_bytes", "Bytes acknowledged by the peers over the lifetime of the established TCP connections."),
+		bytesReceived: desc("received_bytes", "Bytes received over the lifetime of the established TCP connections."),
+	}, nil
+}
+
+func (c *tcpInfoCollector) Update(ch chan<- prometheus.Metric) error {
+	conn, err := netlink.Dial(syscall.NETLINK_INET_DIAG, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't connect netlink: %w", err)
+	}
+	defer conn.Close()
+
+	totals := map[tcpInfoKey]*tcpInfoTotals{}
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		msgs, err := inetDiagDump(conn, family, syscall.IPPROTO_TCP, 1<<(inetDiagInfo-1), 1<<tcpEstablished)
+		if err != nil {
+			if family == syscall.AF_INET6 {
+				// IPv6 is disabled.
+				continue
+			}
+			return fmt.Errorf("couldn't dump tcp sockets: %w", err)
+		}
+		if err := c.aggregate(msgs, totals); err != nil {
+			return err
+		}
+	}
+
+	for key, t := range totals {
+		labels := []string{key.peerGroup, key.localPort}
+		n := float64(t.connections)
+		ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, n, labels...)
+		ch <- prometheus.MustNewConstHistogram(c.rtt, t.connections, t.rttSum, t.rttBuckets, labels...)
+		ch <- prometheus.MustNewConstMetric(c.rttvar, prometheus.GaugeValue, t.rttvarSum/n, labels...)
+		ch <- prometheus.MustNewConstMetric(c.cwnd, prometheus.GaugeValue, t.cwndSum/n, labels...)
+		ch <- prometheus.MustNewConstMetric(c.rtoRetrans, prometheus.GaugeValue, t.rtoRetrans, labels...)
+		ch <- prometheus.MustNewConstMetric(c.retransSegs, prometheus.GaugeValue, t.retransSegs, labels...)
+		ch <- prometheus.MustNewConstMetric(c.lostSegs, prometheus.GaugeValue, t.lostSegs, labels...)
+		ch <- prometheus.MustNewConstMetric(c.deliveryRate, prometheus.GaugeValue, t.deliveryRate, labels...)
+		ch <- prometheus.MustNewConstMetric(c.bytesAcked, prometheus.GaugeValue, t.bytesAcked, labels...)
+		ch <- prometheus.MustNewConstMetric(c.bytesReceived, prometheus.GaugeValue, t.bytesReceived, labels...)
+	}
+	return nil
+}
+
+// aggregate adds the tcp_info of each socket in a dump to its group totals.
+func (c *tcpInfoCollector) aggregate(msgs []netlink.Message, totals map[tcpInfoKey]*tcpInfoTotals) error {
+	for _, m := range msgs {
+		if len(m.Data) < sizeOfDiagMsg {
+			return fmt.Errorf("short inet_diag_msg: %d bytes", len(m.Data))
+		}
+		msg := parseInetDiagMsg(m.Data)
+		info, ok, err := parseTCPInfoAttr(m.Data[sizeOfDiagMsg:])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		key := tcpInfoKey{
+			peerGroup: c.peerGroup(inetDiagIP(msg.Family, msg.ID.DestIP)),
+			localPort: "other",
+		}
+		if port := binary.BigEndian.Uint16(msg.ID.SourcePort[:]); c.localPorts[port] {
+			key.localPort = strconv.Itoa(int(port))
+		}
+		t, ok := totals[key]
+		if !ok {
+			t = &tcpInfoTotals{rttBuckets: make(map[float64]uint64, len(tcpInfoRTTBuckets))}
+			totals[key] = t
+		}
+
+		// Times in tcp_info are in microseconds.
+		rtt := float64(info.Rtt) / 1e6
+		t.connections++
+		t.rttSum += rtt
+		for _, bound := range tcpInfoRTTBuckets {
+			if rtt <= bound {
+				t.rttBuckets[bound]++
+			}
+		}
+		t.rttvarSum += float64(info.Rttvar) / 1e6
+		t.cwndSum += float64(info.Snd_cwnd)
+		t.rtoRetrans += float64(info.Retransmits)
+		t.retransSegs += float64(info.Total_retrans)
+		t.lostSegs += float64(info.Lost)
+		t.deliveryRate += float64(info.Delivery_rate)
+		t.bytesAcked += float64(info.Bytes_acked)
+		t.bytesReceived += float64(info.Bytes_received)
+	}
+	return nil
+}
+
+func (c *tcpInfoCollector) peerGroup(ip net.IP) string {
+	for _, g := range c.peerGroups {
+		for _, n := range g.nets {
+			if n.Contains(ip) {
+				return g.name
+			}
+		}
+	}
+	return "other"
+}
+
+// parseTCPInfoAttr returns the tcp_info from the attributes following an
+// inet_diag_msg. Older kernels send a shorter struct, the fields they don't
+// know are left zero.
+func parseTCPInfoAttr(b []byte) (unix.TCPInfo, bool, error) {
+	var info unix.TCPInfo
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return info, false, err
+	}
+	for ad.Next() {
+		if ad.Type() != inetDiagInfo {
+			continue
+		}
+		copy((*[unsafe.Sizeof(info)]byte)(unsafe.Pointer(&info))[:], ad.Bytes())
+		return info, true, nil
+	}
+	return info, false, ad.Err()
+}
+
+// inetDiagIP returns the address of an inet_diag_sockid, IPv4-mapped IPv6
+// addresses are returned as IPv4 to match IPv4 networks.
+func inetDiagIP(family uint8, addr [4][4]byte) net.IP {
+	if family == syscall.AF_INET {
+		return net.IP(addr[0][:]).To16()
+	}
+	ip := make(net.IP, 0, net.IPv6len)
+	for _, word := range addr {
+		ip = append(ip, word[:]...)
+	}
+	return ip
+}
+
+func parseTCPInfoPeerGroups(flags []string) ([]tcpInfoPeerGroup, error) {
+	groups := make([]tcpInfoPeerGroup, 0, len(flags))
+	for _, flag := range flags {
+		name, cidrs, ok := strings.Cut(flag, "=")
+		if !ok || name == "" || name == "other" {
+			return nil, fmt.Errorf("invalid peer group %q, want name=cidr[,cidr...]", flag)
+		}
+		group := tcpInfoPeerGroup{name: name}
+		for _, cidr := range strings.Split(cidrs, ",") {
+			_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid peer group %q: %w", flag, err)
+			}
+			group.nets = append(group.nets, n)
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+func parseTCPInfoLocalPorts(flag string) (map[uint16]bool, error) {
+	ports := map[uint16]bool{}
+	for _, s := range strings.Split(flag, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		port, err := strconv.ParseUint(s, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local port %q: %w", s, err)
+		}
+		ports[uint16(port)] = true
+	}
+	return ports, nil
+}
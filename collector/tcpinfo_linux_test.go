@@ -0,0 +1,126 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !notcpinfo
+// +build !notcpinfo
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/josharian/native"
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestTCPInfoAggregate(t *testing.T) {
+	groups, err := parseTCPInfoPeerGroups([]string{"storage=10.1.0.0/16,fd00:1::/64", "rack=10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports, err := parseTCPInfoLocalPorts("2049, 3260")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &tcpInfoCollector{peerGroups: groups, localPorts: ports}
+
+	encode := func(family uint8, local uint16, remote net.IP, info unix.TCPInfo, size uintptr) netlink.Message {
+		msg := InetDiagMsg{Family: family, State: uint8(tcpEstablished)}
+		binary.BigEndian.PutUint16(msg.ID.SourcePort[:], local)
+		if family == syscall.AF_INET {
+			copy(msg.ID.DestIP[0][:], remote.To4())
+		} else {
+			for i := range msg.ID.DestIP {
+				copy(msg.ID.DestIP[i][:], remote.To16()[i*4:])
+			}
+		}
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, native.Endian, msg); err != nil {
+			t.Fatal(err)
+		}
+		ae := netlink.NewAttributeEncoder()
+		ae.Bytes(inetDiagInfo, (*[unsafe.Sizeof(info)]byte)(unsafe.Pointer(&info))[:size])
+		attrs, err := ae.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return netlink.Message{Data: append(buf.Bytes(), attrs...)}
+	}
+	full := unsafe.Sizeof(unix.TCPInfo{})
+	msgs := []netlink.Message{
+		encode(syscall.AF_INET, 2049, net.ParseIP("10.1.2.3"), unix.TCPInfo{Rtt: 200, Rttvar: 50, Snd_cwnd: 10, Total_retrans: 3, Delivery_rate: 1000, Bytes_acked: 10, Bytes_received: 20}, full),
+		encode(syscall.AF_INET6, 2049, net.ParseIP("fd00:1::5"), unix.TCPInfo{Rtt: 600, Rttvar: 150, Snd_cwnd: 30, Lost: 1, Delivery_rate: 500}, full),
+		encode(syscall.AF_INET6, 2049, net.ParseIP("::ffff:10.1.9.9"), unix.TCPInfo{Rtt: 100}, full),
+		encode(syscall.AF_INET, 22, net.ParseIP("10.9.9.9"), unix.TCPInfo{Rtt: 30000, Retransmits: 2}, full),
+		// A kernel without the delivery rate and later fields.
+		encode(syscall.AF_INET, 40000, net.ParseIP("192.0.2.1"), unix.TCPInfo{Rtt: 2000000, Delivery_rate: 1}, unsafe.Offsetof(unix.TCPInfo{}.Delivery_rate)),
+	}
+	totals := map[tcpInfoKey]*tcpInfoTotals{}
+	if err := c.aggregate(msgs, totals); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 3, len(totals); want != got {
+		t.Fatalf("want %d groups, got %d: %v", want, got, totals)
+	}
+	storage := totals[tcpInfoKey{"storage", "2049"}]
+	if storage == nil {
+		t.Fatal("storage group not found")
+	}
+	if want, got := uint64(3), storage.connections; want != got {
+		t.Errorf("want %d storage connections, got %d", want, got)
+	}
+	if want, got := 0.0009, storage.rttSum; want-got > 1e-12 || got-want > 1e-12 {
+		t.Errorf("want rtt sum %v, got %v", want, got)
+	}
+	if want, got := uint64(2), storage.rttBuckets[.00025]; want != got {
+		t.Errorf("want %d connections below 250us, got %d", want, got)
+	}
+	if want, got := uint64(3), storage.rttBuckets[.001]; want != got {
+		t.Errorf("want %d connections below 1ms, got %d", want, got)
+	}
+	if want, got := 40.0, storage.cwndSum; want != got {
+		t.Errorf("want cwnd sum %v, got %v", want, got)
+	}
+	if want, got := 1500.0, storage.deliveryRate; want != got {
+		t.Errorf("want delivery rate %v, got %v", want, got)
+	}
+
+	if rack := totals[tcpInfoKey{"rack", "other"}]; rack == nil || rack.rtoRetrans != 2 {
+		t.Errorf("unexpected rack totals %+v", rack)
+	}
+	other := totals[tcpInfoKey{"other", "other"}]
+	if other == nil || other.connections != 1 || other.deliveryRate != 0 || other.rttBuckets[1] != 0 {
+		t.Errorf("unexpected other totals %+v", other)
+	}
+}
+
+func TestTCPInfoFlagErrors(t *testing.T) {
+	for _, groups := range [][]string{{"storage"}, {"=10.0.0.0/8"}, {"other=10.0.0.0/8"}, {"storage=10.0.0.0"}} {
+		if _, err := parseTCPInfoPeerGroups(groups); err == nil {
+			t.Errorf("%v: want error", groups)
+		}
+	}
+	if _, err := parseTCPInfoLocalPorts("22,http"); err == nil {
+		t.Error("want error for a non-numeric port")
+	}
+	if _, err := parseTCPInfoLocalPorts("65536"); err == nil {
+		t.Error("want error for an out of range port")
+	}
+}
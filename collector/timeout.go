@@ -0,0 +1,125 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var scrapeTimeoutDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "scrape", "collector_timeout"),
+	"node_exporter: Whether a collector was abandoned because it didn't finish in time.",
+	[]string{"collector"},
+	nil,
+)
+
+var (
+	// errTimeout is returned for collectors abandoned at their timeout.
+	errTimeout = errors.New("collector timed out")
+	// errStillRunning is returned for collectors whose abandoned update
+	// hasn't returned yet, they aren't started again until it does.
+	errStillRunning = errors.New("collector timed out, previous update still running")
+
+	// abandoned counts the updates per collector name which were abandoned
+	// at their timeout and didn't return yet.
+	abandonedMtx sync.Mutex
+	abandoned    = map[string]int{}
+)
+
+func isTimeoutError(err error) bool {
+	return errors.Is(err, errTimeout) || errors.Is(err, errStillRunning)
+}
+
+// collectorTimeout returns how long a collector may run, the smaller of its
+// configured timeout and the time left until the deadline. Zero means no
+// limit.
+func collectorTimeout(timeout time.Duration, deadline time.Time) time.Duration {
+	if !deadline.IsZero() {
+		left := time.Until(deadline)
+		if left <= 0 {
+			// Zero means no limit, the collector is abandoned right away.
+			left = time.Nanosecond
+		}
+		if timeout <= 0 || left < timeout {
+			timeout = left
+		}
+	}
+	return timeout
+}
+
+// updateWithTimeout runs c.Update and forwards its metrics to ch. If the
+// update doesn't return within timeout it is abandoned: the metrics already
+// forwarded are kept, the remaining ones are discarded and errTimeout is
+// returned. Concurrent updates, e.g. by several Prometheus servers, run
+// side by side, but the collector isn't started again while an abandoned
+// update is still running, so a hanging collector ties up one goroutine
+// instead of one per scrape.
+func updateWithTimeout(name string, c Collector, ch chan<- prometheus.Metric, timeout time.Duration) error {
+	abandonedMtx.Lock()
+	if abandoned[name] > 0 {
+		abandonedMtx.Unlock()
+		return errStillRunning
+	}
+	abandonedMtx.Unlock()
+
+	// finished and wasAbandoned are guarded by abandonedMtx.
+	var finished, wasAbandoned bool
+	out := make(chan prometheus.Metric)
+	done := make(chan error, 1)
+	go func() {
+		err := c.Update(out)
+		abandonedMtx.Lock()
+		finished = true
+		if wasAbandoned {
+			if abandoned[name]--; abandoned[name] <= 0 {
+				delete(abandoned, name)
+			}
+		}
+		abandonedMtx.Unlock()
+		done <- err
+		close(out)
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case m, ok := <-out:
+			if !ok {
+				return <-done
+			}
+			ch <- m
+		case <-expired:
+			abandonedMtx.Lock()
+			if !finished {
+				wasAbandoned = true
+				abandoned[name]++
+			}
+			abandonedMtx.Unlock()
+			go func() {
+				for range out {
+				}
+			}()
+			return errTimeout
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// hangingCollector sends one metric and blocks until released.
+type hangingCollector struct {
+	desc    *prometheus.Desc
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (c *hangingCollector) Update(ch chan<- prometheus.Metric) error {
+	defer close(c.done)
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	<-c.release
+	return nil
+}
+
+func TestNodeCollectorTimeout(t *testing.T) {
+	hanging := &hangingCollector{
+		desc:    prometheus.NewDesc("test_hanging", "Hanging.", nil, nil),
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	counting := &countingCollector{desc: prometheus.NewDesc("test_runs_total", "Runs.", nil, nil)}
+	n := nodeCollectorAdapter{NodeCollector{
+		Collectors: map[string]Collector{"hanging": hanging, "counting": counting},
+		Timeout:    50 * time.Millisecond,
+		logger:     log.NewNopLogger(),
+	}}
+
+	// The metrics sent before the timeout are kept.
+	want := `
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="counting"} 1
+node_scrape_collector_success{collector="hanging"} 0
+# HELP node_scrape_collector_timeout node_exporter: Whether a collector was abandoned because it didn't finish in time.
+# TYPE node_scrape_collector_timeout gauge
+node_scrape_collector_timeout{collector="counting"} 0
+node_scrape_collector_timeout{collector="hanging"} 1
+# HELP test_hanging Hanging.
+# TYPE test_hanging gauge
+test_hanging 1
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 1
+`
+	metrics := []string{"test_hanging", "test_runs_total", "node_scrape_collector_success", "node_scrape_collector_timeout"}
+	if err := testutil.CollectAndCompare(n, strings.NewReader(want), metrics...); err != nil {
+		t.Fatal(err)
+	}
+
+	// The abandoned update isn't started again while it still runs.
+	want = `
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="counting"} 1
+node_scrape_collector_success{collector="hanging"} 0
+# HELP node_scrape_collector_timeout node_exporter: Whether a collector was abandoned because it didn't finish in time.
+# TYPE node_scrape_collector_timeout gauge
+node_scrape_collector_timeout{collector="counting"} 0
+node_scrape_collector_timeout{collector="hanging"} 1
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 2
+`
+	if err := testutil.CollectAndCompare(n, strings.NewReader(want), metrics...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the abandoned update returns the collector runs again.
+	close(hanging.release)
+	<-hanging.done
+	for i := 0; i < 100; i++ {
+		abandonedMtx.Lock()
+		running := abandoned["hanging"] > 0
+		abandonedMtx.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	hanging.done = make(chan struct{})
+	want = `
+# HELP node_scrape_collector_success node_exporter: Whether a collector succeeded.
+# TYPE node_scrape_collector_success gauge
+node_scrape_collector_success{collector="counting"} 1
+node_scrape_collector_success{collector="hanging"} 1
+# HELP node_scrape_collector_timeout node_exporter: Whether a collector was abandoned because it didn't finish in time.
+# TYPE node_scrape_collector_timeout gauge
+node_scrape_collector_timeout{collector="counting"} 0
+node_scrape_collector_timeout{collector="hanging"} 0
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total 3
+`
+	if err := testutil.CollectAndCompare(n, strings.NewReader(want), metrics[1:]...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// overlappingCollector blocks until n updates run at the same time.
+type overlappingCollector struct {
+	desc    *prometheus.Desc
+	started sync.WaitGroup
+}
+
+func (c *overlappingCollector) Update(ch chan<- prometheus.Metric) error {
+	c.started.Done()
+	c.started.Wait()
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	c := &overlappingCollector{desc: prometheus.NewDesc("test_overlapping", "Overlapping.", nil, nil)}
+	c.started.Add(2)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			ch := make(chan prometheus.Metric, 1)
+			errs <- updateWithTimeout("overlapping", c, ch, 0)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("want overlapping updates to succeed, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("want overlapping updates to run side by side, got them serialized")
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// scrapeFilters are the filters of a /metrics request:
+//
+//	collect[]  collectors to run, all enabled ones if not given
+//	exclude[]  collectors not to run
+//	match[]    series to keep, all if not given
+//	drop[]     series to remove
+//
+// match[] and drop[] are label=regex, matching the values of a label, or
+// __name__=regex, matching metric names. Like in Prometheus the expressions
+// are anchored and a missing label has an empty value. A series is kept if it
+// matches any match[] and no drop[] filter.
+//
+// Only collect[] and exclude[] save work. The metrics of a collector are only
+// known once it ran, so match[] and drop[] are applied to the gathered
+// metrics and the collectors they remove everything of still run.
+type scrapeFilters struct {
+	collect []string
+	exclude []string
+	match   []metricFilter
+	drop    []metricFilter
+}
+
+// metricFilter matches the name of a metric, or the value of a label.
+type metricFilter struct {
+	raw   string
+	label string
+	re    *regexp.Regexp
+}
+
+func parseScrapeFilters(q url.Values) (scrapeFilters, error) {
+	f := scrapeFilters{
+		collect: q["collect[]"],
+		exclude: q["exclude[]"],
+	}
+	var err error
+	if f.match, err = parseMetricFilters(q["match[]"]); err != nil {
+		return f, fmt.Errorf("invalid match[]: %w", err)
+	}
+	if f.drop, err = parseMetricFilters(q["drop[]"]); err != nil {
+		return f, fmt.Errorf("invalid drop[]: %w", err)
+	}
+	return f, nil
+}
+
+func parseMetricFilters(values []string) ([]metricFilter, error) {
+	filters := make([]metricFilter, 0, len(values))
+	for _, v := range values {
+		// Label names can't contain =, so the regex may.
+		label, expr, ok := strings.Cut(v, "=")
+		if !ok || !labelNameRE.MatchString(label) {
+			return nil, fmt.Errorf("%q is not label=regex or __name__=regex", v)
+		}
+		f := metricFilter{raw: v}
+		if label != "__name__" {
+			f.label = label
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, err
+		}
+		f.re = re
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// empty reports whether the request has no filters.
+func (f scrapeFilters) empty() bool {
+	return len(f.collect) == 0 && len(f.exclude) == 0 && len(f.match) == 0 && len(f.drop) == 0
+}
+
+// key identifies the requests with the same filters, regardless of their
+// order.
+func (f scrapeFilters) key() string {
+	sorted := func(values []string) string {
+		v := append([]string{}, values...)
+		sort.Strings(v)
+		return strings.Join(v, "\x00")
+	}
+	raw := func(filters []metricFilter) []string {
+		values := make([]string, 0, len(filters))
+		for _, f := range filters {
+			values = append(values, f.raw)
+		}
+		return values
+	}
+	return strings.Join([]string{sorted(f.collect), sorted(f.exclude), sorted(raw(f.match)), sorted(raw(f.drop))}, "\x01")
+}
+
+// gatherer applies the match[] and drop[] filters to the metrics gathered
+// from g.
+func (f scrapeFilters) gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if len(f.match) == 0 && len(f.drop) == 0 {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		filtered := mfs[:0]
+		for _, mf := range mfs {
+			metrics := mf.Metric[:0]
+			for _, m := range mf.Metric {
+				if (len(f.match) == 0 || anyMatches(f.match, mf, m)) && !anyMatches(f.drop, mf, m) {
+					metrics = append(metrics, m)
+				}
+			}
+			if len(metrics) > 0 {
+				mf.Metric = metrics
+				filtered = append(filtered, mf)
+			}
+		}
+		return filtered, err
+	})
+}
+
+func anyMatches(filters []metricFilter, mf *dto.MetricFamily, m *dto.Metric) bool {
+	for _, f := range filters {
+		if f.matches(mf, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f metricFilter) matches(mf *dto.MetricFamily, m *dto.Metric) bool {
+	if f.label == "" {
+		return f.re.MatchString(mf.GetName())
+	}
+	for _, l := range m.GetLabel() {
+		if l.GetName() == f.label {
+			return f.re.MatchString(l.GetValue())
+		}
+	}
+	return f.re.MatchString("")
+}
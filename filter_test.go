@@ -0,0 +1,114 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestScrapeFilters(t *testing.T) {
+	newRegistry := func() prometheus.Gatherer {
+		r := prometheus.NewRegistry()
+		cpu := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "node_cpu_seconds_total", Help: "CPU."}, []string{"cpu", "mode"})
+		cpu.WithLabelValues("0", "idle").Add(1)
+		cpu.WithLabelValues("0", "user").Add(1)
+		gpu := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "node_gpu_utilization", Help: "GPU."}, []string{"gpu"})
+		gpu.WithLabelValues("0").Set(1)
+		load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "Load."})
+		r.MustRegister(cpu, gpu, load)
+		return r
+	}
+	series := func(mfs []*dto.MetricFamily) []string {
+		var s []string
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				name := mf.GetName()
+				for _, l := range m.Label {
+					name += "," + l.GetName() + "=" + l.GetValue()
+				}
+				s = append(s, name)
+			}
+		}
+		return s
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{
+			query: "",
+			want:  []string{"node_cpu_seconds_total,cpu=0,mode=idle", "node_cpu_seconds_total,cpu=0,mode=user", "node_gpu_utilization,gpu=0", "node_load1"},
+		},
+		{
+			query: "match[]=__name__=node_cpu_.*&match[]=__name__=node_load1",
+			want:  []string{"node_cpu_seconds_total,cpu=0,mode=idle", "node_cpu_seconds_total,cpu=0,mode=user", "node_load1"},
+		},
+		{
+			query: "drop[]=__name__=node_gpu_.*&drop[]=mode=idle",
+			want:  []string{"node_cpu_seconds_total,cpu=0,mode=user", "node_load1"},
+		},
+		{
+			// A missing label has an empty value.
+			query: "match[]=mode=user|",
+			want:  []string{"node_cpu_seconds_total,cpu=0,mode=user", "node_gpu_utilization,gpu=0", "node_load1"},
+		},
+		{
+			query: "match[]=__name__=node_.*1&drop[]=__name__=node",
+			want:  []string{"node_load1"},
+		},
+		{
+			// Everything after the first = is the regex.
+			query: "match[]=" + url.QueryEscape("__name__=node_load1|a=b"),
+			want:  []string{"node_load1"},
+		},
+	} {
+		q, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := parseScrapeFilters(q)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.query, err)
+		}
+		mfs, err := f.gatherer(newRegistry()).Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := series(mfs); !reflect.DeepEqual(tc.want, got) {
+			t.Errorf("%s: want %q, got %q", tc.query, tc.want, got)
+		}
+	}
+
+	for _, drop := range []string{"mode=(", "node_.*", "node|a=b"} {
+		if _, err := parseScrapeFilters(url.Values{"drop[]": {drop}}); err == nil {
+			t.Errorf("want error for drop[]=%s", drop)
+		}
+	}
+
+	a, _ := parseScrapeFilters(url.Values{"collect[]": {"cpu", "gpu"}, "match[]": {"__name__=node_.*"}})
+	b, _ := parseScrapeFilters(url.Values{"collect[]": {"gpu", "cpu"}, "match[]": {"__name__=node_.*"}})
+	c, _ := parseScrapeFilters(url.Values{"collect[]": {"gpu", "cpu"}, "drop[]": {"__name__=node_.*"}})
+	if a.key() != b.key() {
+		t.Error("want the same key regardless of the order of filters")
+	}
+	if a.key() == c.key() {
+		t.Error("want different keys for match[] and drop[]")
+	}
+}
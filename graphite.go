@@ -0,0 +1,89 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	graphiteAddress = kingpin.Flag("graphite.address", "Address of a Graphite plaintext listener to push the metrics to over TCP, e.g. graphite:2003. Disabled if empty.").Default("").String()
+	graphiteFlags   = registerSinkFlags("graphite")
+)
+
+var (
+	graphiteTagNameReplacer  = strings.NewReplacer(";", "_", "!", "_", "^", "_", "=", "_", " ", "_", "\n", "_")
+	graphiteTagValueReplacer = strings.NewReplacer(";", "_", " ", "_", "\n", "_")
+)
+
+// newGraphiteSink returns a sink writing the Graphite plaintext protocol with
+// tags over TCP. Each line is a Prometheus series, e.g. histograms are split
+// into their _bucket, _sum and _count series.
+func newGraphiteSink(address string, cfg sinkConfig, g prometheus.Gatherer, logger log.Logger) *pushSink {
+	s := newPushSink("graphite", cfg, g, logger)
+	s.encode = func(points []sinkPoint) [][]byte { return graphiteLines(cfg.prefix, points) }
+	s.write = func(ctx context.Context, lines [][]byte) error {
+		return graphiteWrite(ctx, address, lines)
+	}
+	return s
+}
+
+func graphiteLines(prefix string, points []sinkPoint) [][]byte {
+	var lines [][]byte
+	for _, p := range points {
+		ts := " " + strconv.FormatInt(p.time.Unix(), 10) + "\n"
+		for _, f := range p.fields {
+			tags := p.tags
+			if f.extraLabel != "" {
+				tags = append(append([][2]string{}, p.tags...), [2]string{f.extraLabel, f.key})
+				sort.Slice(tags, func(i, j int) bool { return tags[i][0] < tags[j][0] })
+			}
+			var b bytes.Buffer
+			b.WriteString(graphiteTagNameReplacer.Replace(prefix + p.name + f.suffix))
+			for _, t := range tags {
+				value := graphiteTagValueReplacer.Replace(t[1])
+				if strings.HasPrefix(value, "~") {
+					value = "_" + value[1:]
+				}
+				b.WriteString(";" + graphiteTagNameReplacer.Replace(t[0]) + "=" + value)
+			}
+			b.WriteString(" " + strconv.FormatFloat(f.value, 'g', -1, 64) + ts)
+			lines = append(lines, b.Bytes())
+		}
+	}
+	return lines
+}
+
+func graphiteWrite(ctx context.Context, address string, lines [][]byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	_, err = conn.Write(bytes.Join(lines, nil))
+	return err
+}
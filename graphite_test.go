@@ -0,0 +1,74 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGraphiteSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- string(b)
+	}()
+
+	r, _ := newSinkTestRegistry()
+	cfg := sinkConfig{timeout: time.Second, prefix: "servers.node1.", tags: map[string]string{"cpu": ""}}
+	s := newGraphiteSink(l.Addr().String(), cfg, r, log.NewNopLogger())
+	s.now = func() time.Time { return time.Unix(1000, 0) }
+	if err := s.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `servers.node1.node_cpu_seconds_total;mode=idle 3 1000
+servers.node1.node_load1 0.5 1000
+servers.node1.test_duration_seconds_count 2 1000
+servers.node1.test_duration_seconds_sum 2.5 1000
+servers.node1.test_duration_seconds_bucket;le=1 1 1000
+servers.node1.test_duration_seconds_bucket;le=+Inf 2 1000
+`
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the Graphite lines")
+	}
+	if want, got := 6.0, testutil.ToFloat64(s.sent); want != got {
+		t.Errorf("want %g lines sent, got %g", want, got)
+	}
+}
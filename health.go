@@ -0,0 +1,105 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/node_exporter/collector"
+)
+
+var (
+	criticalCollectors = kingpin.Flag("web.ready.critical-collector", "Collector whose failures make /-/ready fail, e.g. gpu or diskstats. Can be repeated.").Strings()
+	readyMaxFailures   = kingpin.Flag("web.ready.max-failures", "Number of consecutive failed runs of a critical collector after which /-/ready fails.").Default("3").Int()
+)
+
+// readiness reports the exporter as not ready while critical collectors
+// are failing. Collectors only run on scrapes unless they run in the
+// background, so their status is as recent as the last scrape.
+type readiness struct {
+	critical    []string
+	maxFailures int
+	statuses    func() []collector.CollectorStatus
+}
+
+func newReadiness(critical []string, maxFailures int) (*readiness, error) {
+	for _, name := range critical {
+		if !collector.CollectorExists(name) {
+			return nil, fmt.Errorf("unknown critical collector %q", name)
+		}
+	}
+	if maxFailures < 1 {
+		return nil, fmt.Errorf("invalid max failures %d, want at least 1", maxFailures)
+	}
+	return &readiness{critical: critical, maxFailures: maxFailures, statuses: collector.CollectorStatuses}, nil
+}
+
+// failing returns the critical collectors which failed too often, and the
+// status of all enabled collectors.
+func (r *readiness) failing() ([]string, []collector.CollectorStatus) {
+	statuses := r.statuses()
+	var failing []string
+	for _, name := range r.critical {
+		for _, s := range statuses {
+			if s.Name == name && s.ConsecutiveFailures >= r.maxFailures {
+				failing = append(failing, fmt.Sprintf("%s: %s", name, s.LastError))
+			}
+		}
+	}
+	return failing, statuses
+}
+
+func healthyHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "Node Exporter is Healthy.")
+}
+
+func (r *readiness) readyHandler(w http.ResponseWriter, req *http.Request) {
+	if failing, _ := r.failing(); len(failing) > 0 {
+		http.Error(w, fmt.Sprintf("Node Exporter is not ready, critical collectors failing:\n%s", strings.Join(failing, "\n")), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Node Exporter is Ready.")
+}
+
+// statusHandler serves the readiness and the status of each enabled
+// collector as JSON.
+func (r *readiness) statusHandler(logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		failing, statuses := r.failing()
+		status := struct {
+			Ready              bool                        `json:"ready"`
+			Failing            []string                    `json:"failing,omitempty"`
+			CriticalCollectors []string                    `json:"critical_collectors"`
+			Collectors         []collector.CollectorStatus `json:"collectors"`
+		}{
+			Ready:              len(failing) == 0,
+			Failing:            failing,
+			CriticalCollectors: r.critical,
+			Collectors:         statuses,
+		}
+		if status.CriticalCollectors == nil {
+			status.CriticalCollectors = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			level.Error(logger).Log("msg", "Couldn't encode status", "err", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/node_exporter/collector"
+)
+
+func TestReadiness(t *testing.T) {
+	if _, err := newReadiness([]string{"nonexistent"}, 3); err == nil {
+		t.Error("want error for unknown critical collector")
+	}
+
+	r, err := newReadiness([]string{"loadavg"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []collector.CollectorStatus{
+		{Name: "cpu", Success: true},
+		{Name: "loadavg", LastError: "no such file", ConsecutiveFailures: 2},
+	}
+	r.statuses = func() []collector.CollectorStatus { return statuses }
+	get := func(h http.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	if want, got := http.StatusOK, get(r.readyHandler).Code; want != got {
+		t.Errorf("want status %d below the failure threshold, got %d", want, got)
+	}
+	statuses[1].ConsecutiveFailures = 3
+	rec := get(r.readyHandler)
+	if want, got := http.StatusServiceUnavailable, rec.Code; want != got {
+		t.Errorf("want status %d at the failure threshold, got %d", want, got)
+	}
+	if !strings.Contains(rec.Body.String(), "loadavg: no such file") {
+		t.Errorf("want failing collector in body, got %q", rec.Body.String())
+	}
+
+	var status struct {
+		Ready      bool
+		Failing    []string
+		Collectors []collector.CollectorStatus
+	}
+	if err := json.NewDecoder(get(r.statusHandler(log.NewNopLogger())).Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Ready || len(status.Failing) != 1 || len(status.Collectors) != 2 {
+		t.Errorf("want not ready with 1 failing of 2 collectors, got %+v", status)
+	}
+}
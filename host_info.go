@@ -0,0 +1,182 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/node_exporter/collector"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	hostLabels          = kingpin.Flag("host.label", "Label of the host as name=value, exposed by node_host_info. Can be repeated.").Strings()
+	hostMetadataFile    = kingpin.Flag("host.metadata-file", "JSON file with an object whose values are labels of the host, e.g. /etc/bms/instance.json. Nested keys are joined with _, flags take precedence. Read again on reload.").Default("").String()
+	hostLabelsAllSeries = kingpin.Flag("host.labels-on-all-series", "Attach the labels of the host to all series, unless they have them already, instead of only to node_host_info.").Default("false").Bool()
+	invalidLabelCharsRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// hostInfo exposes the identity of the host and its configured labels in
+// node_host_info, and optionally attaches the labels to all series.
+type hostInfo struct {
+	identity     collector.HostIdentity
+	flagLabels   []string
+	metadataFile string
+	allSeries    bool
+
+	mtx    sync.RWMutex
+	labels map[string]string
+}
+
+func newHostInfo(identity collector.HostIdentity, flagLabels []string, metadataFile string, allSeries bool) (*hostInfo, error) {
+	h := &hostInfo{
+		identity:     identity,
+		flagLabels:   flagLabels,
+		metadataFile: metadataFile,
+		allSeries:    allSeries,
+	}
+	return h, h.reload()
+}
+
+// reload reads the metadata file again.
+func (h *hostInfo) reload() error {
+	labels := map[string]string{}
+	if h.metadataFile != "" {
+		b, err := os.ReadFile(h.metadataFile)
+		if err != nil {
+			return err
+		}
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(b, &metadata); err != nil {
+			return fmt.Errorf("couldn't parse host metadata file %s: %w", h.metadataFile, err)
+		}
+		flattenMetadata(labels, "", metadata)
+	}
+	for _, l := range h.flagLabels {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok || !labelNameRE.MatchString(name) {
+			return fmt.Errorf("invalid host label %q, want name=value", l)
+		}
+		labels[name] = value
+	}
+	for name := range labels {
+		if strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid host label %q, names starting with __ are reserved", name)
+		}
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.labels = labels
+	return nil
+}
+
+// flattenMetadata adds the scalar values of metadata to labels, the keys of
+// nested objects are joined with _. Arrays are ignored.
+func flattenMetadata(labels map[string]string, prefix string, metadata map[string]interface{}) {
+	for k, v := range metadata {
+		name := prefix + invalidLabelCharsRE.ReplaceAllString(k, "_")
+		if !labelNameRE.MatchString(name) {
+			// Label names can't start with a digit.
+			name = "_" + name
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenMetadata(labels, name+"_", v)
+		case string:
+			labels[name] = v
+		case float64, bool:
+			labels[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector. The labels of node_host_info
+// change on reloads, it is an unchecked collector.
+func (h *hostInfo) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (h *hostInfo) Collect(ch chan<- prometheus.Metric) {
+	labels := map[string]string{
+		"hostname":       h.identity.Hostname,
+		"machine_id":     h.identity.MachineID,
+		"product_serial": h.identity.DMISerial,
+	}
+	h.mtx.RLock()
+	for name, value := range h.labels {
+		labels[name] = value
+	}
+	h.mtx.RUnlock()
+
+	names := make([]string, 0, len(labels))
+	values := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values = append(values, labels[name])
+	}
+	desc := prometheus.NewDesc(
+		"node_host_info",
+		"Identity and labels of the host, for joins. The identity is read from the hostname, the machine-id and the DMI product serial.",
+		names, nil,
+	)
+	m, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+	if err != nil {
+		m = prometheus.NewInvalidMetric(desc, err)
+	}
+	ch <- m
+}
+
+// gatherer attaches the labels of the host to the series gathered from g,
+// if they are configured to be on all series.
+func (h *hostInfo) gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	if !h.allSeries {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		h.mtx.RLock()
+		defer h.mtx.RUnlock()
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				present := make(map[string]bool, len(m.Label))
+				for _, l := range m.Label {
+					present[l.GetName()] = true
+				}
+				added := false
+				for name, value := range h.labels {
+					if !present[name] && value != "" {
+						m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+						added = true
+					}
+				}
+				if added {
+					sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+				}
+			}
+		}
+		return mfs, err
+	})
+}
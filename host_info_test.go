@@ -0,0 +1,106 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/node_exporter/collector"
+)
+
+func TestHostInfo(t *testing.T) {
+	metadata := filepath.Join(t.TempDir(), "instance.json")
+	if err := os.WriteFile(metadata, []byte(`{"instance-id": "i-42", "rack": 7, "location": {"dc": "fra1", "zone": "b"}, "tags": ["a"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	identity := collector.HostIdentity{Hostname: "node1", MachineID: "0123", DMISerial: "SN-42"}
+
+	h, err := newHostInfo(identity, []string{"zone=override"}, metadata, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := prometheus.NewRegistry()
+	r.MustRegister(h)
+	want := `# HELP node_host_info Identity and labels of the host, for joins. The identity is read from the hostname, the machine-id and the DMI product serial.
+# TYPE node_host_info gauge
+node_host_info{hostname="node1",instance_id="i-42",location_dc="fra1",location_zone="b",machine_id="0123",product_serial="SN-42",rack="7",zone="override"} 1
+`
+	if err := testutil.GatherAndCompare(r, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// The metadata file is read again on reloads.
+	if err := os.WriteFile(metadata, []byte(`{"rack": "8"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reload(); err != nil {
+		t.Fatal(err)
+	}
+	want = strings.Replace(want, `instance_id="i-42",location_dc="fra1",location_zone="b",`, "", 1)
+	want = strings.Replace(want, `rack="7"`, `rack="8"`, 1)
+	if err := testutil.GatherAndCompare(r, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// Series get the labels they don't have yet if configured.
+	h, err = newHostInfo(identity, []string{"rack=r1", "cpu=all"}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = prometheus.NewRegistry()
+	cpu := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "node_cpu_seconds_total", Help: "CPU."}, []string{"cpu"})
+	cpu.WithLabelValues("0").Add(1)
+	r.MustRegister(cpu)
+	want = `# HELP node_cpu_seconds_total CPU.
+# TYPE node_cpu_seconds_total counter
+node_cpu_seconds_total{cpu="0",rack="r1"} 1
+`
+	if err := testutil.GatherAndCompare(h.gatherer(r), strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := newHostInfo(identity, []string{"invalid-label=x"}, "", false); err == nil {
+		t.Error("want error for invalid label name")
+	}
+	if _, err := newHostInfo(identity, []string{"__reserved=x"}, "", false); err == nil {
+		t.Error("want error for reserved label name")
+	}
+
+	// Reserved names from the metadata file fail the reload, the previous
+	// labels are kept.
+	h, err = newHostInfo(identity, nil, metadata, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(metadata, []byte(`{"__meta": "x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.reload(); err == nil {
+		t.Error("want error for reserved label name in metadata file")
+	}
+	r = prometheus.NewRegistry()
+	r.MustRegister(h)
+	want = `# HELP node_host_info Identity and labels of the host, for joins. The identity is read from the hostname, the machine-id and the DMI product serial.
+# TYPE node_host_info gauge
+node_host_info{hostname="node1",machine_id="0123",product_serial="SN-42",rack="8"} 1
+`
+	if err := testutil.GatherAndCompare(r, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
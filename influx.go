@@ -0,0 +1,150 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+// influxUDPPayload keeps the UDP packets within the usual MTU.
+const influxUDPPayload = 1400
+
+var (
+	influxURL     = kingpin.Flag("influx.url", "InfluxDB write URL to push the metrics to in line protocol, e.g. http://influxdb:8086/api/v2/write?org=org&bucket=node or udp://influxdb:8089. Timestamps are in nanoseconds. Disabled if empty.").Default("").String()
+	influxHeaders = kingpin.Flag("influx.header", "Header added to HTTP write requests as name=value, e.g. for authentication. Can be repeated.").Strings()
+	influxFlags   = registerSinkFlags("influx")
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// newInfluxSink returns a sink writing Influx line protocol to an HTTP or
+// UDP URL. Each point is a line, with the value of counters and gauges in
+// the "value" field, and the count, sum and buckets or quantiles of
+// histograms and summaries in their own fields.
+func newInfluxSink(rawURL string, headers []string, cfg sinkConfig, g prometheus.Gatherer, logger log.Logger) (*pushSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	s := newPushSink("influx", cfg, g, logger)
+	s.encode = func(points []sinkPoint) [][]byte { return influxLines(cfg.prefix, points) }
+
+	switch u.Scheme {
+	case "http", "https":
+		header := http.Header{}
+		for _, h := range headers {
+			name, value, ok := strings.Cut(h, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid header %q, want name=value", h)
+			}
+			header.Add(name, value)
+		}
+		s.write = func(ctx context.Context, lines [][]byte) error {
+			return influxWriteHTTP(ctx, rawURL, header, lines)
+		}
+	case "udp":
+		s.write = func(ctx context.Context, lines [][]byte) error {
+			return influxWriteUDP(ctx, u.Host, lines)
+		}
+	default:
+		return nil, fmt.Errorf("invalid Influx URL %q, want an http, https or udp URL", rawURL)
+	}
+	return s, nil
+}
+
+func influxLines(prefix string, points []sinkPoint) [][]byte {
+	lines := make([][]byte, 0, len(points))
+	for _, p := range points {
+		var b bytes.Buffer
+		b.WriteString(influxMeasurementEscaper.Replace(prefix + p.name))
+		for _, t := range p.tags {
+			b.WriteString("," + influxKeyEscaper.Replace(t[0]) + "=" + influxKeyEscaper.Replace(t[1]))
+		}
+		for i, f := range p.fields {
+			if i == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(influxKeyEscaper.Replace(f.key) + "=" + strconv.FormatFloat(f.value, 'g', -1, 64))
+		}
+		b.WriteString(" " + strconv.FormatInt(p.time.UnixNano(), 10) + "\n")
+		lines = append(lines, b.Bytes())
+	}
+	return lines
+}
+
+func influxWriteHTTP(ctx context.Context, url string, header http.Header, lines [][]byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "node_exporter/"+version.Version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// influxWriteUDP sends the lines in as few packets as possible. Lines
+// larger than a packet are sent on their own.
+func influxWriteUDP(ctx context.Context, address string, lines [][]byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var packet []byte
+	for _, l := range lines {
+		if len(packet) > 0 && len(packet)+len(l) > influxUDPPayload {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		packet = append(packet, l...)
+	}
+	_, err = conn.Write(packet)
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInfluxSink(t *testing.T) {
+	r, _ := newSinkTestRegistry()
+	cfg := sinkConfig{timeout: time.Second, prefix: "host ", tags: map[string]string{"mode": "cpu mode"}}
+	want := `host\ node_cpu_seconds_total,cpu=0,cpu\ mode=idle value=3 1000000000000
+host\ node_load1 value=0.5 1000000000000
+host\ test_duration_seconds count=2,sum=2.5,1=1,+Inf=2 1000000000000
+`
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "Token secret", r.Header.Get("Authorization"); want != got {
+			t.Errorf("want Authorization %q, got %q", want, got)
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s, err := newInfluxSink(server.URL+"/api/v2/write?bucket=node", []string{"Authorization=Token secret"}, cfg, r, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Unix(1000, 0) }
+	if err := s.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body != want {
+		t.Errorf("want %q, got %q", want, body)
+	}
+	if want, got := 3.0, testutil.ToFloat64(s.sent); want != got {
+		t.Errorf("want %g lines sent, got %g", want, got)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err = newInfluxSink("udp://"+conn.LocalAddr().String(), nil, cfg, r, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Unix(1000, 0) }
+	if err := s.push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet := make([]byte, influxUDPPayload)
+	n, _, err := conn.ReadFrom(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(packet[:n]); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, err := newInfluxSink("tcp://localhost:8089", nil, cfg, r, log.NewNopLogger()); err == nil || !strings.Contains(err.Error(), "udp") {
+		t.Errorf("want error for unsupported scheme, got %v", err)
+	}
+}
@@ -14,14 +14,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	stdlog "log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"os/user"
 	"runtime"
 	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/common/promlog"
 	"github.com/prometheus/common/promlog/flag"
@@ -32,6 +38,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	promcollectors "github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/version"
 	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/prometheus/exporter-toolkit/web/kingpinflag"
@@ -39,51 +46,87 @@ import (
 )
 
 // handler wraps an unfiltered http.Handler but uses a filtered handler,
-// created on the fly, if filtering is requested. Create instances with
-// newHandler.
+// created on the fly, if filtering is requested or the scrape has a timeout.
+// Create instances with newHandler.
 type handler struct {
+	mtx               sync.RWMutex
 	unfilteredHandler http.Handler
+	// unfilteredGatherer gathers the metrics of the unfiltered handler for
+	// the push sinks.
+	unfilteredGatherer prometheus.Gatherer
 	// exporterMetricsRegistry is a separate registry for the metrics about
 	// the exporter itself.
 	exporterMetricsRegistry *prometheus.Registry
 	includeExporterMetrics  bool
 	maxRequests             int
-	logger                  log.Logger
+	// inFlight limits the concurrent requests across the unfiltered and the
+	// on the fly handlers.
+	inFlight      chan struct{}
+	timeoutOffset time.Duration
+	// coalescer is set if scrapes share their collections.
+	coalescer *scrapeCoalescer
+	hostInfo  *hostInfo
+	logger    log.Logger
 }
 
-func newHandler(includeExporterMetrics bool, maxRequests int, logger log.Logger) *handler {
+func newHandler(includeExporterMetrics bool, maxRequests int, timeoutOffset, coalesceWindow time.Duration, hostInfo *hostInfo, logger log.Logger) *handler {
 	h := &handler{
 		exporterMetricsRegistry: prometheus.NewRegistry(),
 		includeExporterMetrics:  includeExporterMetrics,
 		maxRequests:             maxRequests,
+		timeoutOffset:           timeoutOffset,
+		hostInfo:                hostInfo,
 		logger:                  logger,
 	}
+	if maxRequests > 0 {
+		h.inFlight = make(chan struct{}, maxRequests)
+	}
+	if coalesceWindow > 0 {
+		h.coalescer = newScrapeCoalescer(coalesceWindow, logger)
+	}
 	if h.includeExporterMetrics {
 		h.exporterMetricsRegistry.MustRegister(
 			promcollectors.NewProcessCollector(promcollectors.ProcessCollectorOpts{}),
 			promcollectors.NewGoCollector(),
 		)
 	}
-	if innerHandler, err := h.innerHandler(); err != nil {
+	if err := h.reload(); err != nil {
 		panic(fmt.Sprintf("Couldn't create metrics handler: %s", err))
-	} else {
-		h.unfilteredHandler = innerHandler
 	}
 	return h
 }
 
 // ServeHTTP implements http.Handler.
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	filters := r.URL.Query()["collect[]"]
-	level.Debug(h.logger).Log("msg", "collect query:", "filters", filters)
+	if h.inFlight != nil {
+		select {
+		case h.inFlight <- struct{}{}:
+			defer func() { <-h.inFlight }()
+		default:
+			http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", h.maxRequests), http.StatusServiceUnavailable)
+			return
+		}
+	}
 
-	if len(filters) == 0 {
+	filters, err := parseScrapeFilters(r.URL.Query())
+	if err != nil {
+		level.Warn(h.logger).Log("msg", "Couldn't parse filters:", "err", err)
+		http.Error(w, fmt.Sprintf("Couldn't parse filters: %s", err), http.StatusBadRequest)
+		return
+	}
+	level.Debug(h.logger).Log("msg", "collect query:", "filters", filters.collect, "exclude", filters.exclude)
+	timeout := h.scrapeTimeout(r)
+
+	if filters.empty() && timeout == 0 {
 		// No filters, use the prepared unfiltered handler.
-		h.unfilteredHandler.ServeHTTP(w, r)
+		h.mtx.RLock()
+		unfilteredHandler := h.unfilteredHandler
+		h.mtx.RUnlock()
+		unfilteredHandler.ServeHTTP(w, r)
 		return
 	}
 	// To serve filtered metrics, we create a filtering handler on the fly.
-	filteredHandler, err := h.innerHandler(filters...)
+	filteredHandler, err := h.innerHandler(timeout, filters)
 	if err != nil {
 		level.Warn(h.logger).Log("msg", "Couldn't create filtered metrics handler:", "err", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -93,20 +136,73 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	filteredHandler.ServeHTTP(w, r)
 }
 
-// innerHandler is used to create both the one unfiltered http.Handler to be
-// wrapped by the outer handler and also the filtered handlers created on the
-// fly. The former is accomplished by calling innerHandler without any arguments
-// (in which case it will log all the collectors enabled via command-line
-// flags).
-func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
-	nc, err := collector.NewNodeCollector(h.logger, filters...)
+// scrapeTimeout returns the time the collectors may take from the timeout
+// Prometheus sends with each scrape, less the offset left for encoding and
+// sending the response. Zero means the scrape has no timeout.
+func (h *handler) scrapeTimeout(r *http.Request) time.Duration {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		level.Debug(h.logger).Log("msg", "Ignoring invalid scrape timeout", "timeout", v)
+		return 0
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > h.timeoutOffset {
+		timeout -= h.timeoutOffset
+	}
+	return timeout
+}
+
+// reload creates the unfiltered handler, again after the set of enabled
+// collectors changed.
+func (h *handler) reload() error {
+	gatherer, err := h.innerGatherer(0, scrapeFilters{})
+	if err != nil {
+		return err
+	}
+	innerHandler := h.handlerFor(scrapeFilters{}, gatherer)
+	h.mtx.Lock()
+	h.unfilteredHandler = innerHandler
+	h.unfilteredGatherer = gatherer
+	h.mtx.Unlock()
+	return nil
+}
+
+// Gather implements prometheus.Gatherer for the push sinks, it gathers the
+// metrics served by the unfiltered handler.
+func (h *handler) Gather() ([]*dto.MetricFamily, error) {
+	h.mtx.RLock()
+	gatherer := h.unfilteredGatherer
+	h.mtx.RUnlock()
+	return gatherer.Gather()
+}
+
+// innerHandler creates the filtered handlers on the fly.
+func (h *handler) innerHandler(timeout time.Duration, filters scrapeFilters) (http.Handler, error) {
+	gatherer, err := h.innerGatherer(timeout, filters)
+	if err != nil {
+		return nil, err
+	}
+	return h.handlerFor(filters, gatherer), nil
+}
+
+// innerGatherer is used to create both the one unfiltered gatherer and also
+// the filtered ones created on the fly. The former is accomplished by calling
+// innerGatherer without a timeout and filters (in which case it will log all
+// the collectors enabled via command-line flags).
+func (h *handler) innerGatherer(timeout time.Duration, filters scrapeFilters) (prometheus.Gatherer, error) {
+	nc, err := collector.NewFilteredNodeCollector(h.logger, filters.collect, filters.exclude)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't create collector: %s", err)
 	}
+	nc.Timeout = timeout
 
-	// Only log the creation of an unfiltered handler, which should happen
-	// only once upon startup.
-	if len(filters) == 0 {
+	// Only log the creation of an unfiltered handler, which happens upon
+	// startup and configuration reloads.
+	if filters.empty() && timeout == 0 {
 		level.Info(h.logger).Log("msg", "Enabled collectors")
 		collectors := []string{}
 		for n := range nc.Collectors {
@@ -119,19 +215,31 @@ func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
 	}
 
 	r := prometheus.NewRegistry()
-	r.MustRegister(version.NewCollector("node_exporter"))
+	r.MustRegister(version.NewCollector("node_exporter"), collector.CommandMetrics, collector.CollectorErrors, h.hostInfo)
 	if err := r.Register(nc); err != nil {
 		return nil, fmt.Errorf("couldn't register node collector: %s", err)
 	}
-	handler := promhttp.HandlerFor(
-		prometheus.Gatherers{h.exporterMetricsRegistry, r},
-		promhttp.HandlerOpts{
-			ErrorLog:            stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0),
-			ErrorHandling:       promhttp.ContinueOnError,
-			MaxRequestsInFlight: h.maxRequests,
-			Registry:            h.exporterMetricsRegistry,
-		},
-	)
+	// The labels of the host are attached first, so they can be filtered.
+	return filters.gatherer(h.hostInfo.gatherer(prometheus.Gatherers{h.exporterMetricsRegistry, r})), nil
+}
+
+// handlerFor returns the http.Handler serving the metrics of gatherer.
+func (h *handler) handlerFor(filters scrapeFilters, gatherer prometheus.Gatherer) http.Handler {
+	var handler http.Handler
+	if h.coalescer != nil {
+		// The filtered handlers are created for each request, their
+		// collections are shared through the coalescer.
+		handler = h.coalescer.handler(filters.key(), gatherer)
+	} else {
+		handler = promhttp.HandlerFor(
+			gatherer,
+			promhttp.HandlerOpts{
+				ErrorLog:      stdlog.New(log.NewStdlibAdapter(level.Error(h.logger)), "", 0),
+				ErrorHandling: promhttp.ContinueOnError,
+				Registry:      h.exporterMetricsRegistry,
+			},
+		)
+	}
 	if h.includeExporterMetrics {
 		// Note that we have to use h.exporterMetricsRegistry here to
 		// use the same promhttp metrics for all expositions.
@@ -139,7 +247,7 @@ func (h *handler) innerHandler(filters ...string) (http.Handler, error) {
 			h.exporterMetricsRegistry, handler,
 		)
 	}
-	return handler, nil
+	return handler
 }
 
 func main() {
@@ -156,10 +264,22 @@ func main() {
 			"web.max-requests",
 			"Maximum number of parallel scrape requests. Use 0 to disable.",
 		).Default("40").Int()
+		scrapeTimeoutOffset = kingpin.Flag(
+			"web.scrape-timeout-offset",
+			"Time subtracted from the scrape timeout sent by Prometheus to leave for encoding and sending the metrics.",
+		).Default("500ms").Duration()
+		coalesceWindow = kingpin.Flag(
+			"web.coalesce-window",
+			"Scrapes with the same collect[] filters arriving within this window share one collection and its encoded response. Use 0 to disable.",
+		).Default("0s").Duration()
 		disableDefaultCollectors = kingpin.Flag(
 			"collector.disable-defaults",
 			"Set all collectors to disabled by default.",
 		).Default("false").Bool()
+		configFile = kingpin.Flag(
+			"config.file",
+			"Path to a YAML file setting the enable state and options of the collectors, reloaded on SIGHUP or POST /-/reload. Command line flags take precedence.",
+		).Default("").String()
 		maxProcs = kingpin.Flag(
 			"runtime.gomaxprocs", "The target number of CPUs Go will run on (GOMAXPROCS)",
 		).Envar("GOMAXPROCS").Default("1").Int()
@@ -177,6 +297,14 @@ func main() {
 	if *disableDefaultCollectors {
 		collector.DisableDefaultCollectors()
 	}
+	var configLoader *collector.ConfigLoader
+	if *configFile != "" {
+		configLoader = collector.NewConfigLoader(*configFile, explicitFlags(), logger)
+		if err := configLoader.Reload(); err != nil {
+			level.Error(logger).Log("msg", "Error loading config", "err", err)
+			os.Exit(1)
+		}
+	}
 	level.Info(logger).Log("msg", "Starting node_exporter", "version", version.Info())
 	level.Info(logger).Log("msg", "Build context", "build_context", version.BuildContext())
 	if user, err := user.Current(); err == nil && user.Uid == "0" {
@@ -185,7 +313,110 @@ func main() {
 	runtime.GOMAXPROCS(*maxProcs)
 	level.Debug(logger).Log("msg", "Go MAXPROCS", "procs", runtime.GOMAXPROCS(0))
 
-	http.Handle(*metricsPath, newHandler(!*disableExporterMetrics, *maxRequests, logger))
+	hostIdentity := collector.ReadHostIdentity()
+	hostInfo, err := newHostInfo(hostIdentity, *hostLabels, *hostMetadataFile, *hostLabelsAllSeries)
+	if err != nil {
+		level.Error(logger).Log("msg", "Couldn't read host labels", "err", err)
+		os.Exit(1)
+	}
+	metricsHandler := newHandler(!*disableExporterMetrics, *maxRequests, *scrapeTimeoutOffset, *coalesceWindow, hostInfo, logger)
+	http.Handle(*metricsPath, metricsHandler)
+	if configLoader != nil || *hostMetadataFile != "" {
+		var reloadMtx sync.Mutex
+		reload := func() error {
+			reloadMtx.Lock()
+			defer reloadMtx.Unlock()
+			if configLoader != nil {
+				if err := configLoader.Reload(); err != nil {
+					return err
+				}
+			}
+			if err := hostInfo.reload(); err != nil {
+				return err
+			}
+			return metricsHandler.reload()
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := reload(); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				}
+			}
+		}()
+		http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+				return
+			}
+			if err := reload(); err != nil {
+				level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				http.Error(w, fmt.Sprintf("Failed to reload config: %s", err), http.StatusInternalServerError)
+			}
+		})
+	}
+	if *remoteWriteURL != "" {
+		cfg, err := remoteWriteConfigFromFlags()
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid remote write configuration", "err", err)
+			os.Exit(1)
+		}
+		writer, err := newRemoteWriter(cfg, metricsHandler, log.With(logger, "component", "remote_write"))
+		if err != nil {
+			level.Error(logger).Log("msg", "Couldn't create remote writer", "err", err)
+			os.Exit(1)
+		}
+		metricsHandler.exporterMetricsRegistry.MustRegister(writer)
+		go writer.run(context.Background())
+	}
+	if *otlpEndpoint != "" {
+		cfg, err := otlpConfigFromFlags(hostIdentity)
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid OTLP configuration", "err", err)
+			os.Exit(1)
+		}
+		exporter, err := newOTLPExporter(cfg, metricsHandler, log.With(logger, "component", "otlp"))
+		if err != nil {
+			level.Error(logger).Log("msg", "Couldn't create OTLP exporter", "err", err)
+			os.Exit(1)
+		}
+		metricsHandler.exporterMetricsRegistry.MustRegister(exporter)
+		go exporter.run(context.Background())
+	}
+	if *influxURL != "" {
+		cfg, err := influxFlags.config()
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid Influx configuration", "err", err)
+			os.Exit(1)
+		}
+		sink, err := newInfluxSink(*influxURL, *influxHeaders, cfg, metricsHandler, log.With(logger, "component", "influx"))
+		if err != nil {
+			level.Error(logger).Log("msg", "Couldn't create Influx sink", "err", err)
+			os.Exit(1)
+		}
+		metricsHandler.exporterMetricsRegistry.MustRegister(sink)
+		go sink.run(context.Background())
+	}
+	if *graphiteAddress != "" {
+		cfg, err := graphiteFlags.config()
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid Graphite configuration", "err", err)
+			os.Exit(1)
+		}
+		sink := newGraphiteSink(*graphiteAddress, cfg, metricsHandler, log.With(logger, "component", "graphite"))
+		metricsHandler.exporterMetricsRegistry.MustRegister(sink)
+		go sink.run(context.Background())
+	}
+	ready, err := newReadiness(*criticalCollectors, *readyMaxFailures)
+	if err != nil {
+		level.Error(logger).Log("msg", "Invalid readiness configuration", "err", err)
+		os.Exit(1)
+	}
+	http.HandleFunc("/-/healthy", healthyHandler)
+	http.HandleFunc("/-/ready", ready.readyHandler)
+	http.HandleFunc("/api/v1/status", ready.statusHandler(logger))
 	if *metricsPath != "/" {
 		landingConfig := web.LandingConfig{
 			Name:        "Node Exporter",
@@ -196,6 +427,10 @@ func main() {
 					Address: *metricsPath,
 					Text:    "Metrics",
 				},
+				{
+					Address: "/api/v1/status",
+					Text:    "Collector status",
+				},
 			},
 		}
 		landingPage, err := web.NewLandingPage(landingConfig)
@@ -212,3 +447,19 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// explicitFlags returns the names of the flags set on the command line, they
+// take precedence over the configuration file.
+func explicitFlags() map[string]bool {
+	flags := map[string]bool{}
+	ctx, err := kingpin.CommandLine.ParseContext(os.Args[1:])
+	if err != nil {
+		return flags
+	}
+	for _, e := range ctx.Elements {
+		if f, ok := e.Clause.(*kingpin.FlagClause); ok {
+			flags[f.Model().Name] = true
+		}
+	}
+	return flags
+}
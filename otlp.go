@@ -0,0 +1,433 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/node_exporter/collector"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	otlpProtocolHTTP = "http/protobuf"
+	otlpProtocolGRPC = "grpc"
+
+	otlpGRPCMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+)
+
+var (
+	otlpEndpoint           = kingpin.Flag("otlp.endpoint", "OTLP endpoint to push the metrics to, e.g. http://collector:4318/v1/metrics with OTLP/HTTP or http://collector:4317 with OTLP/gRPC. Disabled if empty.").Default("").String()
+	otlpProtocol           = kingpin.Flag("otlp.protocol", "OTLP transport protocol, one of [http/protobuf, grpc].").Default(otlpProtocolHTTP).Enum(otlpProtocolHTTP, otlpProtocolGRPC)
+	otlpInterval           = kingpin.Flag("otlp.interval", "Interval between two exports of the metrics.").Default("15s").Duration()
+	otlpTimeout            = kingpin.Flag("otlp.timeout", "Timeout of an export request.").Default("10s").Duration()
+	otlpHeaders            = kingpin.Flag("otlp.header", "Header added to export requests as name=value, e.g. for authentication. Can be repeated.").Strings()
+	otlpResourceAttributes = kingpin.Flag("otlp.resource-attribute", "Resource attribute added to the exported metrics as key=value, overriding the detected ones. Can be repeated.").Strings()
+)
+
+// otlpConfig is the configuration of an otlpExporter.
+type otlpConfig struct {
+	endpoint string
+	protocol string
+	interval time.Duration
+	timeout  time.Duration
+	headers  http.Header
+	resource map[string]string
+}
+
+// otlpConfigFromFlags returns the configuration from the flags, the
+// resource identifies the host by its name, machine-id and DMI serial.
+func otlpConfigFromFlags(id collector.HostIdentity) (otlpConfig, error) {
+	cfg := otlpConfig{
+		endpoint: *otlpEndpoint,
+		protocol: *otlpProtocol,
+		interval: *otlpInterval,
+		timeout:  *otlpTimeout,
+		headers:  http.Header{},
+		resource: map[string]string{
+			"service.name":    "node_exporter",
+			"service.version": version.Version,
+		},
+	}
+	for k, v := range map[string]string{
+		"host.name":   id.Hostname,
+		"host.id":     id.MachineID,
+		"host.serial": id.DMISerial,
+	} {
+		if v != "" {
+			cfg.resource[k] = v
+		}
+	}
+	for _, h := range *otlpHeaders {
+		name, value, ok := strings.Cut(h, "=")
+		if !ok || name == "" {
+			return cfg, fmt.Errorf("invalid header %q, want name=value", h)
+		}
+		cfg.headers.Add(name, value)
+	}
+	for _, a := range *otlpResourceAttributes {
+		key, value, ok := strings.Cut(a, "=")
+		if !ok || key == "" {
+			return cfg, fmt.Errorf("invalid resource attribute %q, want key=value", a)
+		}
+		cfg.resource[key] = value
+	}
+	return cfg, nil
+}
+
+// otlpExporter periodically gathers the metrics and exports them to an
+// OpenTelemetry collector. Failed exports aren't retried, the next one
+// carries the cumulative values again.
+type otlpExporter struct {
+	cfg      otlpConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   log.Logger
+	// start is the start time of the cumulative data points.
+	start time.Time
+	now   func() time.Time
+
+	exported prometheus.Counter
+	failed   prometheus.Counter
+}
+
+func newOTLPExporter(cfg otlpConfig, g prometheus.Gatherer, logger log.Logger) (*otlpExporter, error) {
+	u, err := url.Parse(cfg.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, want an http or https URL", cfg.endpoint)
+	}
+	client := &http.Client{Timeout: cfg.timeout}
+	if cfg.protocol == otlpProtocolGRPC {
+		t := &http2.Transport{}
+		if u.Scheme == "http" {
+			// gRPC without TLS speaks HTTP/2 with prior knowledge.
+			t.AllowHTTP = true
+			t.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			}
+		}
+		client.Transport = t
+	}
+
+	now := time.Now()
+	return &otlpExporter{
+		cfg:      cfg,
+		gatherer: g,
+		client:   client,
+		logger:   logger,
+		start:    now,
+		now:      time.Now,
+		exported: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "node_exporter",
+			Name:      "otlp_data_points_exported_total",
+			Help:      "Number of data points exported to the OTLP endpoint.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "node_exporter",
+			Name:      "otlp_data_points_failed_total",
+			Help:      "Number of data points that failed to be exported to the OTLP endpoint.",
+		}),
+	}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (e *otlpExporter) Describe(ch chan<- *prometheus.Desc) {
+	e.exported.Describe(ch)
+	e.failed.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (e *otlpExporter) Collect(ch chan<- prometheus.Metric) {
+	e.exported.Collect(ch)
+	e.failed.Collect(ch)
+}
+
+// run exports the metrics every interval until ctx is done.
+func (e *otlpExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.interval)
+	defer ticker.Stop()
+	for {
+		if err := e.export(ctx); err != nil {
+			level.Error(e.logger).Log("msg", "Failed to export metrics", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// export gathers and exports the metrics once.
+func (e *otlpExporter) export(ctx context.Context) error {
+	mfs, err := e.gatherer.Gather()
+	if err != nil {
+		// Like the HTTP handler, export what was gathered.
+		level.Warn(e.logger).Log("msg", "Error gathering metrics for OTLP export", "err", err)
+	}
+	req, points := encodeExportRequest(mfs, e.cfg.resource, e.start, e.now())
+	if points == 0 {
+		return nil
+	}
+	if e.cfg.protocol == otlpProtocolGRPC {
+		err = e.sendGRPC(ctx, req)
+	} else {
+		err = e.sendHTTP(ctx, req)
+	}
+	if err != nil {
+		e.failed.Add(float64(points))
+		return err
+	}
+	e.exported.Add(float64(points))
+	return nil
+}
+
+func (e *otlpExporter) newRequest(ctx context.Context, url, contentType string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range e.cfg.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "node_exporter/"+version.Version)
+	return req, nil
+}
+
+func (e *otlpExporter) sendHTTP(ctx context.Context, body []byte) error {
+	req, err := e.newRequest(ctx, e.cfg.endpoint, "application/x-protobuf", body)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// sendGRPC makes a unary gRPC call, i.e. sends the length prefixed message
+// and reads the status from the trailers.
+func (e *otlpExporter) sendGRPC(ctx context.Context, msg []byte) error {
+	n := len(msg)
+	body := append([]byte{0, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, msg...)
+	req, err := e.newRequest(ctx, strings.TrimSuffix(e.cfg.endpoint, "/")+otlpGRPCMethod, "application/grpc", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Grpc-Timeout", fmt.Sprintf("%dm", e.cfg.timeout.Milliseconds()))
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The trailers are only available after the body was read.
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		// Errors without a body are sent in the headers.
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if m, err := url.PathUnescape(message); err == nil {
+			message = m
+		}
+		return fmt.Errorf("server returned gRPC status %q: %s", status, message)
+	}
+	return nil
+}
+
+// encodeExportRequest returns the ExportMetricsServiceRequest protobuf of
+// the metric families and its number of data points. Counters are
+// cumulative sums, untyped metrics are gauges.
+func encodeExportRequest(mfs []*dto.MetricFamily, resource map[string]string, start, now time.Time) ([]byte, int) {
+	points := 0
+	var scope []byte
+	scope = appendProtoString(scope, 1, "github.com/prometheus/node_exporter")
+	scope = appendProtoString(scope, 2, version.Version)
+	var scopeMetrics []byte
+	scopeMetrics = appendProtoMessage(scopeMetrics, 1, scope)
+
+	startNano := uint64(start.UnixNano())
+	for _, mf := range mfs {
+		var data []byte
+		for _, m := range mf.Metric {
+			ts := uint64(now.UnixNano())
+			if m.TimestampMs != nil {
+				ts = uint64(m.GetTimestampMs()) * uint64(time.Millisecond)
+			}
+			var p []byte
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				p = appendOTLPAttributes(p, 7, m.GetLabel())
+				p = appendProtoFixed64(p, 2, startNano)
+				p = appendProtoFixed64(p, 3, ts)
+				p = appendProtoFixed64(p, 4, math.Float64bits(m.GetCounter().GetValue()))
+			case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+				v := m.GetGauge().GetValue()
+				if mf.GetType() == dto.MetricType_UNTYPED {
+					v = m.GetUntyped().GetValue()
+				}
+				p = appendOTLPAttributes(p, 7, m.GetLabel())
+				p = appendProtoFixed64(p, 3, ts)
+				p = appendProtoFixed64(p, 4, math.Float64bits(v))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				// OTLP counts each bucket separately, the last one is
+				// unbounded.
+				var bounds, counts []byte
+				prev := uint64(0)
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					bounds = protowire.AppendFixed64(bounds, math.Float64bits(b.GetUpperBound()))
+					counts = protowire.AppendFixed64(counts, b.GetCumulativeCount()-prev)
+					prev = b.GetCumulativeCount()
+				}
+				counts = protowire.AppendFixed64(counts, h.GetSampleCount()-prev)
+				p = appendOTLPAttributes(p, 9, m.GetLabel())
+				p = appendProtoFixed64(p, 2, startNano)
+				p = appendProtoFixed64(p, 3, ts)
+				p = appendProtoFixed64(p, 4, h.GetSampleCount())
+				p = appendProtoFixed64(p, 5, math.Float64bits(h.GetSampleSum()))
+				p = appendProtoMessage(p, 6, counts)
+				if len(bounds) > 0 {
+					p = appendProtoMessage(p, 7, bounds)
+				}
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				p = appendOTLPAttributes(p, 7, m.GetLabel())
+				p = appendProtoFixed64(p, 2, startNano)
+				p = appendProtoFixed64(p, 3, ts)
+				p = appendProtoFixed64(p, 4, s.GetSampleCount())
+				p = appendProtoFixed64(p, 5, math.Float64bits(s.GetSampleSum()))
+				for _, q := range s.GetQuantile() {
+					var vq []byte
+					vq = appendProtoFixed64(vq, 1, math.Float64bits(q.GetQuantile()))
+					vq = appendProtoFixed64(vq, 2, math.Float64bits(q.GetValue()))
+					p = appendProtoMessage(p, 6, vq)
+				}
+			default:
+				continue
+			}
+			data = appendProtoMessage(data, 1, p)
+			points++
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		var metric []byte
+		metric = appendProtoString(metric, 1, mf.GetName())
+		metric = appendProtoString(metric, 2, mf.GetHelp())
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			data = protowire.AppendTag(data, 2, protowire.VarintType)
+			data = protowire.AppendVarint(data, 2) // AGGREGATION_TEMPORALITY_CUMULATIVE
+			data = protowire.AppendTag(data, 3, protowire.VarintType)
+			data = protowire.AppendVarint(data, 1) // is_monotonic
+			metric = appendProtoMessage(metric, 7, data)
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			metric = appendProtoMessage(metric, 5, data)
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			data = protowire.AppendTag(data, 2, protowire.VarintType)
+			data = protowire.AppendVarint(data, 2)
+			metric = appendProtoMessage(metric, 9, data)
+		case dto.MetricType_SUMMARY:
+			metric = appendProtoMessage(metric, 11, data)
+		}
+		scopeMetrics = appendProtoMessage(scopeMetrics, 2, metric)
+	}
+
+	keys := make([]string, 0, len(resource))
+	for k := range resource {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var res []byte
+	for _, k := range keys {
+		res = appendOTLPAttribute(res, 1, k, resource[k])
+	}
+	var resourceMetrics []byte
+	resourceMetrics = appendProtoMessage(resourceMetrics, 1, res)
+	resourceMetrics = appendProtoMessage(resourceMetrics, 2, scopeMetrics)
+	return appendProtoMessage(nil, 1, resourceMetrics), points
+}
+
+func appendOTLPAttributes(b []byte, num protowire.Number, labels []*dto.LabelPair) []byte {
+	for _, l := range labels {
+		b = appendOTLPAttribute(b, num, l.GetName(), l.GetValue())
+	}
+	return b
+}
+
+// appendOTLPAttribute appends a KeyValue with a string value.
+func appendOTLPAttribute(b []byte, num protowire.Number, key, value string) []byte {
+	var kv, v []byte
+	v = appendProtoString(v, 1, value)
+	kv = appendProtoString(kv, 1, key)
+	kv = appendProtoMessage(kv, 2, v)
+	return appendProtoMessage(b, num, kv)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendProtoFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
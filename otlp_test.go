@@ -0,0 +1,295 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeExportRequest returns the resource attributes of an
+// ExportMetricsServiceRequest and its data points as
+// "kind name{attributes} values", sorted. It checks the timestamps of the
+// data points.
+func decodeExportRequest(t *testing.T, req []byte, start, now time.Time) (map[string]string, []string) {
+	attribute := func(kv []byte) (string, string) {
+		var key, value string
+		forEachProtoField(t, kv, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				key = string(v)
+			case 2:
+				forEachProtoField(t, v, func(_ protowire.Number, v []byte, _ uint64) { value = string(v) })
+			}
+		})
+		return key, value
+	}
+	fixed64s := func(b []byte) []uint64 {
+		var values []uint64
+		for len(b) > 0 {
+			v, n := protowire.ConsumeFixed64(b)
+			values = append(values, v)
+			b = b[n:]
+		}
+		return values
+	}
+
+	resource := map[string]string{}
+	var points []string
+	metric := func(m []byte) {
+		var name, kind string
+		forEachProtoField(t, m, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				name = string(v)
+				return
+			case 5:
+				kind = "gauge"
+			case 7:
+				kind = "sum"
+				forEachProtoField(t, v, func(num protowire.Number, _ []byte, u uint64) {
+					if num == 3 && u == 1 {
+						kind += ",monotonic"
+					}
+				})
+			case 9:
+				kind = "histogram"
+			case 11:
+				kind = "summary"
+			default:
+				return
+			}
+			forEachProtoField(t, v, func(num protowire.Number, v []byte, u uint64) {
+				switch num {
+				case 2:
+					if u != 2 {
+						t.Errorf("%s: want cumulative temporality, got %d", name, u)
+					}
+					return
+				case 3:
+					return
+				}
+				var attrs, values []string
+				var startTime, ts uint64
+				attrsNum := protowire.Number(7)
+				if kind == "histogram" {
+					attrsNum = 9
+				}
+				forEachProtoField(t, v, func(num protowire.Number, v []byte, u uint64) {
+					switch {
+					case num == attrsNum:
+						k, v := attribute(v)
+						attrs = append(attrs, k+"="+v)
+					case num == 2:
+						startTime = u
+					case num == 3:
+						ts = u
+					case num == 4 && kind != "histogram" && kind != "summary":
+						values = append(values, fmt.Sprint(math.Float64frombits(u)))
+					case num == 4:
+						values = append(values, fmt.Sprintf("count=%d", u))
+					case num == 5:
+						values = append(values, fmt.Sprintf("sum=%g", math.Float64frombits(u)))
+					case num == 6 && kind == "histogram":
+						values = append(values, fmt.Sprintf("buckets=%v", fixed64s(v)))
+					case num == 7:
+						var bounds []float64
+						for _, b := range fixed64s(v) {
+							bounds = append(bounds, math.Float64frombits(b))
+						}
+						values = append(values, fmt.Sprintf("bounds=%v", bounds))
+					case num == 6:
+						var q, value float64
+						forEachProtoField(t, v, func(num protowire.Number, _ []byte, u uint64) {
+							if num == 1 {
+								q = math.Float64frombits(u)
+							} else {
+								value = math.Float64frombits(u)
+							}
+						})
+						values = append(values, fmt.Sprintf("q%g=%g", q, value))
+					}
+				})
+				if want := uint64(now.UnixNano()); ts != want {
+					t.Errorf("%s: want time %d, got %d", name, want, ts)
+				}
+				if want := uint64(start.UnixNano()); kind != "gauge" && startTime != want {
+					t.Errorf("%s: want start time %d, got %d", name, want, startTime)
+				}
+				points = append(points, fmt.Sprintf("%s %s{%s} %s", kind, name, strings.Join(attrs, ","), strings.Join(values, " ")))
+			})
+		})
+	}
+
+	forEachProtoField(t, req, func(_ protowire.Number, rm []byte, _ uint64) {
+		forEachProtoField(t, rm, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				forEachProtoField(t, v, func(_ protowire.Number, kv []byte, _ uint64) {
+					k, v := attribute(kv)
+					resource[k] = v
+				})
+			case 2:
+				forEachProtoField(t, v, func(num protowire.Number, m []byte, _ uint64) {
+					if num == 2 {
+						metric(m)
+					}
+				})
+			}
+		})
+	})
+	sort.Strings(points)
+	return resource, points
+}
+
+func TestOTLPExporter(t *testing.T) {
+	r := prometheus.NewRegistry()
+	cpu := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "node_cpu_seconds_total", Help: "CPU."}, []string{"cpu", "mode"})
+	cpu.WithLabelValues("0", "idle").Add(3)
+	load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "Load."})
+	load.Set(0.5)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Durations.", Buckets: []float64{1, 2}})
+	h.Observe(0.5)
+	h.Observe(3)
+	s := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_size_bytes", Help: "Sizes.", Objectives: map[float64]float64{0.5: 0.05}})
+	s.Observe(4)
+	r.MustRegister(cpu, load, h, s)
+
+	start, now := time.Unix(100, 0), time.Unix(200, 0)
+	wantResource := map[string]string{"host.name": "node1", "host.id": "0123", "service.name": "node_exporter"}
+	wantPoints := []string{
+		"gauge node_load1{} 0.5",
+		"histogram test_duration_seconds{} count=2 sum=3.5 buckets=[1 0 1] bounds=[1 2]",
+		"sum,monotonic node_cpu_seconds_total{cpu=0,mode=idle} 3",
+		"summary test_size_bytes{} count=1 sum=4 q0.5=4",
+	}
+
+	for _, tc := range []struct {
+		protocol string
+		path     string
+		handler  func(w http.ResponseWriter, body []byte) []byte
+	}{
+		{
+			protocol: otlpProtocolHTTP,
+			path:     "/v1/metrics",
+			handler: func(w http.ResponseWriter, body []byte) []byte {
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				return body
+			},
+		},
+		{
+			protocol: otlpProtocolGRPC,
+			path:     otlpGRPCMethod,
+			handler: func(w http.ResponseWriter, body []byte) []byte {
+				if body[0] != 0 || int(body[1])<<24|int(body[2])<<16|int(body[3])<<8|int(body[4]) != len(body)-5 {
+					t.Errorf("want uncompressed length prefixed message, got prefix %v", body[:5])
+				}
+				w.Header().Set("Content-Type", "application/grpc")
+				w.Write([]byte{0, 0, 0, 0, 0})
+				w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+				return body[5:]
+			},
+		},
+	} {
+		var requests [][]byte
+		server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != tc.path {
+				t.Errorf("%s: want path %q, got %q", tc.protocol, tc.path, r.URL.Path)
+			}
+			if want, got := "secret", r.Header.Get("Authorization"); want != got {
+				t.Errorf("%s: want Authorization %q, got %q", tc.protocol, want, got)
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			requests = append(requests, tc.handler(w, body))
+		}), &http2.Server{}))
+
+		endpoint := server.URL
+		if tc.protocol == otlpProtocolHTTP {
+			endpoint += tc.path
+		}
+		cfg := otlpConfig{
+			endpoint: endpoint,
+			protocol: tc.protocol,
+			timeout:  time.Second,
+			headers:  http.Header{"Authorization": {"secret"}},
+			resource: wantResource,
+		}
+		e, err := newOTLPExporter(cfg, r, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.start = start
+		e.now = func() time.Time { return now }
+		if err := e.export(context.Background()); err != nil {
+			t.Fatalf("%s: %s", tc.protocol, err)
+		}
+		server.Close()
+
+		if len(requests) != 1 {
+			t.Fatalf("%s: want 1 request, got %d", tc.protocol, len(requests))
+		}
+		resource, points := decodeExportRequest(t, requests[0], start, now)
+		if !reflect.DeepEqual(wantResource, resource) {
+			t.Errorf("%s: want resource %q, got %q", tc.protocol, wantResource, resource)
+		}
+		if !reflect.DeepEqual(wantPoints, points) {
+			t.Errorf("%s: want %q, got %q", tc.protocol, wantPoints, points)
+		}
+		if want, got := 4.0, testutil.ToFloat64(e.exported); want != got {
+			t.Errorf("%s: want %g data points exported, got %g", tc.protocol, want, got)
+		}
+	}
+}
+
+func TestOTLPExporterGRPCError(t *testing.T) {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "14")
+		w.Header().Set("Grpc-Message", "collector%20unavailable")
+	}), &http2.Server{}))
+	defer server.Close()
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "Load."}))
+	e, err := newOTLPExporter(otlpConfig{endpoint: server.URL, protocol: otlpProtocolGRPC, timeout: time.Second}, r, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.export(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "collector unavailable") {
+		t.Errorf("want gRPC error, got %v", err)
+	}
+	if want, got := 1.0, testutil.ToFloat64(e.failed); want != got {
+		t.Errorf("want %g data points failed, got %g", want, got)
+	}
+}
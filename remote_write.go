@@ -0,0 +1,518 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/version"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	remoteWriteURL            = kingpin.Flag("remote-write.url", "URL of a Prometheus remote write endpoint to push the metrics to, in addition to serving them.").Default("").String()
+	remoteWriteInterval       = kingpin.Flag("remote-write.interval", "Interval between two pushes of the metrics.").Default("15s").Duration()
+	remoteWriteTimeout        = kingpin.Flag("remote-write.timeout", "Timeout of a remote write request.").Default("30s").Duration()
+	remoteWriteExternalLabels = kingpin.Flag("remote-write.external-label", "Label added to all pushed series as name=value, unless they have it already. Can be repeated.").Strings()
+	remoteWriteQueueDir       = kingpin.Flag("remote-write.queue-dir", "Directory buffering the requests not sent yet, so they survive restarts. Requests are buffered in memory if empty.").Default("").String()
+	remoteWriteQueueMaxBytes  = kingpin.Flag("remote-write.queue-max-bytes", "Maximum size of the buffered requests, the oldest ones are dropped beyond it.").Default("64MiB").Bytes()
+)
+
+const (
+	remoteWriteMinBackoff = time.Second
+	remoteWriteMaxBackoff = time.Minute
+)
+
+// remoteWriteConfig is the configuration of a remoteWriter.
+type remoteWriteConfig struct {
+	url            string
+	interval       time.Duration
+	timeout        time.Duration
+	externalLabels map[string]string
+	queueDir       string
+	queueMaxBytes  int64
+}
+
+func remoteWriteConfigFromFlags() (remoteWriteConfig, error) {
+	cfg := remoteWriteConfig{
+		url:            *remoteWriteURL,
+		interval:       *remoteWriteInterval,
+		timeout:        *remoteWriteTimeout,
+		externalLabels: map[string]string{},
+		queueDir:       *remoteWriteQueueDir,
+		queueMaxBytes:  int64(*remoteWriteQueueMaxBytes),
+	}
+	for _, l := range *remoteWriteExternalLabels {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok || !labelNameRE.MatchString(name) {
+			return cfg, fmt.Errorf("invalid external label %q, want name=value", l)
+		}
+		cfg.externalLabels[name] = value
+	}
+	return cfg, nil
+}
+
+// remoteWriter periodically gathers the metrics and pushes them with the
+// Prometheus remote write protocol. Requests failing with recoverable errors
+// are retried with a backoff, they are buffered in a bounded queue meanwhile.
+type remoteWriter struct {
+	cfg      remoteWriteConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	queue    *remoteWriteQueue
+	logger   log.Logger
+	now      func() time.Time
+	// notify wakes up the sender after a request is queued.
+	notify chan struct{}
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	sent    prometheus.Counter
+	failed  prometheus.Counter
+	pending prometheus.GaugeFunc
+}
+
+// recoverableError is an error a request can be retried after.
+type recoverableError struct {
+	error
+}
+
+func newRemoteWriter(cfg remoteWriteConfig, g prometheus.Gatherer, logger log.Logger) (*remoteWriter, error) {
+	queue, dropped, err := openRemoteWriteQueue(cfg.queueDir, cfg.queueMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if dropped > 0 {
+		level.Warn(logger).Log("msg", "Dropped queued remote write samples beyond the queue size limit", "samples", dropped)
+	}
+	w := &remoteWriter{
+		cfg:        cfg,
+		gatherer:   g,
+		client:     &http.Client{Timeout: cfg.timeout},
+		queue:      queue,
+		logger:     logger,
+		now:        time.Now,
+		notify:     make(chan struct{}, 1),
+		minBackoff: remoteWriteMinBackoff,
+		maxBackoff: remoteWriteMaxBackoff,
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "node_exporter",
+			Name:      "remote_write_samples_sent_total",
+			Help:      "Number of samples pushed to the remote write endpoint.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "node_exporter",
+			Name:      "remote_write_samples_failed_total",
+			Help:      "Number of samples dropped because they were rejected by the remote write endpoint or didn't fit into the queue.",
+		}),
+	}
+	w.pending = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "node_exporter",
+		Name:      "remote_write_samples_pending",
+		Help:      "Number of samples queued for the remote write endpoint.",
+	}, func() float64 { return float64(w.queue.pendingSamples()) })
+	w.failed.Add(float64(dropped))
+	return w, nil
+}
+
+// Describe implements prometheus.Collector.
+func (w *remoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	w.sent.Describe(ch)
+	w.failed.Describe(ch)
+	w.pending.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *remoteWriter) Collect(ch chan<- prometheus.Metric) {
+	w.sent.Collect(ch)
+	w.failed.Collect(ch)
+	w.pending.Collect(ch)
+}
+
+// run pushes the metrics every interval until ctx is done.
+func (w *remoteWriter) run(ctx context.Context) {
+	go w.sendLoop(ctx)
+	ticker := time.NewTicker(w.cfg.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.enqueue(); err != nil {
+			level.Error(w.logger).Log("msg", "Couldn't queue remote write request", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// enqueue gathers the metrics and queues them as one request.
+func (w *remoteWriter) enqueue() error {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		// Like the HTTP handler, push what was gathered.
+		level.Warn(w.logger).Log("msg", "Error gathering metrics for remote write", "err", err)
+	}
+	req, samples := encodeWriteRequest(mfs, w.cfg.externalLabels, w.now().UnixMilli())
+	if samples == 0 {
+		return nil
+	}
+	dropped, err := w.queue.push(snappy.Encode(nil, req), samples)
+	w.failed.Add(float64(dropped))
+	if err != nil {
+		return err
+	}
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// sendLoop sends the queued requests in order.
+func (w *remoteWriter) sendLoop(ctx context.Context) {
+	backoff := w.minBackoff
+	for {
+		e, err := w.queue.head()
+		if err != nil {
+			level.Error(w.logger).Log("msg", "Dropping unreadable remote write request", "err", err)
+			w.failed.Add(float64(e.samples))
+			w.queue.pop(e)
+			continue
+		}
+		if e == nil {
+			select {
+			case <-w.notify:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err = w.send(ctx, e.data)
+		var recoverable recoverableError
+		switch {
+		case err == nil:
+			w.sent.Add(float64(e.samples))
+			w.queue.pop(e)
+			backoff = w.minBackoff
+		case errors.As(err, &recoverable):
+			level.Warn(w.logger).Log("msg", "Failed to push metrics, retrying", "err", err, "backoff", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > w.maxBackoff {
+				backoff = w.maxBackoff
+			}
+		default:
+			level.Error(w.logger).Log("msg", "Remote write endpoint rejected metrics, dropping them", "err", err, "samples", e.samples)
+			w.failed.Add(float64(e.samples))
+			w.queue.pop(e)
+		}
+	}
+}
+
+// send posts one compressed request. Network errors, server errors and rate
+// limiting are recoverable.
+func (w *remoteWriter) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "node_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// encodeWriteRequest returns the WriteRequest protobuf of the metric
+// families and its number of samples. Histograms and summaries are split
+// into their classic series.
+func encodeWriteRequest(mfs []*dto.MetricFamily, externalLabels map[string]string, now int64) ([]byte, int) {
+	var (
+		req     []byte
+		series  []byte
+		samples int
+	)
+	add := func(name string, m *dto.Metric, value float64, extra ...string) {
+		labels := make(map[string]string, len(m.GetLabel())+len(externalLabels)+2)
+		for n, v := range externalLabels {
+			labels[n] = v
+		}
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			labels[extra[i]] = extra[i+1]
+		}
+		labels["__name__"] = name
+		names := make([]string, 0, len(labels))
+		for n := range labels {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		ts := now
+		if m.TimestampMs != nil {
+			ts = m.GetTimestampMs()
+		}
+		series = series[:0]
+		// Fields with zero values are left out like proto3 encoders do, so
+		// requests are byte for byte the ones prompb produces.
+		for _, n := range names {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, n)
+			if labels[n] != "" {
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, labels[n])
+			}
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, label)
+		}
+		var sample []byte
+		if bits := math.Float64bits(value); bits != 0 {
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, bits)
+		}
+		if ts != 0 {
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(ts))
+		}
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+		samples++
+	}
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, m, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", m, s.GetSampleSum())
+				add(name+"_count", m, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add(name+"_bucket", m, float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				if !infSeen {
+					add(name+"_bucket", m, float64(h.GetSampleCount()), "le", "+Inf")
+				}
+				add(name+"_sum", m, h.GetSampleSum())
+				add(name+"_count", m, float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return req, samples
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// remoteWriteQueue is a bounded FIFO of compressed requests. If it has a
+// directory, each request is stored in a file named after its sequence number
+// and number of samples, otherwise the requests are kept in memory.
+type remoteWriteQueue struct {
+	dir      string
+	maxBytes int64
+
+	mtx     sync.Mutex
+	entries []*remoteWriteEntry
+	size    int64
+	seq     uint64
+}
+
+type remoteWriteEntry struct {
+	seq     uint64
+	samples int
+	size    int64
+	// data is only kept in memory without a directory.
+	data []byte
+}
+
+func (e *remoteWriteEntry) name() string {
+	return fmt.Sprintf("%020d-%d.rw", e.seq, e.samples)
+}
+
+// openRemoteWriteQueue opens the queue in dir, with the requests left by a
+// previous run. The oldest requests beyond the size limit, which may have
+// been lowered since, are dropped and their number of samples returned.
+func openRemoteWriteQueue(dir string, maxBytes int64) (*remoteWriteQueue, int, error) {
+	q := &remoteWriteQueue{dir: dir, maxBytes: maxBytes}
+	if dir == "" {
+		return q, 0, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, 0, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, f := range files {
+		var e remoteWriteEntry
+		if _, err := fmt.Sscanf(f.Name(), "%d-%d.rw", &e.seq, &e.samples); err != nil || e.name() != f.Name() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, 0, err
+		}
+		e.size = info.Size()
+		q.entries = append(q.entries, &e)
+		q.size += e.size
+		if e.seq >= q.seq {
+			q.seq = e.seq + 1
+		}
+	}
+	// ReadDir sorts by name, the zero padded sequence numbers keep the order.
+	return q, q.trim(), nil
+}
+
+// push appends a request and drops the oldest ones beyond the size limit. It
+// returns the number of samples dropped.
+func (q *remoteWriteQueue) push(data []byte, samples int) (int, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	e := &remoteWriteEntry{seq: q.seq, samples: samples, size: int64(len(data))}
+	if q.dir == "" {
+		e.data = data
+	} else if err := os.WriteFile(filepath.Join(q.dir, e.name()), data, 0o600); err != nil {
+		return samples, err
+	}
+	q.seq++
+	q.entries = append(q.entries, e)
+	q.size += e.size
+	return q.trim(), nil
+}
+
+// trim drops the oldest requests beyond the size limit, but always keeps the
+// newest one. It returns the number of samples dropped.
+func (q *remoteWriteQueue) trim() int {
+	dropped := 0
+	for q.size > q.maxBytes && len(q.entries) > 1 {
+		dropped += q.entries[0].samples
+		q.remove(q.entries[0])
+	}
+	return dropped
+}
+
+// head returns the oldest request, or nil if the queue is empty. On errors
+// the entry is returned so it can be dropped.
+func (q *remoteWriteQueue) head() (*remoteWriteEntry, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.entries) == 0 {
+		return nil, nil
+	}
+	e := q.entries[0]
+	if q.dir == "" {
+		return e, nil
+	}
+	data, err := os.ReadFile(filepath.Join(q.dir, e.name()))
+	if err != nil {
+		return e, err
+	}
+	return &remoteWriteEntry{seq: e.seq, samples: e.samples, size: e.size, data: data}, nil
+}
+
+// pop removes a request returned by head, unless it was dropped meanwhile.
+func (q *remoteWriteQueue) pop(e *remoteWriteEntry) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.entries) > 0 && q.entries[0].seq == e.seq {
+		q.remove(q.entries[0])
+	}
+}
+
+func (q *remoteWriteQueue) remove(e *remoteWriteEntry) {
+	if q.dir != "" {
+		os.Remove(filepath.Join(q.dir, e.name()))
+	}
+	q.entries = q.entries[1:]
+	q.size -= e.size
+}
+
+func (q *remoteWriteQueue) pendingSamples() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	n := 0
+	for _, e := range q.entries {
+		n += e.samples
+	}
+	return n
+}
@@ -0,0 +1,312 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The reference encoding in TestEncodeWriteRequestReference was produced with
+// the prompb WriteRequest schema marshalled by google.golang.org/protobuf, so
+// a bug shared by the encoder and the decoder of the tests doesn't go
+// unnoticed.
+func TestEncodeWriteRequestReference(t *testing.T) {
+	r := prometheus.NewRegistry()
+	cpu := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "node_cpu_seconds_total", Help: "CPU."}, []string{"cpu", "mode"})
+	cpu.WithLabelValues("0", "idle").Add(12.5)
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_up", Help: "Up."})
+	r.MustRegister(cpu, up)
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, samples := encodeWriteRequest(mfs, map[string]string{"instance": "a"}, 1700000000000)
+	if samples != 2 {
+		t.Errorf("want 2 samples, got %d", samples)
+	}
+	// The sample of node_up has no value field, proto3 leaves out zeros.
+	want := "0a5d0a220a085f5f6e616d655f5f12166e6f64655f6370755f7365636f6e64735f746f74616c0a080a036370751201300a0d0a08696e7374616e63651201610a0c0a046d6f6465120469646c6512100900000000000029401080d095ffbc31" +
+		"0a2d0a130a085f5f6e616d655f5f12076e6f64655f75700a0d0a08696e7374616e636512016112071080d095ffbc31"
+	if got := hex.EncodeToString(req); got != want {
+		t.Errorf("want the prompb encoding\n%s\ngot\n%s", want, got)
+	}
+}
+
+// forEachProtoField calls f with the number and value of each field of the
+// protobuf message b, v for length delimited fields and u for the others.
+func forEachProtoField(t *testing.T, b []byte, f func(num protowire.Number, v []byte, u uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			f(num, v, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f(num, nil, v)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			f(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
+
+// decodeWriteRequest returns the series of a snappy compressed WriteRequest
+// as "name{labels} value @timestamp", sorted.
+func decodeWriteRequest(t *testing.T, data []byte) []string {
+	req, err := snappy.Decode(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series []string
+	forEachProtoField(t, req, func(_ protowire.Number, ts []byte, _ uint64) {
+		var labels []string
+		var sample string
+		forEachProtoField(t, ts, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				var name, value string
+				forEachProtoField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				labels = append(labels, name+"="+value)
+			case 2:
+				var value float64
+				var ts int64
+				forEachProtoField(t, v, func(num protowire.Number, _ []byte, u uint64) {
+					if num == 1 {
+						value = math.Float64frombits(u)
+					} else {
+						ts = int64(u)
+					}
+				})
+				sample = fmt.Sprintf("%g @%d", value, ts)
+			}
+		})
+		series = append(series, "{"+strings.Join(labels, ",")+"} "+sample)
+	})
+	sort.Strings(series)
+	return series
+}
+
+// remoteWriteReceiver records the requests it accepts, failing the first
+// ones with the given statuses.
+type remoteWriteReceiver struct {
+	t        *testing.T
+	mtx      sync.Mutex
+	statuses []int
+	requests [][]string
+	received chan struct{}
+}
+
+func (rr *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if want, got := "snappy", r.Header.Get("Content-Encoding"); want != got {
+		rr.t.Errorf("want Content-Encoding %q, got %q", want, got)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rr.t.Error(err)
+	}
+	rr.mtx.Lock()
+	defer rr.mtx.Unlock()
+	if len(rr.statuses) > 0 {
+		w.WriteHeader(rr.statuses[0])
+		rr.statuses = rr.statuses[1:]
+		return
+	}
+	rr.requests = append(rr.requests, decodeWriteRequest(rr.t, body))
+	rr.received <- struct{}{}
+}
+
+func TestRemoteWriter(t *testing.T) {
+	r := prometheus.NewRegistry()
+	load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "Load."})
+	load.Set(0.5)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Durations.", Buckets: []float64{1}})
+	h.Observe(0.5)
+	h.Observe(2)
+	r.MustRegister(load, h)
+
+	rr := &remoteWriteReceiver{t: t, statuses: []int{http.StatusInternalServerError, http.StatusBadRequest}, received: make(chan struct{}, 10)}
+	server := httptest.NewServer(rr)
+	defer server.Close()
+
+	cfg := remoteWriteConfig{
+		url:            server.URL,
+		interval:       time.Hour,
+		timeout:        time.Second,
+		externalLabels: map[string]string{"instance": "node1"},
+		queueDir:       t.TempDir(),
+		queueMaxBytes:  1 << 20,
+	}
+	w, err := newRemoteWriter(cfg, r, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = func() time.Time { return time.UnixMilli(1000) }
+	w.minBackoff = time.Millisecond
+
+	// The first request fails with a server error and is retried, the
+	// second is rejected and dropped.
+	if err := w.enqueue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.enqueue(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.sendLoop(ctx)
+	select {
+	case <-rr.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the remote write request")
+	}
+
+	want := []string{
+		"{__name__=node_load1,instance=node1} 0.5 @1000",
+		"{__name__=test_duration_seconds_bucket,instance=node1,le=+Inf} 2 @1000",
+		"{__name__=test_duration_seconds_bucket,instance=node1,le=1} 1 @1000",
+		"{__name__=test_duration_seconds_count,instance=node1} 2 @1000",
+		"{__name__=test_duration_seconds_sum,instance=node1} 2.5 @1000",
+	}
+	rr.mtx.Lock()
+	if got := rr.requests[0]; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	rr.mtx.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(w.pending) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if want, got := 5.0, testutil.ToFloat64(w.sent); want != got {
+		t.Errorf("want %g samples sent, got %g", want, got)
+	}
+	if want, got := 5.0, testutil.ToFloat64(w.failed); want != got {
+		t.Errorf("want %g samples failed, got %g", want, got)
+	}
+}
+
+func TestRemoteWriteQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, _, err := openRemoteWriteQueue(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []string{"aaaa", "bbbb", "cccc"} {
+		dropped, err := q.push([]byte(data), i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The third request exceeds the limit and drops the first one.
+		if want := map[int]int{2: 1}[i]; want != dropped {
+			t.Errorf("%d: want %d samples dropped, got %d", i, want, dropped)
+		}
+	}
+
+	// The remaining requests survive a restart, in order.
+	q, _, err = openRemoteWriteQueue(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 5, q.pendingSamples(); want != got {
+		t.Errorf("want %d samples pending, got %d", want, got)
+	}
+	for _, want := range []string{"bbbb", "cccc"} {
+		e, err := q.head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(e.data); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+		q.pop(e)
+	}
+	if e, _ := q.head(); e != nil {
+		t.Errorf("want empty queue, got %q", e.data)
+	}
+	if _, err := q.push([]byte("dddd"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := q.head(); e == nil || e.seq != 3 {
+		t.Errorf("want sequence numbers to continue after a restart, got %v", e)
+	}
+}
+
+func TestRemoteWriteQueueTrimOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	q, _, err := openRemoteWriteQueue(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range []string{"aaaa", "bbbb", "cccc"} {
+		if _, err := q.push([]byte(data), i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A lower limit after a restart drops the oldest requests.
+	q, dropped, err := openRemoteWriteQueue(dir, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, dropped; want != got {
+		t.Errorf("want %d samples dropped, got %d", want, got)
+	}
+	if want, got := 3, q.pendingSamples(); want != got {
+		t.Errorf("want %d samples pending, got %d", want, got)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(files); want != got {
+		t.Errorf("want %d queue files left, got %d", want, got)
+	}
+}
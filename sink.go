@@ -0,0 +1,316 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	countersCumulative = "cumulative"
+	countersDelta      = "delta"
+)
+
+// sinkFlags are the flags shared by the line protocol sinks.
+type sinkFlags struct {
+	interval *time.Duration
+	timeout  *time.Duration
+	prefix   *string
+	tags     *[]string
+	counters *string
+}
+
+func registerSinkFlags(name string) sinkFlags {
+	return sinkFlags{
+		interval: kingpin.Flag(name+".interval", "Interval between two writes of the metrics.").Default("15s").Duration(),
+		timeout:  kingpin.Flag(name+".timeout", "Timeout of a write.").Default("10s").Duration(),
+		prefix:   kingpin.Flag(name+".prefix", "Prefix of the written metric names.").Default("").String(),
+		tags:     kingpin.Flag(name+".tag", "Maps a label to a tag as label=tag, or drops it as label=. Can be repeated.").Strings(),
+		counters: kingpin.Flag(name+".counters", "How counters are written, either as their cumulative values or as the delta since the previous write, one of [cumulative, delta].").Default(countersCumulative).Enum(countersCumulative, countersDelta),
+	}
+}
+
+// sinkConfig is the configuration shared by the line protocol sinks.
+type sinkConfig struct {
+	interval time.Duration
+	timeout  time.Duration
+	prefix   string
+	// tags maps label names to tag names, empty to drop the label.
+	tags     map[string]string
+	counters string
+}
+
+func (f sinkFlags) config() (sinkConfig, error) {
+	cfg := sinkConfig{
+		interval: *f.interval,
+		timeout:  *f.timeout,
+		prefix:   *f.prefix,
+		tags:     map[string]string{},
+		counters: *f.counters,
+	}
+	for _, t := range *f.tags {
+		label, tag, ok := strings.Cut(t, "=")
+		if !ok || !labelNameRE.MatchString(label) {
+			return cfg, fmt.Errorf("invalid tag mapping %q, want label=tag", t)
+		}
+		cfg.tags[label] = tag
+	}
+	return cfg, nil
+}
+
+// sinkPoint is a sample of a metric family, with the fields of histograms
+// and summaries grouped together.
+type sinkPoint struct {
+	name string
+	// tags are the labels after mapping, sorted by name.
+	tags   [][2]string
+	fields []sinkField
+	time   time.Time
+}
+
+// sinkField is a value of a sinkPoint. The Prometheus series it comes from
+// is the name of the point plus suffix, with the extra label if any.
+type sinkField struct {
+	key        string
+	suffix     string
+	extraLabel string
+	value      float64
+	// cumulative is set for the values of counters, histograms and
+	// summaries that only increase until a reset.
+	cumulative bool
+}
+
+// sinkPoints converts the metric families to points, mapping the labels to
+// tags. Non-finite values are dropped, neither Influx nor Graphite support
+// them.
+func sinkPoints(mfs []*dto.MetricFamily, tags map[string]string, now time.Time) []sinkPoint {
+	var points []sinkPoint
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			p := sinkPoint{name: mf.GetName(), time: now}
+			if m.TimestampMs != nil {
+				p.time = time.UnixMilli(m.GetTimestampMs())
+			}
+			for _, l := range m.GetLabel() {
+				name, ok := tags[l.GetName()]
+				if !ok {
+					name = l.GetName()
+				}
+				if name != "" && l.GetValue() != "" {
+					p.tags = append(p.tags, [2]string{name, l.GetValue()})
+				}
+			}
+			sort.Slice(p.tags, func(i, j int) bool { return p.tags[i][0] < p.tags[j][0] })
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				p.fields = []sinkField{{key: "value", value: m.GetCounter().GetValue(), cumulative: true}}
+			case dto.MetricType_GAUGE:
+				p.fields = []sinkField{{key: "value", value: m.GetGauge().GetValue()}}
+			case dto.MetricType_UNTYPED:
+				p.fields = []sinkField{{key: "value", value: m.GetUntyped().GetValue()}}
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				p.fields = []sinkField{
+					{key: "count", suffix: "_count", value: float64(s.GetSampleCount()), cumulative: true},
+					{key: "sum", suffix: "_sum", value: s.GetSampleSum(), cumulative: true},
+				}
+				for _, q := range s.GetQuantile() {
+					p.fields = append(p.fields, sinkField{key: formatFloat(q.GetQuantile()), extraLabel: "quantile", value: q.GetValue()})
+				}
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				cumulative := mf.GetType() == dto.MetricType_HISTOGRAM
+				p.fields = []sinkField{
+					{key: "count", suffix: "_count", value: float64(h.GetSampleCount()), cumulative: cumulative},
+					{key: "sum", suffix: "_sum", value: h.GetSampleSum(), cumulative: cumulative},
+				}
+				infSeen := false
+				for _, b := range h.GetBucket() {
+					infSeen = infSeen || math.IsInf(b.GetUpperBound(), 1)
+					p.fields = append(p.fields, sinkField{key: formatFloat(b.GetUpperBound()), suffix: "_bucket", extraLabel: "le", value: float64(b.GetCumulativeCount()), cumulative: cumulative})
+				}
+				if !infSeen {
+					p.fields = append(p.fields, sinkField{key: "+Inf", suffix: "_bucket", extraLabel: "le", value: float64(h.GetSampleCount()), cumulative: cumulative})
+				}
+			}
+
+			fields := p.fields[:0]
+			for _, f := range p.fields {
+				if !math.IsNaN(f.value) && !math.IsInf(f.value, 0) {
+					fields = append(fields, f)
+				}
+			}
+			if p.fields = fields; len(fields) > 0 {
+				points = append(points, p)
+			}
+		}
+	}
+	return points
+}
+
+func (p sinkPoint) key(f sinkField) string {
+	var b strings.Builder
+	b.WriteString(p.name)
+	for _, t := range p.tags {
+		b.WriteString("\xff" + t[0] + "\xff" + t[1])
+	}
+	b.WriteString("\xfe" + f.key)
+	return b.String()
+}
+
+// deltaTracker turns cumulative values into the delta since the previous
+// write. Values seen for the first time have no delta and are skipped, a
+// value lower than the previous one is a reset and written as is.
+type deltaTracker struct {
+	last map[string]float64
+}
+
+func (d *deltaTracker) apply(points []sinkPoint) []sinkPoint {
+	last := make(map[string]float64, len(d.last))
+	result := points[:0]
+	for _, p := range points {
+		fields := p.fields[:0]
+		for _, f := range p.fields {
+			if !f.cumulative {
+				fields = append(fields, f)
+				continue
+			}
+			key := p.key(f)
+			prev, ok := d.last[key]
+			last[key] = f.value
+			if !ok {
+				continue
+			}
+			if f.value >= prev {
+				f.value -= prev
+			}
+			fields = append(fields, f)
+		}
+		if p.fields = fields; len(fields) > 0 {
+			result = append(result, p)
+		}
+	}
+	// Series gone since the previous write are forgotten.
+	d.last = last
+	return result
+}
+
+// pushSink periodically gathers the metrics and writes them as lines of a
+// text protocol.
+type pushSink struct {
+	name     string
+	cfg      sinkConfig
+	gatherer prometheus.Gatherer
+	// encode returns the lines of the points.
+	encode func(points []sinkPoint) [][]byte
+	// write writes the lines, within the timeout of ctx.
+	write  func(ctx context.Context, lines [][]byte) error
+	delta  *deltaTracker
+	logger log.Logger
+	now    func() time.Time
+
+	sent   prometheus.Counter
+	failed prometheus.Counter
+}
+
+func newPushSink(name string, cfg sinkConfig, g prometheus.Gatherer, logger log.Logger) *pushSink {
+	s := &pushSink{
+		name:     name,
+		cfg:      cfg,
+		gatherer: g,
+		logger:   logger,
+		now:      time.Now,
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "node_exporter",
+			Name:        "sink_lines_sent_total",
+			Help:        "Number of lines written by a push sink.",
+			ConstLabels: prometheus.Labels{"sink": name},
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "node_exporter",
+			Name:        "sink_lines_failed_total",
+			Help:        "Number of lines a push sink failed to write.",
+			ConstLabels: prometheus.Labels{"sink": name},
+		}),
+	}
+	if cfg.counters == countersDelta {
+		s.delta = &deltaTracker{}
+	}
+	return s
+}
+
+// Describe implements prometheus.Collector.
+func (s *pushSink) Describe(ch chan<- *prometheus.Desc) {
+	s.sent.Describe(ch)
+	s.failed.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *pushSink) Collect(ch chan<- prometheus.Metric) {
+	s.sent.Collect(ch)
+	s.failed.Collect(ch)
+}
+
+// run writes the metrics every interval until ctx is done.
+func (s *pushSink) run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.push(ctx); err != nil {
+			level.Error(s.logger).Log("msg", "Failed to write metrics", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// push gathers and writes the metrics once.
+func (s *pushSink) push(ctx context.Context) error {
+	mfs, err := s.gatherer.Gather()
+	if err != nil {
+		// Like the HTTP handler, write what was gathered.
+		level.Warn(s.logger).Log("msg", "Error gathering metrics for sink", "err", err)
+	}
+	points := sinkPoints(mfs, s.cfg.tags, s.now())
+	if s.delta != nil {
+		points = s.delta.apply(points)
+	}
+	lines := s.encode(points)
+	if len(lines) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.timeout)
+	defer cancel()
+	if err := s.write(ctx, lines); err != nil {
+		s.failed.Add(float64(len(lines)))
+		return err
+	}
+	s.sent.Add(float64(len(lines)))
+	return nil
+}
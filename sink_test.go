@@ -0,0 +1,94 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newSinkTestRegistry returns a registry with a metric of each type, and the
+// counter to increment it.
+func newSinkTestRegistry() (*prometheus.Registry, prometheus.Counter) {
+	r := prometheus.NewRegistry()
+	cpu := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "node_cpu_seconds_total", Help: "CPU."}, []string{"cpu", "mode"})
+	cpu.WithLabelValues("0", "idle").Add(3)
+	load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "Load."})
+	load.Set(0.5)
+	nan := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_nan", Help: "Not a number."})
+	nan.Set(math.NaN())
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "Durations.", Buckets: []float64{1}})
+	h.Observe(0.5)
+	h.Observe(2)
+	r.MustRegister(cpu, load, nan, h)
+	return r, cpu.WithLabelValues("0", "idle")
+}
+
+func TestSinkPoints(t *testing.T) {
+	r, cpu := newSinkTestRegistry()
+	describe := func(points []sinkPoint) []string {
+		var s []string
+		for _, p := range points {
+			for _, f := range p.fields {
+				s = append(s, fmt.Sprintf("%s%v %s=%g", p.name, p.tags, f.key, f.value))
+			}
+		}
+		return s
+	}
+	now := time.Unix(1000, 0)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := sinkPoints(mfs, map[string]string{"cpu": "core", "mode": ""}, now)
+	want := []string{
+		"node_cpu_seconds_total[[core 0]] value=3",
+		"node_load1[] value=0.5",
+		"test_duration_seconds[] count=2",
+		"test_duration_seconds[] sum=2.5",
+		"test_duration_seconds[] 1=1",
+		"test_duration_seconds[] +Inf=2",
+	}
+	if got := describe(points); !reflect.DeepEqual(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// Cumulative values are written as deltas from the second write on.
+	d := &deltaTracker{}
+	if got := describe(d.apply(points)); !reflect.DeepEqual([]string{"node_load1[] value=0.5"}, got) {
+		t.Errorf("want only the gauge on the first write, got %q", got)
+	}
+	cpu.Add(2)
+	mfs, err = r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"node_cpu_seconds_total[[core 0]] value=2",
+		"node_load1[] value=0.5",
+		"test_duration_seconds[] count=0",
+		"test_duration_seconds[] sum=0",
+		"test_duration_seconds[] 1=0",
+		"test_duration_seconds[] +Inf=0",
+	}
+	if got := describe(d.apply(sinkPoints(mfs, map[string]string{"cpu": "core", "mode": ""}, now))); !reflect.DeepEqual(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}